@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// agenda prints the open items due today or overdue, most urgent first,
+// and nothing if there are none, so cron doesn't mail empty reports.
+// It exits with exitOverdue if any item is overdue.
+func agenda() {
+	fs := flag.NewFlagSet("agenda", flag.ExitOnError)
+	short := fs.Bool("short", false, "Print a single line with the number of overdue and due items, e.g. for status bars")
+	fs.BoolVar(&noColor, "no-color", false, "Don't color overdue and due items")
+	fs.Parse(flag.Args()[1:])
+
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+
+	req := request("GET")
+	q := req.URL.Query()
+	q.Set("done", "false")
+	q.Set("due_before", tomorrow.Format(time.RFC3339))
+	q.Set("sort", "due")
+	req.URL.RawQuery = q.Encode()
+	resp := do(req)
+	defer resp.Body.Close()
+
+	var col []*todow.Item
+	if err := json.NewDecoder(resp.Body).Decode(&col); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+
+	var overdue int
+	for _, v := range col {
+		if v.Due.Before(now) {
+			overdue++
+		}
+	}
+
+	switch {
+	case len(col) == 0:
+	case *short:
+		fmt.Printf("%d overdue, %d due today\n", overdue, len(col)-overdue)
+	default:
+		printAgenda(col, now)
+	}
+
+	if overdue > 0 {
+		os.Exit(exitOverdue)
+	}
+}
+
+// printAgenda prints one line per item of col, which are all due.
+func printAgenda(col []*todow.Item, now time.Time) {
+	color := useColor()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, v := range col {
+		when := v.Due.Local().Format("15:04")
+		state := "today"
+		if v.Due.Before(now) {
+			when = relTime(v.Due, now)
+			state = "overdue"
+		}
+
+		body := v.Body
+		if v.Priority != "" {
+			body = "(" + v.Priority + ") " + body
+		}
+
+		if color {
+			fmt.Fprint(tw, rowColor(v, now))
+		}
+		fmt.Fprintf(tw, "%s\t#%d\t%s\t%s", state, v.ID, when, body)
+		if color {
+			fmt.Fprint(tw, colorReset)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
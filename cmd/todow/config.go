@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	configFile = flag.String("config", defaultConfigFile(), "TOML file with server profiles")
+	profile    = flag.String("profile", os.Getenv("TODOW_PROFILE"), "Profile of the config file to use, $TODOW_PROFILE by default")
+)
+
+// config is the content of the config file, e.g.
+//
+//	profile = "home"
+//
+//	[profiles.home]
+//	url = "http://todo.home:9999"
+//	user = "me"
+//	password = "secret"
+//
+//	[profiles.work]
+//	url = "https://todo.example.com"
+//	user = "me"
+//	list = "team/sprint"
+//
+//	[profiles.laptop]
+//	local = true
+//	db = "/home/me/todo.txt"
+//	store = "todotxt"
+//
+// todow serve reads its flags from the [server] table, e.g.
+//
+//	[server]
+//	addr = ":9999"
+//	store = "postgres"
+type config struct {
+	// Profile names the profile used without -profile.
+	Profile  string
+	Profiles map[string]struct {
+		URL      string
+		User     string
+		Password string
+		List     string
+
+		Local bool
+		Store string
+		DB    string
+	}
+}
+
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "todow", "config.toml")
+}
+
+// configure sets the flags not given on the command line from the
+// selected profile of the config file. $TODOW_PASSWORD takes precedence
+// over the password of the profile.
+func configure() error {
+	var conf config
+	_, err := toml.DecodeFile(*configFile, &conf)
+	if os.IsNotExist(err) && *profile == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	name := *profile
+	if name == "" {
+		name = conf.Profile
+	}
+	if name == "" && len(conf.Profiles) == 0 {
+		return nil
+	}
+	if name == "" {
+		if len(conf.Profiles) > 1 {
+			return fmt.Errorf("%s has several profiles, select one with -profile or set profile", *configFile)
+		}
+		for n := range conf.Profiles {
+			name = n
+		}
+	}
+	p, ok := conf.Profiles[name]
+	if !ok {
+		return fmt.Errorf("no profile %q in %s", name, *configFile)
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if _, ok := os.LookupEnv("TODOW_PASSWORD"); ok {
+		set["p"] = true
+	}
+
+	if p.Local && !set["local"] {
+		flag.Set("local", "true")
+	}
+	for name, v := range map[string]string{"h": p.URL, "u": p.User, "p": p.Password, "l": p.List, "store": p.Store, "db": p.DB} {
+		if v != "" && !set[name] {
+			flag.Set(name, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// frontMatterEnd separates the fields of an item edited in an editor
+// from its body, notes and checklist.
+const frontMatterEnd = "---"
+
+func editItem() {
+	if len(flag.Args()) < 2 {
+		printErrLn("Missing item id")
+	}
+
+	id := flag.Args()[1]
+	if len(flag.Args()) == 2 {
+		editInEditor(id)
+		return
+	}
+
+	var patch todow.ItemPatch
+	body, tags := todow.ParseTags(flag.Args()[2:])
+	if body != "" {
+		patch.Body = &body
+	}
+	if len(tags) > 0 {
+		patch.Tags = &tags
+	}
+
+	sendPatch(id, &patch)
+}
+
+// setNotes replaces the notes of an item with the arguments following
+// its id, or with stdin if the only one is "-".
+func setNotes() {
+	if len(flag.Args()) < 2 {
+		printErrLn("Missing item id")
+	}
+
+	notes := strings.Join(flag.Args()[2:], " ")
+	if notes == "-" {
+		p, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			printErrLn("Unable to read stdin: %s", err)
+		}
+		notes = strings.TrimSpace(string(p))
+	}
+	sendPatch(flag.Args()[1], &todow.ItemPatch{Notes: &notes})
+}
+
+// setMeta sets the metadata fields given as KEY=VALUE after the id of
+// an item, removing those with empty values.
+func setMeta() {
+	if len(flag.Args()) < 2 {
+		printErrLn("Missing item id")
+	}
+	if len(flag.Args()) < 3 {
+		printErrLn("Missing metadata field KEY=VALUE")
+	}
+
+	meta := parseMeta(flag.Args()[2:])
+	// Empty values remove fields, which parseMeta leaves out.
+	for _, arg := range flag.Args()[2:] {
+		if strings.HasSuffix(arg, "=") {
+			meta[strings.TrimSuffix(arg, "=")] = ""
+		}
+	}
+	sendPatch(flag.Args()[1], &todow.ItemPatch{Meta: meta})
+}
+
+// parseMeta parses metadata fields given as KEY=VALUE,
+// leaving out those with empty values.
+func parseMeta(args []string) map[string]string {
+	meta := map[string]string{}
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i < 0 || !todow.ValidMetaKey(arg[:i]) {
+			printErrLn("Invalid metadata field %q, use KEY=VALUE with a key of letters, digits, ., - and _", arg)
+		}
+		if arg[i+1:] != "" {
+			meta[arg[:i]] = arg[i+1:]
+		}
+	}
+	return meta
+}
+
+// blockItem adds the items given after the id of an item to those it
+// is blocked by.
+func blockItem() {
+	if len(flag.Args()) < 2 {
+		printErrLn("Missing item id")
+	}
+	if len(flag.Args()) < 3 {
+		printErrLn("Missing blocking item id")
+	}
+
+	id := flag.Args()[1]
+	blockers := fetchItem(id).BlockedBy
+	for _, b := range blockerIDs(flag.Args()[2:]) {
+		if !containsID(blockers, b) {
+			blockers = append(blockers, b)
+		}
+	}
+	sendPatch(id, &todow.ItemPatch{BlockedBy: &blockers})
+}
+
+// unblockItem removes the items given after the id of an item from
+// those it is blocked by, or all of them if none are given.
+func unblockItem() {
+	if len(flag.Args()) < 2 {
+		printErrLn("Missing item id")
+	}
+
+	id := flag.Args()[1]
+	blockers := []int64{}
+	if len(flag.Args()) > 2 {
+		remove := blockerIDs(flag.Args()[2:])
+		for _, b := range fetchItem(id).BlockedBy {
+			if !containsID(remove, b) {
+				blockers = append(blockers, b)
+			}
+		}
+	}
+	sendPatch(id, &todow.ItemPatch{BlockedBy: &blockers})
+}
+
+func blockerIDs(args []string) []int64 {
+	ids := make([]int64, len(args))
+	for i, arg := range args {
+		id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+		if err != nil {
+			printErrLn("Invalid item id %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEntries ticks, or unticks if done is false, the checklist
+// entries of an item given by their positions after its id.
+func checkEntries(done bool) {
+	if len(flag.Args()) < 2 {
+		printErrLn("Missing item id")
+	}
+	if len(flag.Args()) < 3 {
+		printErrLn("Missing checklist entry")
+	}
+
+	id := flag.Args()[1]
+	list := fetchItem(id).Checklist
+	for _, arg := range flag.Args()[2:] {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(list) {
+			printErrLn("Invalid checklist entry %q, item #%s has %d", arg, id, len(list))
+		}
+		list[n-1].Done = done
+	}
+	sendPatch(id, &todow.ItemPatch{Checklist: &list})
+}
+
+// editInEditor opens the item identified by id in the user's editor
+// and PATCHes the fields that were changed.
+func editInEditor(id string) {
+	item := fetchItem(id)
+
+	f, err := ioutil.TempFile("", "todow-*.txt")
+	if err != nil {
+		printErrLn("Unable to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	writeFrontMatter(f, item)
+	if err := f.Close(); err != nil {
+		printErrLn("Unable to write %s: %s", f.Name(), err)
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		printErrLn("Editor %s failed: %s", editor, err)
+	}
+
+	f, err = os.Open(f.Name())
+	if err != nil {
+		printErrLn("Unable to read %s: %s", f.Name(), err)
+	}
+	edited, err := readFrontMatter(f)
+	f.Close()
+	if err != nil {
+		printErrLn("Unable to parse %s: %s", f.Name(), err)
+	}
+	if edited.Body == "" {
+		printErrLn("Empty body, item left unchanged")
+	}
+
+	var patch todow.ItemPatch
+	changed := false
+	if edited.Body != item.Body {
+		patch.Body, changed = &edited.Body, true
+	}
+	if edited.Notes != item.Notes {
+		patch.Notes, changed = &edited.Notes, true
+	}
+	if !reflect.DeepEqual(edited.Checklist, item.Checklist) && !(len(edited.Checklist) == 0 && len(item.Checklist) == 0) {
+		patch.Checklist, changed = &edited.Checklist, true
+	}
+	if !reflect.DeepEqual(edited.Tags, item.Tags) && !(len(edited.Tags) == 0 && len(item.Tags) == 0) {
+		patch.Tags, changed = &edited.Tags, true
+	}
+	if !edited.Due.Equal(item.Due) {
+		patch.Due, changed = &edited.Due, true
+	}
+	if !edited.RemindAt.Equal(item.RemindAt) {
+		patch.RemindAt, changed = &edited.RemindAt, true
+	}
+	if !edited.StartAt.Equal(item.StartAt) {
+		patch.StartAt, changed = &edited.StartAt, true
+	}
+	if edited.Done != item.Done {
+		patch.Done, changed = &edited.Done, true
+	}
+	if edited.Priority != item.Priority {
+		patch.Priority, changed = &edited.Priority, true
+	}
+	if !changed {
+		fmt.Printf("Item #%s left unchanged\n", id)
+		return
+	}
+
+	sendPatch(id, &patch)
+}
+
+// writeFrontMatter writes the editable fields of item to f,
+// one "Name: value" per line, followed by its body, notes and checklist.
+func writeFrontMatter(f *os.File, item *todow.Item) {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(todow.DueFormat)
+	}
+
+	fmt.Fprintf(f, "Tags: %s\n", strings.Join(item.Tags, " "))
+	fmt.Fprintf(f, "Due: %s\n", formatTime(item.Due))
+	fmt.Fprintf(f, "Remind: %s\n", formatTime(item.RemindAt))
+	fmt.Fprintf(f, "Start: %s\n", formatTime(item.StartAt))
+	fmt.Fprintf(f, "Priority: %s\n", item.Priority)
+	fmt.Fprintf(f, "Done: %t\n", item.Done)
+	fmt.Fprintln(f, frontMatterEnd)
+	fmt.Fprintln(f, item.Body)
+	fmt.Fprintln(f)
+	if item.Notes != "" {
+		fmt.Fprintln(f, item.Notes)
+	}
+	if len(item.Checklist) > 0 {
+		fmt.Fprintln(f)
+		fmt.Fprintln(f, todow.FormatChecklist(item.Checklist))
+	}
+}
+
+// readFrontMatter parses what writeFrontMatter wrote, after the user
+// edited it. Empty times clear the due date, reminder or start date. The first
+// non-empty line after the fields is the body, lines starting with a
+// check box like "[ ]" or "[x]" are checklist entries, the others are notes.
+func readFrontMatter(f *os.File) (*todow.Item, error) {
+	var item todow.Item
+	s := bufio.NewScanner(f)
+
+	parseTime := func(v string) (time.Time, error) {
+		if v == "" {
+			return time.Time{}, nil
+		}
+		return time.ParseInLocation(todow.DueFormat, v, time.Local)
+	}
+
+	line := 0
+	for s.Scan() {
+		line++
+		if strings.TrimSpace(s.Text()) == frontMatterEnd {
+			break
+		}
+
+		i := strings.Index(s.Text(), ":")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing colon after field name", line)
+		}
+		name, v := strings.TrimSpace(s.Text()[:i]), strings.TrimSpace(s.Text()[i+1:])
+
+		var err error
+		switch strings.ToLower(name) {
+		case "tags":
+			item.Tags = []string{}
+			for _, t := range strings.Fields(v) {
+				item.Tags = append(item.Tags, strings.TrimPrefix(t, todow.TagPrefix))
+			}
+		case "due":
+			item.Due, err = parseTime(v)
+		case "remind":
+			item.RemindAt, err = parseTime(v)
+		case "start":
+			item.StartAt, err = parseTime(v)
+		case "priority":
+			item.Priority = strings.ToUpper(v)
+		case "done":
+			item.Done, err = strconv.ParseBool(v)
+		default:
+			err = fmt.Errorf("unknown field %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+
+	var notes []string
+	for s.Scan() {
+		if item.Body == "" {
+			item.Body = strings.TrimSpace(s.Text())
+			continue
+		}
+		if c, ok := todow.ParseCheckItem(s.Text()); ok {
+			if c.Text != "" {
+				item.Checklist = append(item.Checklist, c)
+			}
+			continue
+		}
+		notes = append(notes, s.Text())
+	}
+	item.Notes = strings.TrimSpace(strings.Join(notes, "\n"))
+	return &item, s.Err()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/j1436go/todow"
+	"golang.org/x/term"
+)
+
+// noColor disables colored output, which is also off if $NO_COLOR
+// is set or stdout isn't a terminal.
+var noColor bool
+
+// Row colors. All have the same length, so that tabwriter, which counts
+// them as part of the first cell, still aligns the columns.
+const (
+	colorNone   = "\x1b[39m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorDim    = "\x1b[02m"
+	colorReset  = "\x1b[0m"
+)
+
+func useColor() bool {
+	if noColor {
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// rowColor returns the color of item: dim if done, red if overdue
+// and yellow if due today.
+func rowColor(item *todow.Item, now time.Time) string {
+	switch {
+	case item.Done:
+		return colorDim
+	case item.Due.IsZero():
+		return colorNone
+	case item.Due.Before(now):
+		return colorRed
+	}
+	y, m, d := now.Date()
+	if dy, dm, dd := item.Due.Local().Date(); dy == y && dm == m && dd == d {
+		return colorYellow
+	}
+	return colorNone
+}
+
+// relTime formats t relative to now, like "3d ago" or "in 2h".
+// Times more than two months away are formatted as dates.
+func relTime(t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	d := t.Sub(now)
+	future := d > 0
+	if !future {
+		d = -d
+	}
+
+	var s string
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		s = fmt.Sprintf("%dm", d/time.Minute)
+	case d < 24*time.Hour:
+		s = fmt.Sprintf("%dh", d/time.Hour)
+	case d < 14*24*time.Hour:
+		s = fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d < 60*24*time.Hour:
+		s = fmt.Sprintf("%dw", d/(7*24*time.Hour))
+	default:
+		return t.Local().Format("2006-01-02")
+	}
+
+	if future {
+		return "in " + s
+	}
+	return s + " ago"
+}
+
+// templateFuncs are available in the item templates of --format:
+//
+//	join   joins strings, e.g. {{join .Tags ","}}
+//	date   formats a time like due dates are given, empty if it's unset
+//	rel    formats a time relative to now, like ls does
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(todow.DueFormat)
+	},
+	"rel": func(t time.Time) string { return relTime(t, time.Now()) },
+}
+
+// isTemplate reports whether format is a template rather than a format name.
+func isTemplate(format string) bool {
+	return strings.Contains(format, "{{")
+}
+
+// itemTemplate parses the template items are printed with by --format.
+func itemTemplate(format string) *template.Template {
+	t, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		printErrLn("Invalid format: %s", err)
+	}
+	return t
+}
+
+// executeTemplate prints item with t, followed by a newline.
+func executeTemplate(t *template.Template, item *todow.Item) {
+	if err := t.Execute(os.Stdout, item); err != nil {
+		printErrLn("\nUnable to format item #%d: %s", item.ID, err)
+	}
+	fmt.Println()
+}
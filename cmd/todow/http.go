@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	retries   = flag.Int("retries", 2, "Number of retries of requests failing due to network errors or an unavailable server")
+	retryWait = flag.Duration("retry-wait", 500*time.Millisecond, "Wait before the first retry, doubling with every further one")
+)
+
+// Exit codes. Invalid flags exit with 2.
+const (
+	exitError    = 1 // invalid arguments or local failures
+	exitNetwork  = 3 // the server could not be reached
+	exitAuth     = 4 // the credentials were rejected or access denied
+	exitNotFound = 5 // the item or other resource does not exist
+	exitRequest  = 6 // the server rejected the request
+	exitServer   = 7 // the server failed
+	exitOverdue  = 8 // agenda found overdue items
+)
+
+// fail prints a message to stderr and exits with code.
+func fail(code int, f string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, f+"\n", args...)
+	os.Exit(code)
+}
+
+// do sends req with the default client, see doWith.
+func do(req *http.Request) *http.Response {
+	return doWith(&client, req)
+}
+
+// doWith sends req with c and returns the response if it succeeded.
+// Otherwise it exits with an explanation of what went wrong. Requests
+// are retried after network errors and while the server is unavailable,
+// unless they might have had an effect on the server already.
+func doWith(c *http.Client, req *http.Request) *http.Response {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			fail(exitError, "Unable to read request body: %s", err)
+		}
+		req.Body.Close()
+	}
+
+	wait := *retryWait
+	for attempt := 0; ; attempt++ {
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		again := attempt < *retries
+
+		resp, err := c.Do(req)
+		if err != nil {
+			if again && (idempotent(req) || notSent(err)) {
+				time.Sleep(wait)
+				wait *= 2
+				continue
+			}
+			fail(exitNetwork, "Unable to %s %s: %s", req.Method, req.URL, err)
+		}
+
+		if again && unavailable(resp.StatusCode) && idempotent(req) {
+			resp.Body.Close()
+			time.Sleep(retryAfter(resp, wait))
+			wait *= 2
+			continue
+		}
+
+		// An earlier attempt whose response got lost may have removed it.
+		if attempt > 0 && req.Method == "DELETE" && resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+			resp.Body = ioutil.NopCloser(strings.NewReader("Removed by an earlier attempt\n"))
+			return resp
+		}
+
+		if resp.StatusCode >= 300 {
+			statusError(req, resp)
+		}
+		return resp
+	}
+}
+
+// idempotent reports whether sending req twice has the same effect as
+// sending it once. Patches of this client only ever set fields.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "PUT", "DELETE", "PATCH":
+		return true
+	}
+	return false
+}
+
+// notSent reports whether err occurred before the request was sent,
+// when connecting to the server.
+func notSent(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// unavailable reports whether status indicates a transient failure.
+func unavailable(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the wait requested by the Retry-After header of
+// resp, if it is at most a minute, or def.
+func retryAfter(resp *http.Response, def time.Duration) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 || secs > 60 {
+		return def
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// statusError exits with a message explaining the failed response.
+func statusError(req *http.Request, resp *http.Response) {
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, io.LimitReader(resp.Body, 4<<10))
+	msg := strings.TrimSpace(buf.String())
+
+	switch code := resp.StatusCode; {
+	case code == http.StatusUnauthorized:
+		fail(exitAuth, "Login as %q failed, check -u and -p or $TODOW_PASSWORD", *user)
+	case code == http.StatusForbidden:
+		fail(exitAuth, "Access denied: %s", msg)
+	case code == http.StatusTooManyRequests:
+		fail(exitAuth, "Locked out: %s", msg)
+	case code == http.StatusNotFound && msg != "" && msg != "404 page not found":
+		fail(exitNotFound, "Not found: %s", msg)
+	case code == http.StatusNotFound:
+		fail(exitNotFound, "Not found: %s", req.URL.Path)
+	case code == http.StatusNotImplemented:
+		fail(exitRequest, "%s", msg)
+	case code >= 500:
+		fail(exitServer, "Server failed with %s: %s", resp.Status, msg)
+	default:
+		fail(exitRequest, "Request failed with %s: %s", resp.Status, msg)
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var (
+	local      = flag.Bool("local", false, "Work on a local store instead of a server")
+	localStore = flag.String("store", "bolt", "Kind of the local store, bolt or todotxt")
+	localDB    = flag.String("db", defaultLocalDB(), "File of the local store")
+)
+
+// localPathRegexp matches the item paths served in local mode.
+var localPathRegexp = regexp.MustCompile(`^` + todow.APIPath + `(?:([0-9]+)(/snooze)?)?$`)
+
+// localTrashRetention is how long local stores keep removed items.
+const localTrashRetention = 30 * 24 * time.Hour
+
+// serverOnly are the API endpoints that only a server provides.
+var serverOnly = map[string]bool{
+	"stats":    true,
+	"import":   true,
+	"events":   true,
+	"shares":   true,
+	"invites":  true,
+	"settings": true,
+}
+
+func defaultLocalDB() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "todos.db"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "todow", "todos.db")
+}
+
+// openLocal opens the local store and makes the client send all
+// requests to it instead of the server. The caller must close the store.
+func openLocal() store.Store {
+	if *list != "" {
+		printErrLn("Shared lists are not available in local mode")
+	}
+	switch *localStore {
+	case "bolt", "todotxt":
+	default:
+		printErrLn("Unsupported local store %q, use bolt or todotxt", *localStore)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*localDB), 0700); err != nil {
+		printErrLn("Unable to create directory of %s: %s", *localDB, err)
+	}
+	// Migrations and compaction are logged, which only servers want.
+	log.SetOutput(ioutil.Discard)
+	s, err := store.Open(*localStore, *localDB)
+	if err != nil {
+		printErrLn("Unable to open %s: %s", *localDB, err)
+	}
+	// A failed purge is tried again with the next command.
+	s.Purge(time.Now().Add(-localTrashRetention))
+
+	client.Transport = localTransport{localAPI{s}}
+	*retries = 0
+	return s
+}
+
+// localTransport answers requests with a handler in this process.
+type localTransport struct {
+	h http.Handler
+}
+
+func (t localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+// localAPI serves the part of the server API that works on a single
+// user's items: listing, adding, changing and removing items, the trash,
+// search, export, backups and compacting. Everything else needs a server.
+type localAPI struct {
+	s store.Store
+}
+
+func (a localAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, todow.APIPath) {
+	case "search":
+		a.search(w, r)
+		return
+	case "bulk":
+		a.bulk(w, r)
+		return
+	case "export":
+		a.export(w, r)
+		return
+	case "backup":
+		a.backup(w, r)
+		return
+	case "compact":
+		a.compact(w, r)
+		return
+	}
+
+	endpoint := strings.SplitN(strings.TrimPrefix(r.URL.Path, todow.APIPath), "/", 2)[0]
+	if endpoint == "trash" {
+		a.trash(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/history") {
+		endpoint = "history"
+	}
+	if serverOnly[endpoint] {
+		http.Error(w, fmt.Sprintf("%s is not available in local mode", endpoint), http.StatusNotImplemented)
+		return
+	}
+	m := localPathRegexp.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if m[1] == "" {
+		switch r.Method {
+		case "GET":
+			a.find(w, r)
+		case "POST":
+			a.add(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	id, _ := strconv.ParseInt(m[1], 10, 64)
+	switch {
+	case m[2] != "" && r.Method == "POST":
+		a.snooze(w, r, id)
+	case m[2] != "":
+		http.NotFound(w, r)
+	case r.Method == "GET":
+		item, err := a.s.Get(id)
+		a.respond(w, r, err, func() { json.NewEncoder(w).Encode(item) })
+	case r.Method == "DELETE":
+		err := a.s.Remove(id)
+		a.respond(w, r, err, func() { fmt.Fprintf(w, "Removed item #%d\n", id) })
+	case r.Method == "PATCH":
+		a.patch(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// respond writes the output of ok, or the error err as the server would.
+func (a localAPI) respond(w http.ResponseWriter, r *http.Request, err error, ok func()) {
+	switch err.(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case error:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case nil:
+		ok()
+	}
+}
+
+// blocked responds with 409 Conflict and returns true if one of the
+// items of ids is blocked by open items, unless force=true is set.
+func (a localAPI) blocked(w http.ResponseWriter, r *http.Request, ids []int64) bool {
+	if r.URL.Query().Get("force") == "true" {
+		return false
+	}
+	for _, id := range ids {
+		item, err := a.s.Get(id)
+		if err != nil {
+			continue
+		}
+		open, err := store.OpenBlockers(a.s, item)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		if len(open) > 0 && !item.Done {
+			http.Error(w, fmt.Sprintf("blocked by open items: #%d by #%s, complete them first or force it", id, todow.FormatIDs(open, ", #")), http.StatusConflict)
+			return true
+		}
+	}
+	return false
+}
+
+// invalidBlockers responds with 400 Bad Request and returns true if the
+// item identified by id can't be blocked by blockers, as one of them
+// does not exist or waits for the item itself.
+func (a localAPI) invalidBlockers(w http.ResponseWriter, r *http.Request, id int64, blockers []int64) bool {
+	for _, b := range blockers {
+		if _, err := a.s.Get(b); err != nil {
+			http.Error(w, fmt.Sprintf("blocking item #%d not found", b), http.StatusBadRequest)
+			return true
+		}
+	}
+	b, err := store.BlockCycle(a.s, id, blockers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if b != 0 {
+		http.Error(w, fmt.Sprintf("item #%d can't be blocked by #%d, which waits for it", id, b), http.StatusBadRequest)
+		return true
+	}
+	return false
+}
+
+// trash lists the removed items, POST on trash/ID restores one.
+func (a localAPI) trash(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, todow.APIPath+"trash"), "/")
+	switch {
+	case path == "" && r.Method == "GET":
+		col, err := a.s.Trash()
+		a.respond(w, r, err, func() { json.NewEncoder(w).Encode(col) })
+	case path != "" && r.Method == "POST":
+		id, err := strconv.ParseInt(path, 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		_, err = a.s.Restore(id)
+		a.respond(w, r, err, func() { fmt.Fprintf(w, "Restored item #%d\n", id) })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a localAPI) find(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("filter") != "" {
+		http.Error(w, "saved filters are not available in local mode", http.StatusNotImplemented)
+		return
+	}
+	q, err := store.ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	col, total, err := a.s.Find(q)
+	a.respond(w, r, err, func() {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		switch r.Header.Get("Accept") {
+		case "text/csv":
+			todow.WriteCSV(w, col)
+		case "text/plain":
+			todow.WriteTSV(w, col)
+		default:
+			json.NewEncoder(w).Encode(col)
+		}
+	})
+}
+
+func (a localAPI) add(w http.ResponseWriter, r *http.Request) {
+	var item todow.Item
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		http.Error(w, fmt.Sprintf("unable to decode todo item: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := todow.CheckMeta(item.Meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("force") != "true" {
+		dups, err := store.Duplicates(a.s, item.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(dups) > 0 {
+			refs := make([]string, len(dups))
+			for i, d := range dups {
+				refs[i] = fmt.Sprintf("#%d %q", d.ID, d.Body)
+			}
+			http.Error(w, fmt.Sprintf("similar to open items: %s, add it anyway by forcing it", strings.Join(refs, ", ")), http.StatusConflict)
+			return
+		}
+	}
+
+	switch err := a.s.Add(&item).(type) {
+	case store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("parent item #%d not found", item.ParentID), http.StatusBadRequest)
+	case error:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case nil:
+		w.WriteHeader(201)
+		fmt.Fprintf(w, "Added item #%d\n", item.ID)
+	}
+}
+
+// patch applies an item patch, or completes the item if there is none.
+func (a localAPI) patch(w http.ResponseWriter, r *http.Request, id int64) {
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cascading := r.URL.Query().Get("cascade") == "true"
+
+	if len(bytes.TrimSpace(p)) == 0 {
+		if a.blocked(w, r, []int64{id}) {
+			return
+		}
+		complete := func(item *todow.Item) { item.SetDone(true) }
+		var cascade func(*todow.Item)
+		if cascading {
+			cascade = complete
+		}
+		err := a.s.Update(id, complete, cascade)
+		a.respond(w, r, err, func() { fmt.Fprintf(w, "Completed item #%d\n", id) })
+		return
+	}
+
+	var patch todow.ItemPatch
+	if err := json.Unmarshal(p, &patch); err != nil {
+		http.Error(w, fmt.Sprintf("unable to decode patch: %s", err), http.StatusBadRequest)
+		return
+	}
+	if patch.Body != nil && strings.TrimSpace(*patch.Body) == "" {
+		http.Error(w, "item body must not be empty", http.StatusBadRequest)
+		return
+	}
+	if patch.Priority != nil && !todow.ValidPriority(*patch.Priority) {
+		http.Error(w, fmt.Sprintf("invalid priority %q, use A to Z", *patch.Priority), http.StatusBadRequest)
+		return
+	}
+	if err := todow.CheckMeta(patch.Meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if patch.BlockedBy != nil && a.invalidBlockers(w, r, id, *patch.BlockedBy) {
+		return
+	}
+	if patch.Done != nil && *patch.Done && a.blocked(w, r, []int64{id}) {
+		return
+	}
+
+	var cascade func(*todow.Item)
+	if patch.Done != nil && cascading {
+		done := *patch.Done
+		cascade = func(item *todow.Item) { item.SetDone(done) }
+	}
+	err = a.s.Update(id, patch.Apply, cascade)
+	a.respond(w, r, err, func() {
+		if patch.Done != nil && !*patch.Done {
+			fmt.Fprintf(w, "Reopened item #%d\n", id)
+			return
+		}
+		fmt.Fprintf(w, "Updated item #%d\n", id)
+	})
+}
+
+// snooze sets the reminder of an item. Local stores have nobody
+// sending reminders, but they are kept for when the store is served.
+func (a localAPI) snooze(w http.ResponseWriter, r *http.Request, id int64) {
+	// The server snoozes for 10 minutes by default, too.
+	d := 10 * time.Minute
+	if s := r.URL.Query().Get("for"); s != "" {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid snooze duration %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	at := time.Now().Add(d)
+	err := a.s.Update(id, func(item *todow.Item) { item.RemindAt = at }, nil)
+	a.respond(w, r, err, func() { fmt.Fprintf(w, "Snoozed item #%d until %s\n", id, at.Format(todow.DueFormat)) })
+}
+
+// bulk removes or patches several items, checking first that all exist.
+func (a localAPI) bulk(w http.ResponseWriter, r *http.Request) {
+	var b todow.Bulk
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil || b.Remove == (b.Patch != nil) {
+		http.Error(w, "invalid bulk request", http.StatusBadRequest)
+		return
+	}
+
+	var missing []string
+	for _, id := range b.IDs {
+		if _, err := a.s.Get(id); err != nil {
+			missing = append(missing, fmt.Sprintf("#%d", id))
+		}
+	}
+	if len(missing) > 0 {
+		http.Error(w, fmt.Sprintf("no items %s, none changed", strings.Join(missing, ", ")), http.StatusNotFound)
+		return
+	}
+	if b.Patch != nil && b.Patch.BlockedBy != nil {
+		for _, id := range b.IDs {
+			if a.invalidBlockers(w, r, id, *b.Patch.BlockedBy) {
+				return
+			}
+		}
+	}
+	if b.Patch != nil && b.Patch.Done != nil && *b.Patch.Done && a.blocked(w, r, b.IDs) {
+		return
+	}
+
+	var cascade func(*todow.Item)
+	if b.Patch != nil && b.Patch.Done != nil && r.URL.Query().Get("cascade") == "true" {
+		done := *b.Patch.Done
+		cascade = func(item *todow.Item) { item.SetDone(done) }
+	}
+
+	var out bytes.Buffer
+	for _, id := range b.IDs {
+		var err error
+		msg := "Updated"
+		switch {
+		case b.Remove:
+			err, msg = a.s.Remove(id), "Removed"
+		default:
+			err = a.s.Update(id, b.Patch.Apply, cascade)
+			if b.Patch.Done != nil && *b.Patch.Done {
+				msg = "Completed"
+			} else if b.Patch.Done != nil {
+				msg = "Reopened"
+			}
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("item #%d: %s, the items before it were changed", id, err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(&out, "%s item #%d\n", msg, id)
+	}
+	out.WriteTo(w)
+}
+
+func (a localAPI) search(w http.ResponseWriter, r *http.Request) {
+	col, err := a.s.Search(r.URL.Query().Get("q"))
+	a.respond(w, r, err, func() { json.NewEncoder(w).Encode(col) })
+}
+
+func (a localAPI) export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	writers := map[string]func(io.Writer, []*todow.Item) error{
+		"csv": todow.WriteCSV,
+		"org": todow.WriteOrg,
+		"md":  todow.WriteMarkdown,
+	}
+	if _, ok := writers[format]; format != "" && format != "json" && !ok {
+		http.Error(w, fmt.Sprintf("unsupported export format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	col, err := a.s.All()
+	a.respond(w, r, err, func() {
+		if write, ok := writers[format]; ok {
+			write(w, col)
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(col)
+	})
+}
+
+func (a localAPI) backup(w http.ResponseWriter, r *http.Request) {
+	b, ok := a.s.(store.Backuper)
+	if !ok {
+		http.Error(w, fmt.Sprintf("backups of %s stores are not supported", *localStore), http.StatusNotImplemented)
+		return
+	}
+	var buf bytes.Buffer
+	_, err := b.Backup(&buf)
+	a.respond(w, r, err, func() { buf.WriteTo(w) })
+}
+
+func (a localAPI) compact(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.s.(store.Compacter)
+	if !ok {
+		http.Error(w, fmt.Sprintf("compacting %s stores is not supported", *localStore), http.StatusNotImplemented)
+		return
+	}
+	before, after, err := c.Compact()
+	a.respond(w, r, err, func() { fmt.Fprintf(w, "Compacted store from %d to %d bytes\n", before, after) })
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"flag"
@@ -8,18 +9,30 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"text/template"
 	"time"
 
 	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/quickadd"
+	"github.com/j1436go/todow/server"
 )
 
 var (
 	domain = flag.String("h", "http://localhost:9999", "Server domain without API path")
-	user   = flag.String("u", todow.HTTPUser, "HTTP Basic username")
-	pass   = flag.String("p", todow.HTTPPassword, "HTTP Basic password")
+	user   = flag.String("u", todow.DefaultUser, "HTTP Basic username")
+	pass   = flag.String("p", os.Getenv("TODOW_PASSWORD"), "HTTP Basic password, $TODOW_PASSWORD by default")
+	list   = flag.String("l", "", "Work on the shared list OWNER/TAG")
+
+	parent  = flag.Int64("parent", 0, "Parent item ID for add")
+	cascade = flag.Bool("r", false, "Also complete or reopen child items")
+	force   = flag.Bool("f", false, "Complete items even if they are blocked by open items, add items even if they resemble open ones")
 
 	client = http.Client{
 		Timeout: time.Second * 7,
@@ -28,6 +41,16 @@ var (
 
 func main() {
 	flag.Parse()
+	if flag.Arg(0) == "serve" {
+		server.Main(flag.Args()[1:])
+		return
+	}
+	if err := configure(); err != nil {
+		printErrLn("Invalid config: %s", err)
+	}
+	if *local {
+		defer openLocal().Close()
+	}
 
 	if len(flag.Args()) == 0 {
 		fmt.Fprintln(os.Stderr, help)
@@ -41,8 +64,90 @@ func main() {
 		addItem()
 	case "rm":
 		removeItem()
+	case "trash":
+		listTrash()
+	case "restore":
+		restoreItems()
 	case "c":
 		completeItem()
+	case "u":
+		uncompleteItem()
+	case "archive":
+		archiveItems(true)
+	case "unarchive":
+		archiveItems(false)
+	case "meta":
+		setMeta()
+	case "pin":
+		pinItems(true)
+	case "unpin":
+		pinItems(false)
+	case "snooze":
+		snoozeItem()
+	case "due":
+		setDue()
+	case "start":
+		setStart()
+	case "pri":
+		setPriority()
+	case "search":
+		searchItems()
+	case "filters":
+		listFilters()
+	case "digest":
+		setDigest()
+	case "subscribe":
+		subscribe()
+	case "subscriptions":
+		listSubscriptions()
+	case "unsubscribe":
+		unsubscribe()
+	case "filter":
+		saveFilter()
+	case "stats":
+		showStats()
+	case "agenda":
+		agenda()
+	case "show":
+		showItem()
+	case "watch":
+		watch()
+	case "notify":
+		notify()
+	case "share":
+		shareItems()
+	case "shares":
+		listShares()
+	case "unshare":
+		unshare()
+	case "invite":
+		invite()
+	case "invites":
+		listInvites()
+	case "join":
+		join()
+	case "uninvite":
+		uninvite()
+	case "edit":
+		editItem()
+	case "notes":
+		setNotes()
+	case "block":
+		blockItem()
+	case "unblock":
+		unblockItem()
+	case "check":
+		checkEntries(true)
+	case "uncheck":
+		checkEntries(false)
+	case "backup":
+		backup()
+	case "compact":
+		compact()
+	case "export":
+		export()
+	case "import":
+		importItems()
 	case "help":
 		fmt.Fprintln(os.Stderr, help)
 	default:
@@ -50,13 +155,68 @@ func main() {
 	}
 }
 
+// addItem adds an item from the arguments, or from stdin if the only
+// argument is "-". With --each-line, every line of stdin is added as
+// an item, followed by the arguments, e.g. tags.
 func addItem() {
-	if len(flag.Args()) == 1 {
-		printErrLn("Missing item text")
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	eachLine := fs.Bool("each-line", false, "Add an item for every line of stdin")
+	notes := fs.String("notes", "", "Notes of the item")
+	startWhen := fs.String("start", "", "Hide the item from ls until the given time, e.g. \"in 6 months\"")
+	var metaFlags stringList
+	fs.Var(&metaFlags, "meta", "Metadata field KEY=VALUE of the item, may be given multiple times")
+	fs.BoolVar(force, "force", *force, "Add the item even if open items have a similar body, like -f")
+	fs.Parse(flag.Args()[1:])
+
+	meta := parseMeta(metaFlags)
+
+	var start time.Time
+	if *startWhen != "" {
+		var err error
+		start, err = quickadd.ParseWhen(*startWhen, time.Now())
+		if err != nil {
+			printErrLn("Invalid start date: %s", err)
+		}
+	}
+
+	if *eachLine {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			if strings.TrimSpace(s.Text()) == "" {
+				continue
+			}
+			postItem(strings.Join(append([]string{s.Text()}, fs.Args()...), " "), *notes, start, meta)
+		}
+		if err := s.Err(); err != nil {
+			printErrLn("Unable to read stdin: %s", err)
+		}
+		return
+	}
+
+	text := strings.Join(fs.Args(), " ")
+	if text == "-" {
+		p, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			printErrLn("Unable to read stdin: %s", err)
+		}
+		text = string(p)
+	}
+	postItem(text, *notes, start, meta)
+}
+
+// postItem adds the item described by text, see quickadd, with notes,
+// the start time start and the metadata meta.
+func postItem(text, notes string, start time.Time, meta map[string]string) {
+	item := quickadd.Parse(text, time.Now())
+	item.Notes = notes
+	item.StartAt = start
+	if len(meta) > 0 {
+		item.Meta = meta
 	}
-	item := &todow.Item{
-		Body:    strings.Join(flag.Args()[1:], " "),
-		Created: time.Now(),
+	item.ParentID = *parent
+	item.Created = time.Now()
+	if item.Body == "" {
+		printErrLn("Missing item text")
 	}
 
 	var buf bytes.Buffer
@@ -66,84 +226,1031 @@ func addItem() {
 	}
 
 	req := request("POST")
+	req.URL.RawQuery = changeQuery()
 	req.Body = ioutil.NopCloser(&buf)
-	resp, err := client.Do(req)
-	if err != nil {
-		printErrLn("Unable to POST %s: %s", *req.URL, err)
-	}
-
-	buf.Reset()
-	io.Copy(&buf, resp.Body)
-	fmt.Fprintln(os.Stdout, buf.String())
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
 }
 
 func removeItem() {
-	if len(flag.Args()) == 1 {
-		printErrLn("Missing item id")
+	ids := itemIDs()
+	if len(ids) > 1 {
+		sendBulk(&todow.Bulk{IDs: ids, Remove: true})
+		return
 	}
-
-	id := flag.Args()[1]
+	id := strconv.FormatInt(ids[0], 10)
 
 	req := request("DELETE")
 	req.URL.Path += id
-	resp, err := client.Do(req)
-	if err != nil {
-		printErrLn("Unable to DELETE %s: %s", *req.URL, err)
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+	return
+}
+
+// listTrash lists the removed items, most recently removed first.
+func listTrash() {
+	req := request("GET")
+	req.URL.Path += "trash"
+	resp := do(req)
+	defer resp.Body.Close()
+
+	col := []*todow.Item{}
+	if err := json.NewDecoder(resp.Body).Decode(&col); err != nil {
+		printErrLn("unable to decode json response: %s", err)
 	}
 
-	var buf bytes.Buffer
-	io.Copy(&buf, resp.Body)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(tw, "ID\tBody\tTags\tRemoved")
+	for _, v := range col {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", v.ID, v.Body, strings.Join(v.Tags, " "), v.Deleted.Format(todow.DueFormat))
+	}
+	tw.Flush()
+}
+
+func restoreItems() {
+	for _, id := range itemIDs() {
+		req := request("POST")
+		req.URL.Path += "trash/" + strconv.FormatInt(id, 10)
+		resp := do(req)
+		io.Copy(os.Stdout, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func completeItem() {
+	ids := itemIDs()
+	if len(ids) > 1 {
+		done := true
+		sendBulk(&todow.Bulk{IDs: ids, Patch: &todow.ItemPatch{Done: &done}})
+		return
+	}
+	id := strconv.FormatInt(ids[0], 10)
+
+	req := request("PATCH")
+	req.URL.Path += id
+	req.URL.RawQuery = changeQuery()
+	resp := do(req)
 	defer resp.Body.Close()
-	fmt.Fprint(os.Stdout, buf.String())
+	io.Copy(os.Stdout, resp.Body)
 	return
 }
 
-func completeItem() {
+func snoozeItem() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing item id")
+	}
+
+	id, err := strconv.ParseInt(flag.Args()[1], 10, 64)
+	if err != nil || id < 1 {
+		printErrLn("Invalid item id %q", flag.Args()[1])
+	}
+
+	req := request("POST")
+	req.URL.Path += strconv.FormatInt(id, 10) + "/snooze"
+	if len(flag.Args()) > 2 {
+		req.URL.RawQuery = url.Values{"for": {flag.Args()[2]}}.Encode()
+	}
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+// setDue sets the due date of an item, or clears it with "none".
+func setDue() {
+	if len(flag.Args()) < 3 {
+		printErrLn("Missing item id or due date")
+	}
+
+	var due time.Time
+	if when := strings.Join(flag.Args()[2:], " "); when != "none" {
+		var err error
+		due, err = quickadd.ParseWhen(when, time.Now())
+		if err != nil {
+			printErrLn("Invalid due date: %s", err)
+		}
+	}
+	sendPatch(flag.Args()[1], &todow.ItemPatch{Due: &due})
+}
+
+// setStart defers an item until the given time, or makes it
+// actionable right away with "none".
+func setStart() {
+	if len(flag.Args()) < 3 {
+		printErrLn("Missing item id or start date")
+	}
+
+	var start time.Time
+	if when := strings.Join(flag.Args()[2:], " "); when != "none" {
+		var err error
+		start, err = quickadd.ParseWhen(when, time.Now())
+		if err != nil {
+			printErrLn("Invalid start date: %s", err)
+		}
+	}
+	sendPatch(flag.Args()[1], &todow.ItemPatch{StartAt: &start})
+}
+
+// setPriority sets the priority of an item, or clears it with "none".
+func setPriority() {
+	if len(flag.Args()) < 3 {
+		printErrLn("Missing item id or priority")
+	}
+
+	var pri string
+	if level := strings.TrimPrefix(flag.Args()[2], quickadd.PriorityPrefix); level != "none" {
+		var ok bool
+		pri, ok = quickadd.ParsePriority(level)
+		if !ok {
+			printErrLn("Invalid priority %q, use high, medium, low or A to Z", level)
+		}
+	}
+	sendPatch(flag.Args()[1], &todow.ItemPatch{Priority: &pri})
+}
+
+func uncompleteItem() {
+	ids := itemIDs()
+	done := false
+	if len(ids) > 1 {
+		sendBulk(&todow.Bulk{IDs: ids, Patch: &todow.ItemPatch{Done: &done}})
+		return
+	}
+	sendPatch(strconv.FormatInt(ids[0], 10), &todow.ItemPatch{Done: &done})
+}
+
+// archiveItems archives the items given as arguments,
+// or takes them out of the archive.
+func archiveItems(archived bool) {
+	ids := itemIDs()
+	if len(ids) > 1 {
+		sendBulk(&todow.Bulk{IDs: ids, Patch: &todow.ItemPatch{Archived: &archived}})
+		return
+	}
+	sendPatch(strconv.FormatInt(ids[0], 10), &todow.ItemPatch{Archived: &archived})
+}
+
+// pinItems pins or unpins the items given as arguments.
+func pinItems(pinned bool) {
+	ids := itemIDs()
+	if len(ids) > 1 {
+		sendBulk(&todow.Bulk{IDs: ids, Patch: &todow.ItemPatch{Pinned: &pinned}})
+		return
+	}
+	sendPatch(strconv.FormatInt(ids[0], 10), &todow.ItemPatch{Pinned: &pinned})
+}
+
+// itemIDs returns the item ids given as arguments of the command,
+// single ones like 3 or ranges like 7-9.
+func itemIDs() []int64 {
 	if len(flag.Args()) == 1 {
 		printErrLn("Missing item id")
 	}
 
-	id := flag.Args()[1]
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, arg := range flag.Args()[1:] {
+		from, to := arg, arg
+		if i := strings.Index(arg, "-"); i > 0 {
+			from, to = arg[:i], arg[i+1:]
+		}
+		first, err := strconv.ParseInt(from, 10, 64)
+		if err != nil || first < 1 {
+			printErrLn("Invalid item id or range %q", arg)
+		}
+		last, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || last < first {
+			printErrLn("Invalid item id or range %q", arg)
+		}
+		if last-first >= 1000 {
+			printErrLn("Range %q spans too many items", arg)
+		}
+		for id := first; id <= last; id++ {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// changeQuery returns the query parameters of requests changing items
+// set by the -r and -f flags.
+func changeQuery() string {
+	v := url.Values{}
+	if *cascade {
+		v.Set("cascade", "true")
+	}
+	if *force {
+		v.Set("force", "true")
+	}
+	return v.Encode()
+}
+
+// sendBulk sends a bulk request for several items
+// and prints the server response.
+func sendBulk(b *todow.Bulk) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(b); err != nil {
+		printErrLn("Unable to marshal bulk request to json: %s", err)
+	}
+
+	req := request("POST")
+	req.URL.Path += "bulk"
+	req.URL.RawQuery = changeQuery()
+	req.Body = ioutil.NopCloser(&buf)
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+// sendPatch PATCHes the item identified by id with patch
+// and prints the server response.
+func sendPatch(id string, patch *todow.ItemPatch) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(patch)
+	if err != nil {
+		printErrLn("Unable to marshal patch to json: %s", err)
+	}
 
 	req := request("PATCH")
 	req.URL.Path += id
-	resp, err := client.Do(req)
+	req.URL.RawQuery = changeQuery()
+	req.Body = ioutil.NopCloser(&buf)
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func backup() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing backup file")
+	}
+
+	req := request("GET")
+	req.URL.Path += "backup"
+	// Snapshots of big databases take longer than regular requests.
+	c := client
+	c.Timeout = 0
+	resp := doWith(&c, req)
+	defer resp.Body.Close()
+
+	f, err := os.OpenFile(flag.Args()[1], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		printErrLn("Unable to PATH %s: %s", *req.URL, err)
+		printErrLn("Unable to create backup file: %s", err)
 	}
 
-	var buf bytes.Buffer
-	io.Copy(&buf, resp.Body)
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		printErrLn("Unable to write backup file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		printErrLn("Unable to write backup file: %s", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "Wrote %d bytes to %s\n", n, flag.Args()[1])
+}
+
+func compact() {
+	req := request("POST")
+	req.URL.Path += "compact"
+	c := client
+	c.Timeout = 0
+	resp := doWith(&c, req)
 	defer resp.Body.Close()
-	fmt.Fprint(os.Stdout, buf.String())
-	return
+	io.Copy(os.Stdout, resp.Body)
 }
 
-func listItems() {
+func export() {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("f", "json", "Export format, json, csv, org or md")
+	fs.Parse(flag.Args()[1:])
+
 	req := request("GET")
-	resp, err := client.Do(req)
+	req.URL.Path += "export"
+	req.URL.RawQuery = url.Values{"format": {*format}}.Encode()
+	resp := do(req)
+	defer resp.Body.Close()
+
+	out := os.Stdout
+	if fs.NArg() > 0 {
+		var err error
+		out, err = os.Create(fs.Arg(0))
+		if err != nil {
+			printErrLn("Unable to create export file: %s", err)
+		}
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		printErrLn("Unable to write export: %s", err)
+	}
+	if err := out.Close(); err != nil {
+		printErrLn("Unable to write export: %s", err)
+	}
+}
+
+func importItems() {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("f", "", "Import format, json, csv or todotxt, derived from the file extension by default")
+	from := fs.String("from", "", "Service to import from instead of a file, todoist")
+	token := fs.String("token", "", "API token of the service, $TODOIST_TOKEN by default")
+	api := fs.String("api", "https://api.todoist.com/rest/v2/", "URL of the service's API")
+	sync := fs.Bool("sync", false, "Keep importing changes from the service")
+	interval := fs.Duration("interval", 15*time.Minute, "Interval between imports with --sync")
+	fs.Parse(flag.Args()[1:])
+
+	switch *from {
+	case "":
+	case "todoist":
+		importTodoist(*api, *token, *sync, *interval)
+		return
+	default:
+		printErrLn("Unsupported service %q, use todoist", *from)
+	}
+
+	if fs.NArg() == 0 {
+		printErrLn("Missing import file")
+	}
+
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(fs.Arg(0))) {
+		case ".json":
+			*format = "json"
+		case ".csv":
+			*format = "csv"
+		case ".txt":
+			*format = "todotxt"
+		default:
+			printErrLn("Unable to derive format from file name, use -f")
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
 	if err != nil {
-		printErrLn("Unable to GET %s: %s", *req.URL, err)
+		printErrLn("Unable to open import file: %s", err)
 	}
+	defer f.Close()
 
-	if strings.Contains(resp.Header.Get("Content-Type"), "text/plain") {
-		var buf bytes.Buffer
-		io.Copy(&buf, resp.Body)
-		defer resp.Body.Close()
-		fmt.Fprint(os.Stdout, buf.String())
+	req := request("POST")
+	req.URL.Path += "import"
+	req.URL.RawQuery = url.Values{"format": {*format}}.Encode()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Body = f
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func shareItems() {
+	_, tags := todow.ParseTags(flag.Args()[1:])
+	if len(tags) > 1 {
+		printErrLn("Only one tag can be shared")
+	}
+
+	req := request("POST")
+	req.URL.Path += "shares"
+	if len(tags) == 1 {
+		req.URL.RawQuery = url.Values{"tag": {tags[0]}}.Encode()
+	}
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func listShares() {
+	req := request("GET")
+	req.URL.Path += "shares"
+	resp := do(req)
+	defer resp.Body.Close()
+
+	var shares []struct {
+		Token   string
+		Tag     string
+		Created time.Time
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&shares); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(tw, "Token\tTag\tCreated")
+	for _, s := range shares {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Token, s.Tag, s.Created.Format(todow.DueFormat))
+	}
+	tw.Flush()
+}
+
+func unshare() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing share token")
+	}
+
+	req := request("DELETE")
+	req.URL.Path += "shares/" + flag.Args()[1]
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func invite() {
+	fs := flag.NewFlagSet("invite", flag.ExitOnError)
+	write := fs.Bool("w", false, "Allow changes to the list")
+	fs.Parse(flag.Args()[1:])
+
+	_, tags := todow.ParseTags(fs.Args())
+	if len(tags) != 1 || fs.NArg() != 2 {
+		printErrLn("Usage: todow invite [-w] +TAG USER|EMAIL")
+	}
+	who := fs.Arg(0)
+	if strings.HasPrefix(who, todow.TagPrefix) {
+		who = fs.Arg(1)
+	}
+
+	q := url.Values{"tag": {tags[0]}, "write": {strconv.FormatBool(*write)}}
+	if strings.Contains(who, "@") {
+		q.Set("email", who)
+	} else {
+		q.Set("user", who)
+	}
+
+	req := request("POST")
+	req.URL.Path += "invites"
+	req.URL.RawQuery = q.Encode()
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func listInvites() {
+	req := request("GET")
+	req.URL.Path += "invites"
+	resp := do(req)
+	defer resp.Body.Close()
+
+	var invites []struct {
+		Token    string
+		Owner    string
+		Tag      string
+		Invitee  string
+		Email    string
+		Accepted bool
+		Write    bool
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&invites); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "Token\tList\tInvitee\tAccess")
+	for _, inv := range invites {
+		invitee := inv.Invitee
+		if !inv.Accepted {
+			invitee = inv.Email + " (pending)"
+		}
+		access := "read"
+		if inv.Write {
+			access = "write"
+		}
+		fmt.Fprintf(tw, "%s\t%s/%s\t%s\t%s\n", inv.Token, inv.Owner, inv.Tag, invitee, access)
+	}
+	tw.Flush()
+}
+
+func join() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing invitation token")
+	}
+
+	req := request("POST")
+	req.URL.Path += "invites/" + flag.Args()[1]
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func uninvite() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing invitation token")
+	}
+
+	req := request("DELETE")
+	req.URL.Path += "invites/" + flag.Args()[1]
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+// watch prints change events streamed by the server until
+// the connection is closed.
+func watch() {
+	req := request("GET")
+	req.URL.Path += "events"
+	req.Header.Set("Accept", "text/event-stream")
+	// The stream stays open indefinitely.
+	c := client
+	c.Timeout = 0
+	resp := doWith(&c, req)
+	defer resp.Body.Close()
+
+	err := readEvents(resp.Body, func(e serverEvent) {
+		now := time.Now().Format("15:04:05")
+		if e.Item == nil {
+			fmt.Fprintf(os.Stdout, "%s %s #%d\n", now, e.Type, e.ID)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "%s %s #%d: %s\n", now, e.Type, e.ID, e.Item.Body)
+	})
+	if err != nil {
+		printErrLn("Event stream broken: %s", err)
+	}
+}
+
+// serverEvent is a change of an item streamed by the server.
+// Item is nil for removals.
+type serverEvent struct {
+	Type string
+	ID   int64
+	Item *todow.Item
+}
+
+// readEvents calls fn with every event of the stream r
+// until it ends or can't be decoded.
+func readEvents(r io.Reader, fn func(serverEvent)) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var e serverEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line[len("data:"):])), &e); err != nil {
+			return fmt.Errorf("unable to decode event: %s", err)
+		}
+		fn(e)
+	}
+	return s.Err()
+}
+
+// fetchItem returns the item identified by id.
+func fetchItem(id string) *todow.Item {
+	req := request("GET")
+	req.URL.Path += id
+	resp := do(req)
+	defer resp.Body.Close()
+
+	var item todow.Item
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+	return &item
+}
+
+func showItem() {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	format := fs.String("format", "", "Go template the item is printed with, e.g. '{{.ID}} {{.Body}}'")
+	fs.Parse(flag.Args()[1:])
+
+	if fs.NArg() == 0 {
+		printErrLn("Missing item id")
+	}
+	var tmpl *template.Template
+	if *format != "" {
+		tmpl = itemTemplate(*format)
+	}
+
+	item := fetchItem(fs.Arg(0))
+	if tmpl != nil {
+		executeTemplate(tmpl, item)
+		return
+	}
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(todow.DueFormat)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%d\n", item.ID)
+	if item.ParentID != 0 {
+		fmt.Fprintf(tw, "Parent:\t%d\n", item.ParentID)
+	}
+	fmt.Fprintf(tw, "Body:\t%s\n", item.Body)
+	fmt.Fprintf(tw, "Tags:\t%s\n", strings.Join(item.Tags, " "))
+	fmt.Fprintf(tw, "Priority:\t%s\n", item.Priority)
+	fmt.Fprintf(tw, "Created:\t%s\n", formatTime(item.Created))
+	fmt.Fprintf(tw, "Due:\t%s\n", formatTime(item.Due))
+	fmt.Fprintf(tw, "Reminder:\t%s\n", formatTime(item.RemindAt))
+	if item.Pinned {
+		fmt.Fprintf(tw, "Pinned:\t%t\n", item.Pinned)
+	}
+	if !item.StartAt.IsZero() {
+		fmt.Fprintf(tw, "Start:\t%s\n", formatTime(item.StartAt))
+	}
+	if len(item.BlockedBy) > 0 {
+		fmt.Fprintf(tw, "Blocked by:\t%s\n", todow.FormatIDs(item.BlockedBy, " "))
+	}
+	fmt.Fprintf(tw, "Done:\t%t\n", item.Done)
+	fmt.Fprintf(tw, "Completed:\t%s\n", formatTime(item.CompletedAt))
+	keys := make([]string, 0, len(item.Meta))
+	for k := range item.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s:\t%s\n", k, item.Meta[k])
+	}
+	tw.Flush()
+	if item.Notes != "" {
+		fmt.Printf("\n%s\n", item.Notes)
+	}
+	if len(item.Checklist) > 0 {
+		fmt.Println()
+		for i, c := range item.Checklist {
+			box := "[ ]"
+			if c.Done {
+				box = "[x]"
+			}
+			fmt.Printf("%2d %s %s\n", i+1, box, c.Text)
+		}
+	}
+}
+
+func listItems() {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	completedSince := fs.String("completed-since", "", "Only list items completed within the given duration, e.g. 7d")
+	limit := fs.Int("limit", 0, "Maximum number of items to list")
+	page := fs.Int("page", 1, "Page of items to list if -limit is set")
+	sortBy := fs.String("sort", "", "Sort items by id, created, due or priority")
+	desc := fs.Bool("desc", false, "Reverse the sort order")
+	format := fs.String("format", "", "Print the server's json, csv or tsv output, or each item with a Go template, instead of a table")
+	fs.BoolVar(&noColor, "no-color", false, "Don't color overdue, due and done items")
+	fs.BoolVar(&noPager, "no-pager", false, "Don't page output longer than the terminal")
+	done := fs.Bool("done", false, "Only list completed items")
+	pending := fs.Bool("pending", false, "Only list items not completed yet")
+	archived := fs.Bool("archived", false, "List archived items instead of the others")
+	all := fs.Bool("all", false, "Also list items deferred to a later start date")
+	due := fs.String("due", "", "Only list items due today, within a week or overdue")
+	search := fs.String("search", "", "Only list items whose body contains the text")
+	filter := fs.String("filter", "", "Only list the items of a saved filter, see filters")
+	var tagFlags stringList
+	fs.Var(&tagFlags, "tag", "Only list items tagged with the tag, may be given multiple times")
+	fs.Parse(flag.Args()[1:])
+
+	if *done && *pending {
+		printErrLn("--done and --pending exclude each other")
+	}
+
+	accept, ok := map[string]string{
+		"":     "application/json",
+		"json": "application/json",
+		"csv":  "text/csv",
+		"tsv":  "text/plain",
+	}[*format]
+	var tmpl *template.Template
+	if !ok && isTemplate(*format) {
+		tmpl, accept = itemTemplate(*format), "application/json"
+	} else if !ok {
+		printErrLn("Unsupported format %q", *format)
+	}
+
+	req := request("GET")
+	req.Header.Set("Accept", accept)
+	q := req.URL.Query()
+
+	_, tags := todow.ParseTags(fs.Args())
+	for _, t := range append(tags, tagFlags...) {
+		q.Add("tag", strings.TrimPrefix(t, todow.TagPrefix))
+	}
+
+	if *archived {
+		q.Set("archived", "true")
+	}
+	if *all {
+		q.Set("deferred", "true")
+	}
+
+	switch {
+	case *done:
+		q.Set("done", "true")
+	case *pending:
+		q.Set("done", "false")
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	switch *due {
+	case "":
+	case "today":
+		q.Set("due_after", today.Format(time.RFC3339))
+		q.Set("due_before", today.AddDate(0, 0, 1).Format(time.RFC3339))
+	case "week":
+		q.Set("due_after", today.Format(time.RFC3339))
+		q.Set("due_before", today.AddDate(0, 0, 7).Format(time.RFC3339))
+	case "overdue":
+		q.Set("due_before", now.Format(time.RFC3339))
+		q.Set("done", "false")
+	default:
+		printErrLn("Invalid due %q, use today, week or overdue", *due)
+	}
+
+	if *search != "" {
+		q.Set("q", *search)
+	}
+	if *filter != "" {
+		q.Set("filter", *filter)
+	}
+
+	if *completedSince != "" {
+		d, err := parseDuration(*completedSince)
+		if err != nil {
+			printErrLn("Invalid duration %q: %s", *completedSince, err)
+		}
+		q.Set("completed_since", time.Now().Add(-d).Format(time.RFC3339))
+	}
+
+	if *sortBy != "" {
+		q.Set("sort", *sortBy)
+	}
+	if *desc {
+		q.Set("order", "desc")
+	}
+
+	// A page without size is what fits on the terminal
+	// below the header and above the page count.
+	if *limit == 0 && flagGiven(fs, "page") {
+		*limit = defaultPageSize
+		if h := terminalHeight(); h > 3 {
+			*limit = h - 3
+		}
+	}
+	if *limit > 0 {
+		if *page < 1 {
+			printErrLn("Invalid page %d", *page)
+		}
+		q.Set("limit", strconv.Itoa(*limit))
+		q.Set("offset", strconv.Itoa((*page-1)**limit))
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	resp := do(req)
+	defer resp.Body.Close()
+
+	if *format != "" && tmpl == nil {
+		io.Copy(os.Stdout, resp.Body)
 		return
 	}
 
 	col := []*todow.Item{}
-	err = json.NewDecoder(resp.Body).Decode(&col)
+	err := json.NewDecoder(resp.Body).Decode(&col)
 	if err != nil {
 		printErrLn("unable to decode json response: %s", err)
 	}
+
+	if tmpl != nil {
+		for _, v := range col {
+			executeTemplate(tmpl, v)
+		}
+		return
+	}
+	printItems(col)
+
+	if total, err := strconv.Atoi(resp.Header.Get("X-Total-Count")); err == nil && *limit > 0 {
+		pages := (total + *limit - 1) / *limit
+		fmt.Fprintf(os.Stderr, "Page %d of %d, %d items\n", *page, pages, total)
+	}
+}
+
+func searchItems() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing search text")
+	}
+
+	req := request("GET")
+	req.URL.Path += "search"
+	req.URL.RawQuery = url.Values{"q": {strings.Join(flag.Args()[1:], " ")}}.Encode()
+	resp := do(req)
+	defer resp.Body.Close()
+
+	col := []*todow.Item{}
+	if err := json.NewDecoder(resp.Body).Decode(&col); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+
+	printItems(col)
+}
+
+// savedFilter is a filter saved in the settings on the server.
+type savedFilter struct {
+	Name string
+	Expr string
+}
+
+// fetchFilters returns the saved filters.
+func fetchFilters() []savedFilter {
+	req := request("GET")
+	req.URL.Path += "settings"
+	resp := do(req)
 	defer resp.Body.Close()
 
+	var s struct{ Filters []savedFilter }
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+	return s.Filters
+}
+
+func listFilters() {
 	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-	fmt.Fprintln(tw, "ID\tBody\tDone")
-	for _, v := range col {
+	fmt.Fprintln(tw, "Name\tExpression")
+	for _, f := range fetchFilters() {
+		fmt.Fprintf(tw, "%s\t%s\n", f.Name, f.Expr)
+	}
+	tw.Flush()
+}
+
+// saveFilter saves the filter named by the first argument with the
+// expression of the others, or removes it if there are none.
+func saveFilter() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing filter name")
+	}
+	name, expr := flag.Args()[1], strings.Join(flag.Args()[2:], " ")
+
+	var filters []savedFilter
+	found := false
+	for _, f := range fetchFilters() {
+		if f.Name != name {
+			filters = append(filters, f)
+			continue
+		}
+		found = true
+		if expr != "" {
+			filters = append(filters, savedFilter{name, expr})
+		}
+	}
+	switch {
+	case !found && expr == "":
+		printErrLn("No filter named %q", name)
+	case !found:
+		filters = append(filters, savedFilter{name, expr})
+	}
+	if filters == nil {
+		filters = []savedFilter{}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(struct{ Filters []savedFilter }{filters}); err != nil {
+		printErrLn("Unable to marshal filters to json: %s", err)
+	}
+	req := request("PATCH")
+	req.URL.Path += "settings"
+	req.Body = ioutil.NopCloser(&buf)
+	resp := do(req)
+	defer resp.Body.Close()
+
+	if expr == "" {
+		fmt.Printf("Removed filter %q\n", name)
+		return
+	}
+	fmt.Printf("Saved filter %q\n", name)
+}
+
+// setDigest shows the digest settings, or mails a digest to the address
+// given as first argument on the schedule given by the others, or stops
+// mailing them if the argument is off.
+func setDigest() {
+	if len(flag.Args()) == 1 {
+		req := request("GET")
+		req.URL.Path += "settings"
+		resp := do(req)
+		defer resp.Body.Close()
+
+		var s struct {
+			Digest *struct {
+				To, Schedule string
+				Sent         time.Time
+			}
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			printErrLn("unable to decode json response: %s", err)
+		}
+		if s.Digest == nil {
+			fmt.Println("No digest")
+			return
+		}
+		fmt.Printf("Mailing a digest %s to %s, last on %s\n", s.Digest.Schedule, s.Digest.To, s.Digest.Sent.Format(todow.DueFormat))
+		return
+	}
+
+	var d struct{ To, Schedule string }
+	switch {
+	case flag.Args()[1] == "off":
+	case len(flag.Args()) == 2:
+		printErrLn("Missing digest schedule")
+	default:
+		d.To, d.Schedule = flag.Args()[1], strings.Join(flag.Args()[2:], " ")
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(struct{ Digest interface{} }{d}); err != nil {
+		printErrLn("Unable to marshal digest to json: %s", err)
+	}
+	req := request("PATCH")
+	req.URL.Path += "settings"
+	req.Body = ioutil.NopCloser(&buf)
+	resp := do(req)
+	defer resp.Body.Close()
+
+	if d.To == "" {
+		fmt.Println("Stopped digests")
+		return
+	}
+	fmt.Printf("Mailing a digest %s to %s\n", d.Schedule, d.To)
+}
+
+// subscribe subscribes to the ntfy topic given as first argument, on the
+// server given as second one or the one the server uses by default.
+func subscribe() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing topic")
+	}
+	body := struct{ Topic, Server string }{Topic: flag.Args()[1]}
+	if len(flag.Args()) > 2 {
+		body.Server = flag.Args()[2]
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		printErrLn("Unable to marshal subscription to json: %s", err)
+	}
+	req := request("POST")
+	req.URL.Path += "settings/push"
+	req.Body = ioutil.NopCloser(&buf)
+	resp := do(req)
+	defer resp.Body.Close()
+
+	var sub struct{ ID, Topic string }
+	if err := json.NewDecoder(resp.Body).Decode(&sub); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+	fmt.Printf("Pushing reminders to topic %s, subscription %s\n", sub.Topic, sub.ID)
+}
+
+func listSubscriptions() {
+	req := request("GET")
+	req.URL.Path += "settings"
+	resp := do(req)
+	defer resp.Body.Close()
+
+	var s struct {
+		Push []struct {
+			ID, Topic, Server string
+			Created           time.Time
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+
+	// IDs fill a tab stop, so cells are padded.
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "ID\tTopic\tServer\tCreated")
+	for _, p := range s.Push {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.ID, p.Topic, p.Server, p.Created.Format(todow.DueFormat))
+	}
+	tw.Flush()
+}
+
+func unsubscribe() {
+	if len(flag.Args()) == 1 {
+		printErrLn("Missing subscription id")
+	}
+
+	req := request("DELETE")
+	req.URL.Path += "settings/push/" + flag.Args()[1]
+	resp := do(req)
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+// printItems prints col as a table, children indented below their parents,
+// paged if it is longer than the terminal.
+func printItems(col []*todow.Item) {
+	color := useColor()
+	now := time.Now()
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 0, '\t', 0)
+	if color {
+		fmt.Fprint(tw, colorNone)
+	}
+	fmt.Fprintln(tw, "ID\tBody\tTags\tCreated\tDue\tDone\tCompleted")
+	for _, v := range todow.Nest(col) {
 		var done rune
 
 		if v.Done {
@@ -151,28 +1258,134 @@ func listItems() {
 		} else {
 			done = ' '
 		}
+
+		body := v.Body
+		if v.Priority != "" {
+			body = "(" + v.Priority + ") " + body
+		}
+
+		var completed string
+		if !v.CompletedAt.IsZero() {
+			completed = v.CompletedAt.Format(todow.DueFormat)
+		}
+
+		if color {
+			fmt.Fprint(tw, rowColor(v.Item, now))
+		}
 		fmt.Fprintf(
 			tw,
-			"%d\t%s\t%c",
+			"%d\t%s\t%s\t%s\t%s\t%c\t%s",
 			v.ID,
-			v.Body,
+			strings.Repeat("  ", v.Depth)+body,
+			strings.Join(v.Tags, " "),
+			relTime(v.Created, now),
+			relTime(v.Due, now),
 			done,
+			completed,
 		)
+		if color {
+			fmt.Fprint(tw, colorReset)
+		}
 		fmt.Fprintln(tw)
 	}
 	tw.Flush()
+	pageOutput(buf.Bytes())
+}
+
+func showStats() {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	weeks := fs.Int("weeks", 8, "Number of weeks to count added and completed items of")
+	fs.Parse(flag.Args()[1:])
+
+	req := request("GET")
+	req.URL.Path += "stats"
+	req.URL.RawQuery = url.Values{"weeks": {strconv.Itoa(*weeks)}}.Encode()
+	resp := do(req)
+	defer resp.Body.Close()
+
+	var stats struct {
+		Open, Done int
+		Weeks      []struct {
+			Start            time.Time
+			Added, Completed int
+		}
+		OldestOpen    *todow.Item
+		AvgCompletion time.Duration
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		printErrLn("unable to decode json response: %s", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Open:\t%d\n", stats.Open)
+	fmt.Fprintf(tw, "Done:\t%d\n", stats.Done)
+	if stats.OldestOpen != nil {
+		fmt.Fprintf(tw, "Oldest open:\t#%d %s, created %s\n", stats.OldestOpen.ID, stats.OldestOpen.Body, stats.OldestOpen.Created.Format(todow.DueFormat))
+	}
+	if stats.AvgCompletion > 0 {
+		fmt.Fprintf(tw, "Avg. time to completion:\t%s\n", todow.FormatDays(stats.AvgCompletion))
+	}
+	tw.Flush()
+
+	if len(stats.Weeks) == 0 {
+		return
+	}
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Week of\tAdded\tCompleted\t")
+	for _, w := range stats.Weeks {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t\n", w.Start.Format("2006-01-02"), w.Added, w.Completed)
+	}
+	tw.Flush()
+}
+
+// parseDuration is like time.ParseDuration but also accepts
+// a number of days or weeks, e.g. "7d" or "2w".
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
 }
 
 func request(method string) *http.Request {
 	req, _ := http.NewRequest(method, *domain+todow.APIPath, nil)
 	req.SetBasicAuth(*user, *pass)
 	req.Header.Set("Content-Type", "application/json")
+	if *list != "" {
+		req.Header.Set("X-Todow-List", *list)
+	}
 	return req
 }
 
+// flagGiven reports whether the flag name was given in fs.
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) { given = given || f.Name == name })
+	return given
+}
+
+// stringList is a flag that may be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 func printErrLn(f string, args ...interface{}) {
-	fmt.Printf(f+"\n", args...)
-	os.Exit(1)
+	fail(exitError, f, args...)
 }
 
 var help = `todow [COMMAND] [ARGUMENTS]...
@@ -181,18 +1394,257 @@ Flags:
 	-h
 		Todow hostname
 
+	-u [NAME] -p [PASSWORD]
+		Log in as NAME, the password defaults to $TODOW_PASSWORD
+
+	-profile [NAME]
+		Use the server, credentials and list of a profile of
+		~/.config/todow/config.toml, flags taking precedence
+
+	-l [OWNER/TAG]
+		Work on the items of a list OWNER shared with you
+
+	-local [-store bolt|todotxt] [-db FILE]
+		Work on a store on this machine instead of a server,
+		~/.local/share/todow/todos.db by default. Sharing, invitations,
+		stats, import, watch and saved filters need a server
+
+	-parent [ID]
+		Add the new item below the given item
+
+	-r
+		Also complete or reopen all child items
+
+	-f
+		Complete items blocked by open items, or add items whose
+		body closely matches that of open ones
+
+	-retries [N] -retry-wait [DURATION]
+		Retry failed requests N times, waiting DURATION before the
+		first retry and twice as long before each next one
+
 
 Commands:
-	ls
-		List all items
+	ls [--done|--pending] [--archived] [--all] [--due today|week|overdue] [--tag TAG]... [--search TEXT]
+	   [--completed-since DURATION] [--sort FIELD [--desc]] [--limit N] [--page P] [--format json|csv|tsv|TEMPLATE]
+	   [--filter NAME] [--no-color] [--no-pager] [+TAG]...
+		List all items, optionally only those tagged with all given tags,
+		completed or not, due today, within a week or overdue, whose
+		body contains TEXT or completed within DURATION, e.g. 7d, 2w or 12h,
+		or those of the saved filter NAME.
+		With --archived, list archived items instead. Items deferred to
+		a later start date are only listed with --all.
+		Overdue items are shown in red, items due today in yellow and
+		done items dimmed, unless --no-color is given or $NO_COLOR is set.
+		Sort by id, created, due or priority.
+		With --limit, list page P of N items each, with --page only, of as
+		many items as fit on the terminal. Tables longer than the terminal
+		are shown with $TODOW_PAGER or $PAGER, less by default, unless
+		--no-pager is given.
+		With --format, print the raw server output instead of a table,
+		or, if it contains {{, each item with the Go template, e.g.
+		'{{.ID}} {{.Body}} {{join .Tags ","}} {{date .Due}} {{rel .Created}}'.
+		The fields are those of the json output
+
+	add [--notes NOTES] [--start WHEN] [--meta KEY=VALUE]... [--force] [BODY] [+TAG]... [!PRIORITY] [WHEN]
+		Add item, words prefixed with + are tags, words prefixed with !
+		set the priority, e.g. !high or !b, and dates and times like
+		"tomorrow 3pm", "friday", "in 2 hours" or 24.12. the due date.
+		With - as BODY, the item is read from stdin. Items closely
+		matching open ones are refused with the candidates listed,
+		unless --force is given
+
+	add --each-line [--force] [+TAG]...
+		Add an item for every line of stdin, with the given tags,
+		stopping at the first one refused as duplicate
+
+	rm [ID]...
+		Remove items, given by ID or ranges like 7-9. Removed items are
+		kept in the trash for a while, 30 days unless the server is told
+		otherwise
+
+	trash
+		List removed items
+
+	restore [ID]...
+		Move items out of the trash
+
+	c [ID]...
+		Mark items complete. Items blocked by open items are only
+		completed with -f
+
+	u [ID]...
+		Mark items not complete
+
+	archive [ID]...
+		Archive items, which keeps them out of ls unless --archived is
+		given. Servers archive items completed longer than -archive-after
+		ago on their own
+
+	unarchive [ID]...
+		Take items out of the archive
+
+	meta [ID] [KEY=VALUE|KEY=]...
+		Set metadata fields of an item, e.g. issue=GH-12, or remove
+		them with an empty value. Keys consist of letters, digits, .,
+		- and _
+
+	pin [ID]...
+		Pin items, which lists them before all others whatever the order
+
+	unpin [ID]...
+		Unpin items
+
+	snooze [ID] [DURATION]
+		Postpone the reminder of an item, e.g. by 1h30m
+
+	due [ID] [WHEN|none]
+		Set the due date of an item, e.g. to "friday 3pm" or 2016-05-25,
+		or clear it
+
+	start [ID] [WHEN|none]
+		Defer an item until WHEN, e.g. "in 6 months", which hides it
+		from ls unless --all is given, or clear its start date
+
+	pri [ID] [LEVEL|none]
+		Set the priority of an item to high, medium, low or A to Z,
+		or clear it
+
+	search [TEXT]
+		List items whose body, notes or tags contain words starting with
+		each word of TEXT
+
+	filters
+		List saved filters
+
+	filter [NAME] [EXPRESSION]...
+		Save a filter, listed with ls --filter NAME and in the web UI,
+		or remove it if no EXPRESSION is given. Its words select items
+		by +TAG, due:today, due:week, due:overdue or due:3d, pri:B for
+		priority B or higher, done:true or done:false, and by words of
+		their body, e.g. todow filter "Work urgent" +work pri:A due:week
+
+	digest [EMAIL SCHEDULE|off]
+		Mail a summary of overdue items, items due today and items added
+		since the last one to EMAIL on SCHEDULE, "daily HH:MM" or "weekly
+		WEEKDAY HH:MM" in the server's time zone, e.g. todow digest
+		me@example.com weekly monday 07:00, or stop it with off. Without
+		arguments, show the digest settings
+
+	subscribe [TOPIC] [SERVER]
+		Push reminders and items falling due to an ntfy topic, on
+		SERVER or the one the server is told, https://ntfy.sh by
+		default. Subscribe to the topic in the ntfy app to receive them
+
+	subscriptions
+		List push subscriptions
+
+	unsubscribe [ID]
+		Stop pushing to a topic
+
+	show [--format TEMPLATE] [ID]
+		Show all fields of an item, or those used by TEMPLATE, see ls
+
+	stats [--weeks N]
+		Show counts of open and done items, of items added and completed
+		in each of the last N weeks, the oldest open item and the average
+		time to completion
+
+	notify [--daemon [--interval DURATION]] [--command CMD]
+		Raise a desktop notification for every overdue item. With --daemon,
+		keep running and notify when items fall due or reminders fire.
+		Notifications are raised with notify-send, osascript on macOS,
+		or CMD run with title and message as arguments
+
+	agenda [--short] [--no-color]
+		Print open items due today or overdue, nothing if there are none,
+		and exit with 8 if any is overdue. With --short, print only their
+		numbers, e.g. for status bars
+
+	watch
+		Print changes to items as they happen
+
+	share [+TAG]
+		Create a public read-only link to the items tagged with TAG,
+		or to all items
+
+	shares
+		List share links
+
+	unshare [TOKEN]
+		Revoke a share link
+
+	invite [-w] [+TAG] [USER|EMAIL]
+		Share the list of items tagged with TAG with another user,
+		or mail them an invitation. With -w, they may change it
+
+	invites
+		List invitations to your lists and the lists shared with you
+
+	join [TOKEN]
+		Accept a mailed invitation
+
+	uninvite [TOKEN]
+		Revoke an invitation, or leave a list shared with you
+
+	edit [ID] [BODY] [+TAG]...
+		Replace the body of an item, and its tags if any are given.
+		Without BODY, edit the item in $VISUAL or $EDITOR, its tags,
+		due date, reminder, start date, priority and state given above
+		the body, its notes and checklist, one "[ ] ENTRY" per line,
+		below it
+
+	notes [ID] [NOTES|-]
+		Replace the notes of an item, read from stdin with -, or clear
+		them if NOTES is missing
+
+	check [ID] [N]...
+		Tick the Nth entries of the checklist of an item, as numbered
+		by show
+
+	uncheck [ID] [N]...
+		Untick the Nth entries of the checklist of an item
+
+	block [ID] [BLOCKER]...
+		Block an item by other items, which need to be completed first
+
+	unblock [ID] [BLOCKER]...
+		Unblock an item from the given items, or from all without any
+
+	export [-f json|csv|org|md] [FILE]
+		Export all items to FILE or stdout, with org as an Emacs
+		org-mode outline, with md as a Markdown task list
+
+	import [-f json|csv|todotxt] [FILE]
+		Add all items of FILE, skipping duplicates
+
+	import --from todoist [--token TOKEN] [--sync [--interval DURATION]]
+		Add the active tasks of Todoist, with their labels and project
+		as tags, using the API token from Todoist's integration
+		settings, $TODOIST_TOKEN by default. Tasks imported before are
+		updated, and their items completed once the tasks are completed
+		or removed in Todoist. With --sync, keep importing every
+		DURATION, 15m by default
+
+	backup [FILE]
+		Download a backup of the server's store, restore it
+		with todow serve -restore FILE
 
-	add [BODY]
-		Add item
+	compact
+		Give back space left unused by the server's store
 
-	rm [ID]
-		Remove item
+	serve [FLAGS] [adduser NAME]
+		Run the server, see todow serve -help for its flags, which may
+		also be set in the [server] table of the config file. adduser
+		adds a user to the server's users file
 
-	c [ID]
-		Mark item complete
 
+Exit status:
+	1	invalid usage or other error
+	3	server unreachable
+	4	authentication failed or access denied
+	5	item or resource not found
+	6	request rejected by the server
+	7	server error
+	8	agenda found overdue items
 `
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// notify raises a desktop notification for every overdue item. With
+// --daemon, it keeps running instead and notifies whenever an item falls
+// due or, when working with a server, the reminder of an item fires.
+func notify() {
+	fs := flag.NewFlagSet("notify", flag.ExitOnError)
+	daemon := fs.Bool("daemon", false, "Keep running and notify as items fall due and reminders fire")
+	interval := fs.Duration("interval", time.Minute, "Interval between checks for items falling due")
+	command := fs.String("command", "", "Command raising notifications, run with title and message as arguments; notify-send or osascript by default")
+	fs.Parse(flag.Args()[1:])
+
+	n := desktopNotifier{*command}
+	if !*daemon {
+		req := openItemsRequest()
+		req.URL.RawQuery += "&due_before=" + time.Now().Format(time.RFC3339)
+		resp := do(req)
+		defer resp.Body.Close()
+
+		var col []*todow.Item
+		if err := json.NewDecoder(resp.Body).Decode(&col); err != nil {
+			printErrLn("unable to decode json response: %s", err)
+		}
+		for _, v := range col {
+			n.notify("Overdue", v)
+		}
+		return
+	}
+
+	// Servers clear reminders when they fire and stream them as events,
+	// without a server reminders are checked like due times.
+	if !*local {
+		go streamReminders(n)
+	}
+
+	last := time.Now()
+	for range time.Tick(*interval) {
+		now := time.Now()
+		col, err := pollOpenItems()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to check due items: %s\n", err)
+			continue
+		}
+
+		for _, v := range col {
+			if v.Due.After(last) && !v.Due.After(now) {
+				n.notify("Due", v)
+			}
+			if *local && v.RemindAt.After(last) && !v.RemindAt.After(now) {
+				n.notify("Reminder", v)
+			}
+		}
+		last = now
+	}
+}
+
+// openItemsRequest returns a request for all open items, soonest due first.
+func openItemsRequest() *http.Request {
+	req := request("GET")
+	req.URL.RawQuery = "done=false&sort=due"
+	return req
+}
+
+// pollOpenItems returns all open items. Unlike do, it returns network
+// and server failures, which a daemon outlives, instead of exiting.
+func pollOpenItems() ([]*todow.Item, error) {
+	req := openItemsRequest()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := pollStatus(req, resp); err != nil {
+		return nil, err
+	}
+
+	var col []*todow.Item
+	if err := json.NewDecoder(resp.Body).Decode(&col); err != nil {
+		return nil, fmt.Errorf("unable to decode json response: %s", err)
+	}
+	return col, nil
+}
+
+// pollStatus returns an error for failed responses. Rejected
+// credentials won't get any better, so they still exit.
+func pollStatus(req *http.Request, resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		statusError(req, resp)
+	case resp.StatusCode >= 300:
+		p, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(p)))
+	}
+	return nil
+}
+
+// streamReminders notifies of the reminder events streamed by the
+// server, reconnecting whenever the stream breaks. It never returns.
+func streamReminders(n desktopNotifier) {
+	c := client
+	c.Timeout = 0
+	for {
+		req := request("GET")
+		req.URL.Path += "events"
+		req.Header.Set("Accept", "text/event-stream")
+
+		resp, err := c.Do(req)
+		if err == nil {
+			err = pollStatus(req, resp)
+			if err == nil {
+				err = readEvents(resp.Body, func(e serverEvent) {
+					if e.Type == "reminder" && e.Item != nil {
+						n.notify("Reminder", e.Item)
+					}
+				})
+			}
+			resp.Body.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Reminder stream broken: %s\n", err)
+		}
+		time.Sleep(*retryWait * 10)
+	}
+}
+
+// desktopNotifier raises desktop notifications with command, or with
+// the notification tool of the operating system if it is empty.
+type desktopNotifier struct {
+	command string
+}
+
+// notify raises a notification titled what for item and prints it,
+// so it isn't lost if no notification can be shown.
+func (n desktopNotifier) notify(what string, item *todow.Item) {
+	title := "todow: " + what
+	msg := fmt.Sprintf("#%d %s", item.ID, item.Body)
+	if !item.Due.IsZero() {
+		msg += ", due " + item.Due.Local().Format(todow.DueFormat)
+	}
+	fmt.Printf("%s %s: %s\n", time.Now().Format("15:04:05"), what, msg)
+
+	var cmd *exec.Cmd
+	switch {
+	case strings.TrimSpace(n.command) != "":
+		args := strings.Fields(n.command)
+		cmd = exec.Command(args[0], append(args[1:], title, msg)...)
+	case runtime.GOOS == "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(msg), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=todow", title, msg)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to raise notification: %s %s\n", err, strings.TrimSpace(string(out)))
+	}
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
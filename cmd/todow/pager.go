@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+
+	"golang.org/x/term"
+)
+
+// noPager disables paging of output longer than the terminal.
+var noPager bool
+
+// defaultPageSize is the number of items of a page if neither
+// --limit is given nor the terminal height is known.
+const defaultPageSize = 20
+
+// terminalHeight returns the number of lines of the terminal
+// stdout is, or 0 if it isn't one.
+func terminalHeight() int {
+	_, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return h
+}
+
+// pageOutput writes out to stdout. Like git, it runs $TODOW_PAGER or $PAGER,
+// less by default, to show output that doesn't fit on the terminal.
+func pageOutput(out []byte) {
+	h := terminalHeight()
+	if noPager || h == 0 || bytes.Count(out, []byte("\n")) < h {
+		os.Stdout.Write(out)
+		return
+	}
+
+	pager := os.Getenv("TODOW_PAGER")
+	if pager == "" {
+		pager = os.Getenv("PAGER")
+	}
+	if pager == "" {
+		pager = "less"
+	}
+	if pager == "cat" {
+		os.Stdout.Write(out)
+		return
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = bytes.NewReader(out)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	// Quit if the output fits after all, keep colors and the screen.
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	if err := cmd.Run(); err != nil {
+		// Pagers quit early are fine, missing ones are not.
+		if _, ok := err.(*exec.ExitError); !ok {
+			os.Stdout.Write(out)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/quickadd"
+)
+
+// todoistMetaKey is the metadata field linking items to the Todoist
+// tasks they were imported from.
+const todoistMetaKey = "todoist"
+
+// A todoistTask is an active task as the Todoist REST API returns it.
+type todoistTask struct {
+	ID          string
+	Content     string
+	Description string
+	Labels      []string
+	// Priority ranges from 1, normal, to 4, urgent.
+	Priority  int
+	ProjectID string  `json:"project_id"`
+	ParentID  *string `json:"parent_id"`
+	Due       *struct {
+		Date     string
+		Datetime string
+	}
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// importTodoist imports the active tasks of a Todoist account, and with
+// --sync keeps doing so. Tasks imported before are updated instead,
+// and the items of tasks completed or removed in Todoist are completed.
+func importTodoist(api, token string, sync bool, interval time.Duration) {
+	if token == "" {
+		token = os.Getenv("TODOIST_TOKEN")
+	}
+	if token == "" {
+		printErrLn("Missing Todoist API token, use --token or $TODOIST_TOKEN")
+	}
+	t := todoist{api: strings.TrimSuffix(api, "/") + "/", token: token}
+
+	for {
+		added, updated, completed := t.sync()
+		if !sync || added+updated+completed > 0 {
+			fmt.Printf("Imported %d tasks from Todoist, updated %d items, completed %d\n", added, updated, completed)
+		}
+		if !sync {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// todoist is a client of the Todoist REST API at api.
+type todoist struct {
+	api, token string
+}
+
+// get decodes the JSON response to a GET request of path into v.
+func (t todoist) get(path string, v interface{}) {
+	req, _ := http.NewRequest("GET", t.api+path, nil)
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	c := http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Do(req)
+	if err != nil {
+		printErrLn("Unable to reach Todoist: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		p, _ := ioutil.ReadAll(resp.Body)
+		printErrLn("Todoist responded with %s: %s", resp.Status, strings.TrimSpace(string(p)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		printErrLn("Unable to decode Todoist response: %s", err)
+	}
+}
+
+// sync imports the active tasks once and returns how many items were
+// added, updated and completed.
+func (t todoist) sync() (added, updated, completed int) {
+	var tasks []todoistTask
+	t.get("tasks", &tasks)
+	var projects []struct{ ID, Name string }
+	t.get("projects", &projects)
+	tags := map[string]string{}
+	for _, p := range projects {
+		// Tasks without a project are in the inbox.
+		if p.Name != "Inbox" {
+			tags[p.ID] = todoistTag(p.Name)
+		}
+	}
+
+	// ids maps task IDs to the IDs of the items imported from them.
+	ids := map[string]int64{}
+	existing := map[string]*todow.Item{}
+	for _, item := range allItems() {
+		if id := item.Meta[todoistMetaKey]; id != "" {
+			existing[id] = item
+			ids[id] = item.ID
+		}
+	}
+
+	active := map[string]bool{}
+	for _, task := range todoistOrder(tasks) {
+		active[task.ID] = true
+		item := todoistItem(task, tags)
+		if task.ParentID != nil {
+			item.ParentID = ids[*task.ParentID]
+		}
+
+		old, ok := existing[task.ID]
+		if !ok {
+			ids[task.ID] = postImported(item)
+			added++
+			continue
+		}
+		// Items completed since stay so.
+		if !old.Done && todoistChanged(old, item) {
+			patchImported(old.ID, &todow.ItemPatch{
+				Body:     &item.Body,
+				Notes:    &item.Notes,
+				Tags:     &item.Tags,
+				Due:      &item.Due,
+				Priority: &item.Priority,
+			})
+			updated++
+		}
+	}
+
+	done := true
+	for id, item := range existing {
+		if !active[id] && !item.Done {
+			patchImported(item.ID, &todow.ItemPatch{Done: &done})
+			completed++
+		}
+	}
+	return added, updated, completed
+}
+
+// todoistOrder orders tasks so that parents come before their children.
+func todoistOrder(tasks []todoistTask) []todoistTask {
+	placed := map[string]bool{}
+	var res []todoistTask
+	for len(res) < len(tasks) {
+		n := len(res)
+		for _, task := range tasks {
+			if placed[task.ID] {
+				continue
+			}
+			// Parents that aren't active are ignored.
+			if p := task.ParentID; p != nil && !placed[*p] && todoistHas(tasks, *p) {
+				continue
+			}
+			placed[task.ID] = true
+			res = append(res, task)
+		}
+		if len(res) == n {
+			break
+		}
+	}
+	return res
+}
+
+func todoistHas(tasks []todoistTask, id string) bool {
+	for _, t := range tasks {
+		if t.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// todoistItem returns the item of task, tagged with its labels and the
+// tag of its project in tags, if any.
+func todoistItem(task todoistTask, tags map[string]string) todow.Item {
+	item := todow.Item{
+		Body:     task.Content,
+		Notes:    task.Description,
+		Priority: map[int]string{4: "A", 3: "B", 2: "C"}[task.Priority],
+		Meta:     map[string]string{todoistMetaKey: task.ID},
+		Created:  task.CreatedAt,
+		Tags:     []string{},
+	}
+	if item.Created.IsZero() {
+		item.Created = time.Now()
+	}
+	for _, l := range task.Labels {
+		item.Tags = append(item.Tags, todoistTag(l))
+	}
+	if tag, ok := tags[task.ProjectID]; ok {
+		item.Tags = append(item.Tags, tag)
+	}
+
+	if d := task.Due; d != nil {
+		if due, err := time.Parse(time.RFC3339, d.Datetime); err == nil {
+			item.Due = due.Local()
+		} else if due, err := time.ParseInLocation("2006-01-02T15:04:05", d.Datetime, time.Local); err == nil {
+			// Floating times are in the local time zone.
+			item.Due = due
+		} else if due, err := quickadd.ParseWhen(d.Date, time.Now()); err == nil {
+			item.Due = due
+		}
+	}
+	return item
+}
+
+// todoistTag turns a Todoist project or label name into a tag.
+func todoistTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), "-"))
+}
+
+// todoistChanged reports whether the fields imported from a task
+// differ between old and item.
+func todoistChanged(old *todow.Item, item todow.Item) bool {
+	return old.Body != item.Body || old.Notes != item.Notes || old.Priority != item.Priority ||
+		!old.Due.Equal(item.Due) || !reflect.DeepEqual(append([]string{}, old.Tags...), item.Tags)
+}
+
+// allItems returns all items, archived or not.
+func allItems() []*todow.Item {
+	var col []*todow.Item
+	for _, archived := range []bool{false, true} {
+		req := request("GET")
+		req.Header.Set("Accept", "application/json")
+		q := url.Values{"deferred": {"true"}}
+		if archived {
+			q.Set("archived", "true")
+		}
+		req.URL.RawQuery = q.Encode()
+		resp := do(req)
+		var page []*todow.Item
+		err := json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			printErrLn("unable to decode json response: %s", err)
+		}
+		col = append(col, page...)
+	}
+	return col
+}
+
+// postImported adds item, even if it resembles an open item, and
+// returns its ID.
+func postImported(item todow.Item) int64 {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(item); err != nil {
+		printErrLn("Unable to marshal item to json: %s", err)
+	}
+	req := request("POST")
+	req.URL.RawQuery = "force=true"
+	req.Body = ioutil.NopCloser(&buf)
+	resp := do(req)
+	defer resp.Body.Close()
+
+	p, _ := ioutil.ReadAll(resp.Body)
+	var id int64
+	if _, err := fmt.Sscanf(string(p), "Added item #%d", &id); err != nil {
+		printErrLn("Unexpected response to adding an item: %s", strings.TrimSpace(string(p)))
+	}
+	return id
+}
+
+// patchImported applies patch to the item identified by id, completing
+// it even if it is blocked.
+func patchImported(id int64, patch *todow.ItemPatch) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(patch); err != nil {
+		printErrLn("Unable to marshal patch to json: %s", err)
+	}
+	req := request("PATCH")
+	req.URL.Path += strconv.FormatInt(id, 10)
+	req.URL.RawQuery = "force=true"
+	req.Body = ioutil.NopCloser(&buf)
+	do(req).Body.Close()
+}
@@ -0,0 +1,214 @@
+package todow
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVHeader names the columns written by WriteCSV.
+var CSVHeader = []string{
+	"ID", "ParentID", "Body", "Tags", "Priority",
+	"Created", "Due", "RemindAt", "Done", "CompletedAt", "Notes",
+	"Checklist", "BlockedBy", "StartAt", "Pinned", "Meta",
+}
+
+// WriteCSV writes col as CSV including a header row.
+// Tags and the IDs of blocking items are separated by spaces, times are formatted as RFC 3339
+// and left empty if zero. The checklist is formatted by FormatChecklist,
+// the metadata like a URL query.
+func WriteCSV(w io.Writer, col []*Item) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+
+	for _, v := range col {
+		if err := cw.Write(csvRecord(v)); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTSV writes col as tab-separated text with the columns of WriteCSV.
+// Fields are not quoted; tabs and line breaks within them are replaced
+// by spaces so every item stays on one line.
+func WriteTSV(w io.Writer, col []*Item) error {
+	clean := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+	if _, err := fmt.Fprintln(w, strings.Join(CSVHeader, "\t")); err != nil {
+		return err
+	}
+	for _, v := range col {
+		rec := csvRecord(v)
+		for i := range rec {
+			rec[i] = clean.Replace(rec[i])
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(rec, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func csvRecord(v *Item) []string {
+	return []string{
+		strconv.FormatInt(v.ID, 10),
+		strconv.FormatInt(v.ParentID, 10),
+		v.Body,
+		strings.Join(v.Tags, " "),
+		v.Priority,
+		formatCSVTime(v.Created),
+		formatCSVTime(v.Due),
+		formatCSVTime(v.RemindAt),
+		strconv.FormatBool(v.Done),
+		formatCSVTime(v.CompletedAt),
+		v.Notes,
+		FormatChecklist(v.Checklist),
+		FormatIDs(v.BlockedBy, " "),
+		formatCSVTime(v.StartAt),
+		strconv.FormatBool(v.Pinned),
+		formatMeta(v.Meta),
+	}
+}
+
+func formatMeta(m map[string]string) string {
+	v := url.Values{}
+	for k, s := range m {
+		v.Set(k, s)
+	}
+	return v.Encode()
+}
+
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ReadCSV reads items written by WriteCSV. Columns are matched by the
+// header row, so they may appear in any order and all but Body may be
+// missing.
+func ReadCSV(r io.Reader) ([]*Item, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %s", err)
+	}
+
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.TrimSpace(h)] = i
+	}
+	if _, ok := cols["Body"]; !ok {
+		return nil, fmt.Errorf("missing Body column")
+	}
+
+	var col []*Item
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return col, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		item, err := parseCSVRecord(cols, rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		col = append(col, item)
+	}
+}
+
+func parseCSVRecord(cols map[string]int, rec []string) (*Item, error) {
+	field := func(name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	item := &Item{
+		Body:      field("Body"),
+		Tags:      strings.Fields(field("Tags")),
+		Priority:  field("Priority"),
+		Notes:     field("Notes"),
+		Checklist: ParseChecklist(field("Checklist")),
+	}
+	if item.Body == "" {
+		return nil, fmt.Errorf("empty body")
+	}
+
+	var err error
+	if s := field("ID"); s != "" {
+		if item.ID, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid ID: %s", err)
+		}
+	}
+	if s := field("ParentID"); s != "" {
+		if item.ParentID, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid ParentID: %s", err)
+		}
+	}
+	for _, s := range strings.Fields(field("BlockedBy")) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BlockedBy: %s", err)
+		}
+		item.BlockedBy = append(item.BlockedBy, id)
+	}
+	if s := field("Done"); s != "" {
+		if item.Done, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid Done: %s", err)
+		}
+	}
+	if s := field("Meta"); s != "" {
+		v, err := url.ParseQuery(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Meta: %s", err)
+		}
+		item.Meta = map[string]string{}
+		for k := range v {
+			item.Meta[k] = v.Get(k)
+		}
+		if err := CheckMeta(item.Meta); err != nil {
+			return nil, err
+		}
+	}
+	if s := field("Pinned"); s != "" {
+		if item.Pinned, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid Pinned: %s", err)
+		}
+	}
+
+	for name, t := range map[string]*time.Time{
+		"Created":     &item.Created,
+		"Due":         &item.Due,
+		"RemindAt":    &item.RemindAt,
+		"CompletedAt": &item.CompletedAt,
+		"StartAt":     &item.StartAt,
+	} {
+		s := field(name)
+		if s == "" {
+			continue
+		}
+		if *t, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, err)
+		}
+	}
+
+	return item, nil
+}
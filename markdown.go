@@ -0,0 +1,58 @@
+package todow
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// markdownEscaper escapes the characters that would format the text
+// of GitHub-flavored Markdown.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`,
+	"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`,
+)
+
+// WriteMarkdown writes col as a GitHub-flavored Markdown task list.
+// Items are nested below their parents and followed by their priority,
+// tags and due date. Notes are indented below them, checklist entries
+// become nested tasks.
+func WriteMarkdown(w io.Writer, col []*Item) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range Nest(col) {
+		indent := strings.Repeat("  ", v.Depth)
+
+		bw.WriteString(indent + "- " + markdownBox(v.Done) + " ")
+		if v.Priority != "" {
+			bw.WriteString("**(" + v.Priority + ")** ")
+		}
+		bw.WriteString(markdownEscaper.Replace(v.Body))
+		for _, t := range v.Tags {
+			bw.WriteString(" `" + TagPrefix + t + "`")
+		}
+		if !v.Due.IsZero() {
+			bw.WriteString(" *due " + v.Due.In(time.Local).Format(DueFormat) + "*")
+		}
+		bw.WriteString("\n")
+
+		if v.Notes != "" {
+			bw.WriteString("\n")
+			for _, line := range strings.Split(strings.TrimRight(v.Notes, "\n"), "\n") {
+				bw.WriteString(strings.TrimRight(indent+"  "+line, " ") + "\n")
+			}
+			bw.WriteString("\n")
+		}
+		for _, c := range v.Checklist {
+			bw.WriteString(indent + "  - " + markdownBox(c.Done) + " " + markdownEscaper.Replace(c.Text) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func markdownBox(done bool) string {
+	if done {
+		return "[x]"
+	}
+	return "[ ]"
+}
@@ -0,0 +1,99 @@
+package todow
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// WriteOrg writes col as an Emacs org-mode outline. Items become TODO or
+// DONE headings nested below their parents, with their priority, tags,
+// start date as SCHEDULED, due date as DEADLINE and completion as CLOSED.
+// Notes and the checklist follow the heading.
+func WriteOrg(w io.Writer, col []*Item) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range Nest(col) {
+		indent := strings.Repeat(" ", v.Depth+2)
+
+		state := "TODO"
+		if v.Done {
+			state = "DONE"
+		}
+		bw.WriteString(strings.Repeat("*", v.Depth+1) + " " + state + " ")
+		if v.Priority != "" {
+			bw.WriteString("[#" + v.Priority + "] ")
+		}
+		bw.WriteString(v.Body)
+		if len(v.Tags) > 0 {
+			tags := make([]string, len(v.Tags))
+			for i, t := range v.Tags {
+				tags[i] = orgTag(t)
+			}
+			bw.WriteString(" :" + strings.Join(tags, ":") + ":")
+		}
+		bw.WriteString("\n")
+
+		var planning []string
+		if v.Done && !v.CompletedAt.IsZero() {
+			planning = append(planning, "CLOSED: "+orgTime(v.CompletedAt, "[]"))
+		}
+		if !v.StartAt.IsZero() {
+			planning = append(planning, "SCHEDULED: "+orgTime(v.StartAt, "<>"))
+		}
+		if !v.Due.IsZero() {
+			planning = append(planning, "DEADLINE: "+orgTime(v.Due, "<>"))
+		}
+		if len(planning) > 0 {
+			bw.WriteString(indent + strings.Join(planning, " ") + "\n")
+		}
+
+		bw.WriteString(indent + ":PROPERTIES:\n")
+		bw.WriteString(indent + ":TODOW_ID: " + strconv.FormatInt(v.ID, 10) + "\n")
+		if !v.Created.IsZero() {
+			bw.WriteString(indent + ":CREATED: " + orgTime(v.Created, "[]") + "\n")
+		}
+		bw.WriteString(indent + ":END:\n")
+
+		// Indenting keeps lines of notes starting with * from
+		// becoming headings.
+		if v.Notes != "" {
+			for _, line := range strings.Split(strings.TrimRight(v.Notes, "\n"), "\n") {
+				bw.WriteString(strings.TrimRight(indent+line, " ") + "\n")
+			}
+		}
+		for _, c := range v.Checklist {
+			box := "[ ]"
+			if c.Done {
+				box = "[X]"
+			}
+			bw.WriteString(indent + "- " + box + " " + c.Text + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// orgTag replaces the characters of tag that org-mode doesn't allow
+// in tags by underscores.
+func orgTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_@#%", r) {
+			return r
+		}
+		return '_'
+	}, tag)
+}
+
+// orgTime formats t as an org-mode timestamp enclosed in brackets, "<>"
+// for active and "[]" for inactive ones. The time of day is left out at
+// midnight.
+func orgTime(t time.Time, brackets string) string {
+	t = t.In(time.Local)
+	layout := "2006-01-02 Mon 15:04"
+	if t.Hour() == 0 && t.Minute() == 0 {
+		layout = "2006-01-02 Mon"
+	}
+	return brackets[:1] + t.Format(layout) + brackets[1:]
+}
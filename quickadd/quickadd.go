@@ -0,0 +1,329 @@
+// Package quickadd parses items from a line of natural language, like
+//
+//	call dentist tomorrow 3pm +health !high
+//
+// Words prefixed with + are tags and words prefixed with ! set the
+// priority: !high, !medium and !low or !1 to !3 map to the todo.txt
+// priorities A to C, a single letter like !d is used as is.
+//
+// The due date is read from the first date and time found:
+//
+//	today, tonight, tomorrow
+//	monday to sunday, mon to sun, optionally preceded by "next"
+//	in N minutes, hours, days or weeks (also min, h, d, w and singular forms)
+//	in N months or years, a date that may be followed by a time
+//	2016-05-25, 25.05. or 25.05.2016
+//	3pm, 3:30pm, 15:00, noon
+//
+// Dates may be preceded by "on", "by" or "due", times by "at".
+// Dates without time are due at 9:00, times without date today, or
+// tomorrow if the time has passed already.
+package quickadd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// PriorityPrefix marks priority words.
+const PriorityPrefix = "!"
+
+// defaultHour is the hour items are due at if only a date is given.
+const defaultHour = 9
+
+var priorities = map[string]string{
+	"high":   "A",
+	"1":      "A",
+	"medium": "B",
+	"med":    "B",
+	"2":      "B",
+	"low":    "C",
+	"3":      "C",
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+var units = map[string]time.Duration{
+	"minute": time.Minute, "minutes": time.Minute, "min": time.Minute, "mins": time.Minute,
+	"hour": time.Hour, "hours": time.Hour, "h": time.Hour,
+	"day": 24 * time.Hour, "days": 24 * time.Hour, "d": 24 * time.Hour,
+	"week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour, "w": 7 * 24 * time.Hour,
+}
+
+// months are the units of "in N months", which name dates
+// rather than durations, by months they are ahead.
+var months = map[string]int{
+	"month": 1, "months": 1,
+	"year": 12, "years": 12,
+}
+
+var (
+	clockRegexp  = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)$|^(\d{1,2}):(\d{2})$`)
+	germanRegexp = regexp.MustCompile(`^(\d{1,2})\.(\d{1,2})\.(\d{4})?$`)
+)
+
+// A parser holds the state of parsing one line.
+type parser struct {
+	now   time.Time
+	words []string
+
+	day     time.Time // midnight of the due date
+	clock   time.Duration
+	hasDate bool
+	hasTime bool
+	due     time.Time // exact due time, from "in N units"
+}
+
+// Parse returns the item described by text. Relative dates are
+// resolved against now, in its location.
+func Parse(text string, now time.Time) todow.Item {
+	p := parser{now: now, words: strings.Fields(text)}
+	var item todow.Item
+	var body []string
+
+	for i := 0; i < len(p.words); i++ {
+		w := p.words[i]
+		switch {
+		case strings.HasPrefix(w, todow.TagPrefix) && len(w) > len(todow.TagPrefix):
+			item.Tags = append(item.Tags, strings.TrimPrefix(w, todow.TagPrefix))
+			continue
+		case strings.HasPrefix(w, PriorityPrefix) && item.Priority == "":
+			if pri, ok := ParsePriority(strings.TrimPrefix(w, PriorityPrefix)); ok {
+				item.Priority = pri
+				continue
+			}
+		}
+
+		if n := p.when(i); n > 0 {
+			i += n - 1
+			continue
+		}
+		body = append(body, w)
+	}
+
+	item.Body = strings.Join(body, " ")
+	item.Due = p.dueTime()
+	return item
+}
+
+// ParseWhen returns the due time described by text, which must
+// consist of a date, a time or both.
+func ParseWhen(text string, now time.Time) (time.Time, error) {
+	p := parser{now: now, words: strings.Fields(text)}
+	for i := 0; i < len(p.words); i++ {
+		n := p.when(i)
+		if n == 0 {
+			return time.Time{}, fmt.Errorf("unable to parse %q as date or time", p.words[i])
+		}
+		i += n - 1
+	}
+	if len(p.words) == 0 {
+		return time.Time{}, fmt.Errorf("missing date or time")
+	}
+	return p.dueTime(), nil
+}
+
+// ParsePriority returns the priority named by s, like high or b,
+// without PriorityPrefix.
+func ParsePriority(s string) (string, bool) {
+	if pri, ok := priorities[strings.ToLower(s)]; ok {
+		return pri, true
+	}
+	if len(s) == 1 && strings.ToUpper(s) >= "A" && strings.ToUpper(s) <= "Z" {
+		return strings.ToUpper(s), true
+	}
+	return "", false
+}
+
+// when parses a date or time starting at word i and returns the
+// number of words it consists of, or 0 if there is none.
+func (p *parser) when(i int) int {
+	w := strings.ToLower(p.words[i])
+	switch w {
+	case "on", "by", "due":
+		if !p.hasDate {
+			if n := p.date(i + 1); n > 0 {
+				return 1 + n
+			}
+		}
+		return 0
+	case "at":
+		if !p.hasTime {
+			if n := p.clockAt(i + 1); n > 0 {
+				return 1 + n
+			}
+		}
+		return 0
+	}
+	if !p.hasDate {
+		if n := p.date(i); n > 0 {
+			return n
+		}
+	}
+	if !p.hasTime {
+		return p.clockAt(i)
+	}
+	return 0
+}
+
+// date parses a date starting at word i.
+func (p *parser) date(i int) int {
+	if i >= len(p.words) {
+		return 0
+	}
+	w := strings.ToLower(p.words[i])
+	today := time.Date(p.now.Year(), p.now.Month(), p.now.Day(), 0, 0, 0, 0, p.now.Location())
+
+	set := func(d time.Time, n int) int {
+		p.day, p.hasDate = d, true
+		return n
+	}
+
+	switch w {
+	case "today":
+		return set(today, 1)
+	case "tonight":
+		if !p.hasTime {
+			p.clock, p.hasTime = 20*time.Hour, true
+		}
+		return set(today, 1)
+	case "tomorrow":
+		return set(today.AddDate(0, 0, 1), 1)
+	case "next":
+		if i+1 < len(p.words) {
+			if wd, ok := weekdays[strings.ToLower(p.words[i+1])]; ok {
+				return set(nextWeekday(today, wd), 2)
+			}
+		}
+		return 0
+	case "in":
+		if i+2 >= len(p.words) {
+			return 0
+		}
+		n, err := strconv.Atoi(p.words[i+1])
+		if err != nil || n <= 0 {
+			return 0
+		}
+		unit := strings.ToLower(p.words[i+2])
+		if m, ok := months[unit]; ok {
+			return set(today.AddDate(0, n*m, 0), 3)
+		}
+		d, ok := units[unit]
+		if !ok || p.hasTime {
+			return 0
+		}
+		p.due = p.now.Add(time.Duration(n) * d)
+		p.hasDate, p.hasTime = true, true
+		return 3
+	}
+
+	if wd, ok := weekdays[w]; ok {
+		return set(nextWeekday(today, wd), 1)
+	}
+	if d, err := time.ParseInLocation("2006-01-02", w, p.now.Location()); err == nil {
+		return set(d, 1)
+	}
+	if m := germanRegexp.FindStringSubmatch(w); m != nil {
+		day, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		year := today.Year()
+		if m[3] != "" {
+			year, _ = strconv.Atoi(m[3])
+		}
+		d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, p.now.Location())
+		if d.Day() != day || d.Month() != time.Month(month) {
+			return 0
+		}
+		// Dates without year lie ahead.
+		if m[3] == "" && d.Before(today) {
+			d = d.AddDate(1, 0, 0)
+		}
+		return set(d, 1)
+	}
+	return 0
+}
+
+// clockAt parses a time of day at word i.
+func (p *parser) clockAt(i int) int {
+	if i >= len(p.words) {
+		return 0
+	}
+	w := strings.ToLower(p.words[i])
+	if w == "noon" {
+		p.clock, p.hasTime = 12*time.Hour, true
+		return 1
+	}
+
+	m := clockRegexp.FindStringSubmatch(w)
+	if m == nil {
+		return 0
+	}
+	var hour, min int
+	if m[3] != "" {
+		hour, _ = strconv.Atoi(m[1])
+		min, _ = strconv.Atoi(m[2])
+		if hour < 1 || hour > 12 {
+			return 0
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	} else {
+		hour, _ = strconv.Atoi(m[4])
+		min, _ = strconv.Atoi(m[5])
+	}
+	if hour > 23 || min > 59 {
+		return 0
+	}
+
+	p.clock, p.hasTime = time.Duration(hour)*time.Hour+time.Duration(min)*time.Minute, true
+	return 1
+}
+
+// dueTime combines the parsed date and time.
+func (p *parser) dueTime() time.Time {
+	switch {
+	case !p.due.IsZero():
+		return p.due
+	case p.hasDate && p.hasTime:
+		return at(p.day, p.clock)
+	case p.hasDate:
+		return at(p.day, defaultHour*time.Hour)
+	case p.hasTime:
+		today := time.Date(p.now.Year(), p.now.Month(), p.now.Day(), 0, 0, 0, 0, p.now.Location())
+		due := at(today, p.clock)
+		if !due.After(p.now) {
+			due = at(today.AddDate(0, 0, 1), p.clock)
+		}
+		return due
+	}
+	return time.Time{}
+}
+
+// at returns the time d after midnight of day, in wall clock time.
+func at(day time.Time, d time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, day.Location())
+}
+
+// nextWeekday returns the first day after today that is a wd.
+func nextWeekday(today time.Time, wd time.Weekday) time.Time {
+	n := (int(wd) - int(today.Weekday()) + 7) % 7
+	if n == 0 {
+		n = 7
+	}
+	return today.AddDate(0, 0, n)
+}
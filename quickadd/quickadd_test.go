@@ -0,0 +1,72 @@
+package quickadd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// now is a Wednesday.
+var now = time.Date(2016, 5, 25, 10, 0, 0, 0, time.UTC)
+
+func day(month time.Month, d, hour, min int) time.Time {
+	return time.Date(2016, month, d, hour, min, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text     string
+		body     string
+		tags     []string
+		priority string
+		due      time.Time
+	}{
+		{"buy milk", "buy milk", nil, "", time.Time{}},
+		{"call dentist tomorrow 3pm +health !high", "call dentist", []string{"health"}, "A", day(5, 26, 15, 0)},
+		{"report by friday", "report", nil, "", day(5, 27, 9, 0)},
+		{"plan next monday", "plan", nil, "", day(5, 30, 9, 0)},
+		{"standup wednesday", "standup", nil, "", day(6, 1, 9, 0)},
+		{"ping in 2 hours", "ping", nil, "", day(5, 25, 12, 0)},
+		{"renew in 1 year", "renew", nil, "", time.Date(2017, 5, 25, 9, 0, 0, 0, time.UTC)},
+		{"lunch at noon", "lunch", nil, "", day(5, 25, 12, 0)},
+		{"wake 8:00", "wake", nil, "", day(5, 26, 8, 0)},
+		{"movie tonight", "movie", nil, "", day(5, 25, 20, 0)},
+		{"pay rent 01.06.", "pay rent", nil, "", day(6, 1, 9, 0)},
+		{"birthday 20.05.", "birthday", nil, "", time.Date(2017, 5, 20, 9, 0, 0, 0, time.UTC)},
+		{"launch on 2016-07-01 at 9:30am", "launch", nil, "", day(7, 1, 9, 30)},
+		{"impossible 31.02.", "impossible 31.02.", nil, "", time.Time{}},
+		{"in 2 bananas", "in 2 bananas", nil, "", time.Time{}},
+		{"fix !d bug !c", "fix bug !c", nil, "D", time.Time{}},
+		{"odd !word", "odd !word", nil, "", time.Time{}},
+		{"a + b +x +y", "a + b", []string{"x", "y"}, "", time.Time{}},
+	}
+	for _, tt := range tests {
+		item := Parse(tt.text, now)
+		if item.Body != tt.body || !reflect.DeepEqual(item.Tags, tt.tags) || item.Priority != tt.priority || !item.Due.Equal(tt.due) {
+			t.Errorf("Parse(%q) = %q %q %q %s, want %q %q %q %s", tt.text,
+				item.Body, item.Tags, item.Priority, item.Due, tt.body, tt.tags, tt.priority, tt.due)
+		}
+	}
+}
+
+func TestParseWhen(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Time
+		ok   bool
+	}{
+		{"tomorrow", day(5, 26, 9, 0), true},
+		{"fri 5pm", day(5, 27, 17, 0), true},
+		{"in 30 min", day(5, 25, 10, 30), true},
+		{"", time.Time{}, false},
+		{"soon", time.Time{}, false},
+		{"tomorrow soon", time.Time{}, false},
+		{"13pm", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseWhen(tt.text, now)
+		if (err == nil) != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("ParseWhen(%q) = %s, %v, want %s, ok %t", tt.text, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	accessLogPath    = flags.String("access-log", "", "File requests are logged to in Combined Log Format, separate from the other messages. Disabled if empty")
+	accessLogMaxSize = flags.Int64("access-log-max-size", 100<<20, "Size in bytes the access log is rotated at, 0 for no limit")
+	accessLogMaxAge  = flags.Duration("access-log-max-age", 24*time.Hour, "Age the access log is rotated at, 0 for no limit")
+	accessLogKeep    = flags.Int("access-log-keep", 7, "Number of rotated access logs to keep, 0 keeps all")
+)
+
+// accessLog is the file of -access-log, nil if it is disabled.
+var accessLog *rotatingFile
+
+// logAccess appends a line in Combined Log Format for r, received at
+// start by the user with the login name login, "" if not logged in, and
+// answered with w, to the access log.
+func logAccess(r *http.Request, login string, w *statusWriter, start time.Time) {
+	size := "-"
+	if w.size > 0 {
+		size = fmt.Sprint(w.size)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s %q %q\n",
+		orDash(remoteHost(r)),
+		orDash(login),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto,
+		w.status,
+		size,
+		orDash(r.Referer()),
+		orDash(r.UserAgent()),
+	)
+	if _, err := accessLog.Write([]byte(line)); err != nil {
+		slog.Error("unable to write access log", "err", err)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// A rotatingFile is a log file that is renamed after the time it is
+// rotated at, e.g. access.log.20161015-103000.000, and replaced by a
+// new one once it reaches its maximum size or age. Only the newest
+// keep rotated files are kept, unless keep is 0.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// openRotatingFile opens the log file at path, appending to it if it
+// exists.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	l := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would exceed its
+// maximum size or it reached its maximum age.
+func (l *rotatingFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize
+	old := l.maxAge > 0 && time.Since(l.opened) >= l.maxAge
+	if full || old {
+		if err := l.rotate(); err != nil {
+			return 0, fmt.Errorf("unable to rotate %s: %s", l.path, err)
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and opens a new one. If renaming fails, the
+// file is appended to further.
+func (l *rotatingFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	name := l.path + "." + time.Now().Format("20060102-150405.000")
+	renamed := os.Rename(l.path, name)
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renamed != nil {
+		return renamed
+	}
+	return l.prune()
+}
+
+// prune removes all but the newest keep rotated files.
+func (l *rotatingFile) prune() error {
+	if l.keep <= 0 {
+		return nil
+	}
+
+	// Timestamps in the names make lexical order chronological.
+	names, err := filepath.Glob(l.path + ".[0-9]*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for len(names) > l.keep {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
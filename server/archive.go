@@ -0,0 +1,68 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var archiveAfter = flags.Duration("archive-after", 0, "Archive items completed longer than this ago, e.g. 720h for 30 days, 0 disables it")
+
+// archiveCompleted hourly archives the items of all users that were
+// completed longer than -archive-after ago. It never returns.
+func archiveCompleted() {
+	for range time.Tick(time.Hour) {
+		before := time.Now().Add(-*archiveAfter)
+		for name, s := range stores {
+			n, err := archiveDone(s, name, before)
+			if err != nil {
+				slog.Error("unable to archive items", "user", name, "err", err)
+			}
+			if n > 0 {
+				slog.Info("archived items", "user", name, "count", n)
+			}
+		}
+	}
+}
+
+// archiveDone archives the items of s, which belongs to the named user,
+// completed before t and returns how many there were.
+func archiveDone(s store.Store, name string, before time.Time) (int, error) {
+	done := true
+	col, _, err := s.Find(store.Query{Done: &done, Deferred: true})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, v := range col {
+		if !v.CompletedAt.Before(before) {
+			continue
+		}
+
+		// The item may have been reopened since.
+		var item *todow.Item
+		err := s.Update(v.ID, func(i *todow.Item) {
+			if i.Done {
+				i.SetArchived(true)
+			}
+			c := *i
+			item = &c
+		}, nil)
+		if _, ok := err.(store.ErrNotFound); ok {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if item.Archived.IsZero() {
+			continue
+		}
+
+		n++
+		events.publish(event{Type: eventUpdated, ID: item.ID, Item: item, user: name})
+	}
+	return n, nil
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/j1436go/todow/store"
+)
+
+var auditLoginInterval = flags.Duration("audit-login-interval", time.Hour, "Minimum interval between audited successful logins of a user from the same IP")
+
+// Audited actions.
+const (
+	auditLogin        = "login"
+	auditLoginFailed  = "login-failed"
+	auditLockedOut    = "locked-out"
+	auditHook         = "hook"
+	auditShareView    = "share-view"
+	auditShare        = "share"
+	auditUnshare      = "unshare"
+	auditInvite       = "invite"
+	auditUninvite     = "uninvite"
+	auditAcceptInvite = "accept-invite"
+	auditRemove       = "remove"
+	auditRestore      = "restore"
+	auditCompact      = "compact"
+	auditBackup       = "backup"
+	auditPassword     = "password"
+	auditSettings     = "settings"
+	auditToken        = "token"
+	auditRevokeToken  = "revoke-token"
+	auditPush         = "push"
+	auditRemovePush   = "remove-push"
+	auditGoogle       = "google"
+	auditUngoogle     = "disconnect-google"
+)
+
+// logins remembers when a user last logged in from an IP. Clients
+// send credentials with every request, so successful logins are only
+// audited once per -audit-login-interval.
+var logins = struct {
+	sync.Mutex
+	last map[string]time.Time
+}{last: map[string]time.Time{}}
+
+// audit appends an entry for action by the user of r to the audit log.
+// name overrides the user of r if it is not empty. Failures are logged,
+// the request proceeds regardless.
+func audit(r *http.Request, name, action, detail string) {
+	if name == "" {
+		if n, ok := r.Context().Value(userKey).(string); ok {
+			name = n
+			if name == "" {
+				name = *user
+			}
+		}
+	}
+	e := store.AuditEntry{
+		Time:      time.Now(),
+		User:      name,
+		IP:        remoteHost(r),
+		Action:    action,
+		Detail:    detail,
+		RequestID: requestID(r),
+	}
+
+	a, ok := db.(store.Auditor)
+	if !ok {
+		requestLog(r).Info("audit", "action", e.Action, "user", e.User, "ip", e.IP, "detail", e.Detail)
+		return
+	}
+	if err := a.Audit(e); err != nil {
+		requestLog(r).Error("unable to record action in audit log", "action", action, "err", err)
+	}
+}
+
+// auditLoginOf audits a successful login of the user of r, unless
+// they logged in from the same IP recently.
+func auditLoginOf(r *http.Request) {
+	key := userName(r) + " " + remoteHost(r)
+	now := time.Now()
+
+	logins.Lock()
+	last, ok := logins.last[key]
+	if ok && now.Sub(last) < *auditLoginInterval {
+		logins.Unlock()
+		return
+	}
+	logins.last[key] = now
+	logins.Unlock()
+
+	audit(r, "", auditLogin, "")
+}
+
+// auditLog lists audit entries as JSON. The "since" query parameter
+// limits them to entries recorded at or after the given time,
+// "limit" to the newest n entries.
+func auditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	a, ok := db.(store.Auditor)
+	if !ok {
+		httpError(w, r, fmt.Sprintf("audit logs of %s stores are not supported", *storeKind), http.StatusNotImplemented)
+		return
+	}
+
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			httpError(w, r, fmt.Sprintf("invalid time %q, use RFC 3339", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			httpError(w, r, fmt.Sprintf("invalid limit %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	log, err := a.AuditLog(since, limit)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, log)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/j1436go/todow/store"
+)
+
+// backup streams a snapshot of the store.
+func backup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	b, ok := db.(store.Backuper)
+	if !ok {
+		httpError(w, r, fmt.Sprintf("backups of %s stores are not supported", *storeKind), http.StatusNotImplemented)
+		return
+	}
+
+	noWriteTimeout(w, r)
+	name := fmt.Sprintf("todow-%s.%s", time.Now().Format("20060102-150405"), *storeKind)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	n, err := b.Backup(w)
+	if err != nil {
+		// Headers are gone already, all we can do is log.
+		requestLog(r).Error("backup failed", "bytes", n, "err", err)
+		return
+	}
+	requestLog(r).Info("streamed backup", "bytes", n)
+	audit(r, "", auditBackup, fmt.Sprintf("%d bytes", n))
+}
+
+// backupTo writes a snapshot of the store to the file at path.
+func backupTo(path string) error {
+	b, ok := db.(store.Backuper)
+	if !ok {
+		return fmt.Errorf("backups of %s stores are not supported", *storeKind)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.Backup(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// restore replaces the store with the backup at path.
+func restore(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return store.Restore(*storeKind, *storeSource, f)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+// checkBlocked responds with 409 Conflict and returns false if one of
+// the items identified by ids, which are about to be completed, is
+// blocked by open items, unless the "force" query parameter is true.
+// Items that don't exist are left to the caller.
+func checkBlocked(w http.ResponseWriter, r *http.Request, ids []int64) bool {
+	if r.URL.Query().Get("force") == "true" {
+		return true
+	}
+
+	db := userStore(r)
+	var blocked []string
+	for _, id := range ids {
+		item, err := db.Get(id)
+		switch err.(type) {
+		case store.ErrNotFound:
+			continue
+		case error:
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if item.Done {
+			continue
+		}
+		open, err := store.OpenBlockers(db, item)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if len(open) > 0 {
+			blocked = append(blocked, fmt.Sprintf("#%d by %s", id, formatRefs(open)))
+		}
+	}
+	if len(blocked) > 0 {
+		httpError(w, r, fmt.Sprintf("blocked by open items: %s, complete them first or force it", strings.Join(blocked, ", ")), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// checkBlockers responds with 400 Bad Request and returns false unless
+// the item identified by id may be blocked by the items of blockers,
+// which must exist and must not be blocked by the item themselves.
+func checkBlockers(w http.ResponseWriter, r *http.Request, id int64, blockers []int64) bool {
+	db := userStore(r)
+	for _, b := range blockers {
+		switch _, err := db.Get(b); err.(type) {
+		case store.ErrNotFound:
+			httpError(w, r, fmt.Sprintf("blocking item #%d not found", b), http.StatusBadRequest)
+			return false
+		case error:
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	switch b, err := store.BlockCycle(db, id, blockers); {
+	case err != nil:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return false
+	case b != 0:
+		httpError(w, r, fmt.Sprintf("item #%d can't be blocked by #%d, which waits for it", id, b), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// blockedItems returns the open blockers of the items of col that have
+// any, formatted by formatRefs, by item ID.
+func blockedItems(s store.Store, col []*todow.Item) (map[int64]string, error) {
+	blocked := map[int64]string{}
+	for _, item := range col {
+		if item.Done || len(item.BlockedBy) == 0 {
+			continue
+		}
+		open, err := store.OpenBlockers(s, item)
+		if err != nil {
+			return nil, err
+		}
+		if len(open) > 0 {
+			blocked[item.ID] = formatRefs(open)
+		}
+	}
+	return blocked, nil
+}
+
+// formatRefs formats ids as "#1, #2".
+func formatRefs(ids []int64) string {
+	return "#" + todow.FormatIDs(ids, ", #")
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+// maxBulk is the maximum number of items of a bulk request.
+const maxBulk = 1000
+
+// bulk removes or patches several items at once, see todow.Bulk.
+// Nothing is changed if one of the items does not exist.
+// The "cascade" and "force" query parameters work as for PATCH.
+func bulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var b todow.Bulk
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		httpError(w, r, fmt.Sprintf("unable to decode bulk request: %s", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	switch {
+	case len(b.IDs) == 0:
+		httpError(w, r, "missing item ids", http.StatusBadRequest)
+		return
+	case len(b.IDs) > maxBulk:
+		httpError(w, r, fmt.Sprintf("too many items, at most %d are allowed", maxBulk), http.StatusBadRequest)
+		return
+	case b.Remove == (b.Patch != nil):
+		httpError(w, r, "either remove or patch the items", http.StatusBadRequest)
+		return
+	case b.Patch != nil && b.Patch.Body != nil && strings.TrimSpace(*b.Patch.Body) == "":
+		httpError(w, r, "item body must not be empty", http.StatusBadRequest)
+		return
+	case b.Patch != nil && b.Patch.Priority != nil && !todow.ValidPriority(*b.Patch.Priority):
+		httpError(w, r, fmt.Sprintf("invalid priority %q, use A to Z", *b.Patch.Priority), http.StatusBadRequest)
+		return
+	}
+
+	if b.Patch != nil {
+		if err := todow.CheckMeta(b.Patch.Meta); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	db := userStore(r)
+	items := map[int64]*todow.Item{}
+	var ids []int64
+	var missing []string
+	for _, id := range b.IDs {
+		if _, ok := items[id]; ok {
+			continue
+		}
+		item, err := db.Get(id)
+		switch err.(type) {
+		case store.ErrNotFound:
+			missing = append(missing, fmt.Sprintf("#%d", id))
+		case error:
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items[id] = item
+		ids = append(ids, id)
+	}
+	if len(missing) > 0 {
+		httpError(w, r, fmt.Sprintf("no items %s, none changed", strings.Join(missing, ", ")), http.StatusNotFound)
+		return
+	}
+
+	if b.Patch != nil && b.Patch.BlockedBy != nil {
+		for _, id := range ids {
+			if !checkBlockers(w, r, id, *b.Patch.BlockedBy) {
+				return
+			}
+		}
+	}
+	if b.Patch != nil && b.Patch.Done != nil && *b.Patch.Done && !checkBlocked(w, r, ids) {
+		return
+	}
+
+	var cascade func(*todow.Item)
+	if b.Patch != nil && b.Patch.Done != nil && r.URL.Query().Get("cascade") == "true" {
+		done := *b.Patch.Done
+		cascade = func(item *todow.Item) { item.SetDone(done) }
+	}
+
+	var out strings.Builder
+	for _, id := range ids {
+		if b.Remove {
+			if err := db.Remove(id); err != nil {
+				bulkFailed(w, r, id, err)
+				return
+			}
+			audit(r, "", auditRemove, fmt.Sprintf("item #%d: %s", id, items[id].Body))
+			events.publish(event{Type: eventRemoved, ID: id, user: storeOwner(r)})
+			fmt.Fprintf(&out, "Removed item #%d\n", id)
+			continue
+		}
+
+		changes := map[int64][]store.Change{}
+		if err := db.Update(id, recording(b.Patch.Apply, changes), recording(cascade, changes)); err != nil {
+			bulkFailed(w, r, id, err)
+			return
+		}
+		recordChanges(r, changes)
+		switch {
+		case b.Patch.Done != nil && *b.Patch.Done:
+			publishItem(r, eventCompleted, id)
+			fmt.Fprintf(&out, "Completed item #%d\n", id)
+		case b.Patch.Done != nil:
+			publishItem(r, eventUpdated, id)
+			fmt.Fprintf(&out, "Reopened item #%d\n", id)
+		default:
+			publishItem(r, eventUpdated, id)
+			fmt.Fprintf(&out, "Updated item #%d\n", id)
+		}
+	}
+
+	fmt.Fprint(w, out.String())
+}
+
+// bulkFailed reports that changing the item identified by id failed,
+// after the items before it were changed already.
+func bulkFailed(w http.ResponseWriter, r *http.Request, id int64, err error) {
+	httpError(w, r, fmt.Sprintf("item #%d: %s, the items before it were changed", id, err), http.StatusInternalServerError)
+}
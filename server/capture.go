@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/quickadd"
+	"github.com/j1436go/todow/store"
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	captureAddr = flags.String("capture-smtp", "", "Address to receive mails on by SMTP, e.g. :2525, which are added as items if sent to a -capture-to address")
+	captureTo   stringList
+)
+
+func init() {
+	flags.Var(&captureTo, "capture-to", "Address whose mails -capture-smtp adds as items, may be given multiple times. "+
+		"Prefix it with USER: to add items for another user than the default one. Anyone knowing it may add items")
+}
+
+// captureMaxSize is the size of the largest mail accepted.
+const captureMaxSize = 1 << 20
+
+// captureMail receives mails by SMTP on -capture-smtp and adds them as
+// items, see captureItem. It is meant to receive mails relayed by the
+// mail server of the -capture-to addresses. It never returns.
+func captureMail() {
+	l, err := net.Listen("tcp", *captureAddr)
+	if err != nil {
+		fatal("unable to receive mails", "err", err)
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			slog.Error("unable to accept mail connection", "err", err)
+			continue
+		}
+		go receiveMail(c)
+	}
+}
+
+// receiveMail speaks just enough SMTP with c to receive mails.
+func receiveMail(c net.Conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Minute))
+	tc := textproto.NewConn(c)
+	reply := func(code int, msg string) { tc.PrintfLine("%d %s", code, msg) }
+
+	reply(220, "todow ESMTP")
+	// The users the mail is sent to, by recipient.
+	var users []string
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO", "EHLO":
+			reply(250, "todow")
+		case "MAIL", "RSET":
+			users = nil
+			reply(250, "OK")
+		case "RCPT":
+			name, ok := captureUser(arg)
+			if !ok {
+				reply(550, "no such mailbox")
+				continue
+			}
+			users = append(users, name)
+			reply(250, "OK")
+		case "DATA":
+			if len(users) == 0 {
+				reply(503, "no valid recipients")
+				continue
+			}
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+			dr := tc.DotReader()
+			p, err := ioutil.ReadAll(io.LimitReader(dr, captureMaxSize+1))
+			io.Copy(ioutil.Discard, dr)
+			if err != nil {
+				return
+			}
+
+			if len(p) > captureMaxSize {
+				reply(552, "message too big")
+			} else if ids, err := captureItems(users, p); err != nil {
+				reply(554, err.Error())
+			} else {
+				reply(250, "added item "+ids)
+			}
+			users = nil
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
+
+// captureUser returns the name of the user mails to the recipient
+// of the RCPT command argument arg are added for.
+func captureUser(arg string) (string, bool) {
+	if !strings.HasPrefix(strings.ToUpper(arg), "TO:") {
+		return "", false
+	}
+	addr := strings.TrimSpace(arg[3:])
+	if i := strings.Index(addr, ">"); i >= 0 {
+		addr = addr[:i]
+	}
+	addr = strings.TrimPrefix(addr, "<")
+	if addr == "" {
+		return "", false
+	}
+
+	for _, t := range captureTo {
+		var name string
+		if i := strings.Index(t, ":"); i >= 0 {
+			name, t = t[:i], t[i+1:]
+		}
+		if strings.EqualFold(addr, t) {
+			_, ok := stores[name]
+			return name, ok
+		}
+	}
+	return "", false
+}
+
+// captureItems adds the mail p as an item of each of users and returns
+// their IDs, like "#1, #2". Once the mail was added for any of them, the
+// sender is told it was delivered, retrying would add it twice, and
+// failures for the others are only logged.
+func captureItems(users []string, p []byte) (string, error) {
+	item, err := parseMail(p)
+	if err != nil {
+		return "", err
+	}
+
+	var ids []int64
+	for _, name := range users {
+		item := item
+		if err = captureItem(name, &item); err != nil {
+			slog.Error("unable to add mail as item", "user", name, "err", err)
+			continue
+		}
+		ids = append(ids, item.ID)
+	}
+	if len(ids) == 0 {
+		return "", err
+	}
+	return formatRefs(ids), nil
+}
+
+// parseMail returns the item the mail p is added as. The subject, parsed
+// by quickadd for tags, priority and due date, becomes its body, the
+// plain text of the mail its notes.
+func parseMail(p []byte) (todow.Item, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(p))
+	if err != nil {
+		return todow.Item{}, fmt.Errorf("unable to read mail: %s", err)
+	}
+
+	dec := mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	item := quickadd.Parse(trimForward(subject), time.Now())
+	if item.Body == "" {
+		return todow.Item{}, fmt.Errorf("mail has no subject")
+	}
+	text, err := mailText(msg.Header, msg.Body)
+	if err != nil {
+		return todow.Item{}, fmt.Errorf("unable to read mail body: %s", err)
+	}
+	item.Notes = strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1))
+	item.Created = time.Now()
+	return item, nil
+}
+
+// captureItem adds item, parsed from a mail, for the named user.
+func captureItem(name string, item *todow.Item) error {
+	setDefaultReminder(name, item)
+
+	s := stores[name]
+	if err := s.Add(item); err != nil {
+		return err
+	}
+	if h, ok := s.(store.Historian); ok {
+		c := store.Change{Kind: store.ChangeCreated, Time: item.Created, User: loginName(name)}
+		if err := h.Record(item.ID, c); err != nil {
+			slog.Error("unable to record change in item history", "change", c.Kind, "item", item.ID, "err", err)
+		}
+	}
+	events.publish(event{Type: eventAdded, ID: item.ID, Item: item, user: name})
+	return nil
+}
+
+// trimForward removes the prefixes mail clients give the subjects
+// of forwarded and replied to mails, like "Fwd: " and "Re: ".
+func trimForward(subject string) string {
+	for {
+		subject = strings.TrimSpace(subject)
+		trimmed := false
+		for _, prefix := range []string{"fwd:", "fw:", "wg:", "re:", "aw:"} {
+			if strings.HasPrefix(strings.ToLower(subject), prefix) {
+				subject, trimmed = subject[len(prefix):], true
+			}
+		}
+		if !trimmed {
+			return subject
+		}
+	}
+}
+
+// mailText returns the first plain text of the mail part with header h
+// and body, which may be multipart, or an empty string if it has none.
+func mailText(h interface{ Get(string) string }, body io.Reader) (string, error) {
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	typ, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		// Mails without content type are plain text.
+		typ = "text/plain"
+	}
+	switch {
+	case typ == "text/plain":
+		// Text in unknown charsets is kept as it is.
+		if r, err := charset.NewReaderLabel(params["charset"], body); err == nil {
+			body = r
+		}
+		p, err := ioutil.ReadAll(body)
+		return string(p), err
+	case strings.HasPrefix(typ, "multipart/"):
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := mailText(part.Header, part)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+	return "", nil
+}
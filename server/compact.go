@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/j1436go/todow/store"
+)
+
+// compact compacts the store and reports the change in size.
+func compact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	c, ok := db.(store.Compacter)
+	if !ok {
+		httpError(w, r, fmt.Sprintf("compacting %s stores is not supported", *storeKind), http.StatusNotImplemented)
+		return
+	}
+
+	before, after, err := c.Compact()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit(r, "", auditCompact, fmt.Sprintf("%d to %d bytes", before, after))
+	fmt.Fprintf(w, "Compacted store from %d to %d bytes\n", before, after)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var configFile = flags.String("config", "", "TOML file setting flags by name, e.g. store = \"postgres\", by default the [server] table of the client's config file")
+
+// clientConfigFile returns the path of the config file the client reads
+// its profiles from, which may configure the server in a [server] table.
+func clientConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "todow", "config.toml")
+}
+
+// envAliases name the environment variables of flags whose variable isn't
+// TODOW_ followed by the flag name in upper case, dashes replaced by
+// underscores, like TODOW_DB_MAX_OPEN for -db-max-open.
+var envAliases = map[string]string{
+	"a": "TODOW_ADDR",
+	"u": "TODOW_USER",
+	"p": "TODOW_PASS",
+}
+
+// configAliases are config file keys naming flags with short names.
+var configAliases = map[string]string{
+	"addr": "a",
+	"user": "u",
+	"pass": "p",
+}
+
+func envName(flagName string) string {
+	if n, ok := envAliases[flagName]; ok {
+		return n
+	}
+	return "TODOW_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// configure sets the flags not given on the command line from the
+// environment, and those still unset from the config file. Config files
+// shared with the client set flags in their [server] table.
+func configure() error {
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if err = flags.Set(f.Name, v); err != nil {
+			err = fmt.Errorf("invalid %s: %s", envName(f.Name), err)
+		}
+		set[f.Name] = true
+	})
+	path := *configFile
+	if path == "" {
+		path = clientConfigFile()
+	}
+	if err != nil || path == "" {
+		return err
+	}
+
+	var conf map[string]interface{}
+	_, err = toml.DecodeFile(path, &conf)
+	if os.IsNotExist(err) && *configFile == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t, ok := conf["server"].(map[string]interface{}); ok {
+		conf = t
+	} else if *configFile == "" {
+		return nil
+	}
+
+	for key, v := range conf {
+		name := key
+		if n, ok := configAliases[key]; ok {
+			name = n
+		}
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q in %s", key, path)
+		}
+		if set[name] {
+			continue
+		}
+
+		// Arrays set flags that may be given multiple times.
+		vs, ok := v.([]interface{})
+		if !ok {
+			vs = []interface{}{v}
+		}
+		for _, v := range vs {
+			if err := flags.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("invalid %s in %s: %s", key, path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+var (
+	corsOrigins = flags.String("cors-origins", "", "Comma separated origins allowed to make cross-origin requests with credentials, "+
+		"* for any origin without them")
+	corsMethods = flags.String("cors-methods", "GET, POST, PATCH, DELETE", "Methods allowed in cross-origin requests")
+	corsHeaders = flags.String("cors-headers", "Authorization, Content-Type, Idempotency-Key, If-None-Match", "Request headers allowed in cross-origin requests")
+)
+
+// cors adds CORS headers to responses to requests from allowed origins
+// and answers their preflight requests. Without allowed origins,
+// h is returned unchanged. Only origins listed explicitly may send
+// credentials, any other site could read the pages of logged in users
+// including their CSRF tokens otherwise.
+func cors(h http.Handler) http.Handler {
+	if *corsOrigins == "" {
+		return h
+	}
+
+	allowed := map[string]bool{}
+	for _, o := range strings.Split(*corsOrigins, ",") {
+		allowed[strings.TrimSpace(o)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if allowed[origin] {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Total-Count")
+
+		// Preflight requests carry no credentials, so they must
+		// be answered before authentication.
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", *corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", *corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidCSRF(t *testing.T) {
+	token := csrfToken(withUser(httptest.NewRequest("GET", "/", nil), "alice"))
+	form := url.Values{"csrf": {token}}.Encode()
+
+	tests := []struct {
+		name   string
+		method string
+		user   string
+		header map[string]string
+		form   string
+		want   bool
+	}{
+		{"safe method", "GET", "alice", map[string]string{"Origin": "https://evil.example"}, "", true},
+		{"options", "OPTIONS", "alice", map[string]string{"Sec-Fetch-Site": "cross-site"}, "", true},
+		{"cli", "POST", "alice", nil, "", true},
+		{"browser without token", "POST", "alice", map[string]string{"Origin": "https://evil.example"}, "", false},
+		{"referer without token", "DELETE", "alice", map[string]string{"Referer": "https://evil.example/"}, "", false},
+		{"fetch metadata without token", "PATCH", "alice", map[string]string{"Sec-Fetch-Site": "same-origin"}, "", false},
+		{"header token", "POST", "alice", map[string]string{"Origin": "https://todo.example", "X-CSRF-Token": token}, "", true},
+		{"form token", "POST", "alice", map[string]string{"Origin": "https://todo.example"}, form, true},
+		{"wrong token", "POST", "alice", map[string]string{"Origin": "https://todo.example", "X-CSRF-Token": "00" + token[2:]}, "", false},
+		{"token of another user", "POST", "bob", map[string]string{"Origin": "https://todo.example", "X-CSRF-Token": token}, "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.form))
+		if tt.form != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		if got := validCSRF(withUser(r, tt.user)); got != tt.want {
+			t.Errorf("%s: validCSRF = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
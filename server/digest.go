@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+// A digest is a summary of a user's overdue items, items due today and
+// items added since the last digest, mailed to To on Schedule, see
+// parseDigestSchedule. Mails are sent as set by the -notify-mail flags.
+type digest struct {
+	To       string
+	Schedule string
+
+	// Sent is when the last digest was mailed, or when the user
+	// opted in. Missed digests aren't sent twice.
+	Sent time.Time `json:",omitempty"`
+}
+
+// validDigest returns an error if d has an invalid address or schedule.
+func validDigest(d digest) error {
+	if _, err := mail.ParseAddress(d.To); err != nil {
+		return fmt.Errorf("invalid digest address %q", d.To)
+	}
+	_, err := parseDigestSchedule(d.Schedule)
+	return err
+}
+
+// A digestSchedule mails a digest every day, or on a weekday if weekly
+// is set, at the time of day at.
+type digestSchedule struct {
+	weekly bool
+	day    time.Weekday
+	at     time.Duration
+}
+
+// parseDigestSchedule parses schedules like "daily 07:00" and
+// "weekly monday 07:00", in the server's time zone.
+func parseDigestSchedule(s string) (digestSchedule, error) {
+	invalid := fmt.Errorf("invalid digest schedule %q, use daily HH:MM or weekly WEEKDAY HH:MM", s)
+
+	var d digestSchedule
+	f := strings.Fields(strings.ToLower(s))
+	switch {
+	case len(f) == 2 && f[0] == "daily":
+	case len(f) == 3 && f[0] == "weekly":
+		day, ok := weekdays[f[1]]
+		if !ok {
+			return d, invalid
+		}
+		d.weekly, d.day = true, day
+	default:
+		return d, invalid
+	}
+
+	hm := strings.Split(f[len(f)-1], ":")
+	if len(hm) != 2 {
+		return d, invalid
+	}
+	h, err := strconv.Atoi(hm[0])
+	if err != nil || h < 0 || h > 23 {
+		return d, invalid
+	}
+	m, err := strconv.Atoi(hm[1])
+	if err != nil || m < 0 || m > 59 {
+		return d, invalid
+	}
+	d.at = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	return d, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// next returns the first time a digest is due after t.
+func (d digestSchedule) next(t time.Time) time.Time {
+	t = t.In(time.Local)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	for {
+		at := day.Add(d.at)
+		if at.After(t) && (!d.weekly || day.Weekday() == d.day) {
+			return at
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+}
+
+// mailDigests mails the digests of the users who opted in once they are
+// due, checking every minute. It never returns.
+func mailDigests() {
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+		for name, s := range stores {
+			d := settingsOf(name).Digest
+			if d == nil {
+				continue
+			}
+			sched, err := parseDigestSchedule(d.Schedule)
+			if err != nil || sched.next(d.Sent).After(now) {
+				continue
+			}
+
+			// A failing mail server isn't asked again until the next one.
+			if err := mailDigest(s, *d, now); err != nil {
+				slog.Error("unable to mail digest", "user", name, "err", err)
+			}
+			if err := digestSent(name, now); err != nil {
+				slog.Error("unable to save settings", "user", name, "err", err)
+			}
+		}
+	}
+}
+
+// digestSent records that the digest of the named user was sent at t.
+func digestSent(name string, t time.Time) error {
+	settings.Lock()
+	defer settings.Unlock()
+	s, ok := settings.users[name]
+	if !ok || s.Digest == nil {
+		return nil
+	}
+	// settingsOf copies share the digest, so it is replaced.
+	d := *s.Digest
+	d.Sent = t
+	s.Digest = &d
+	return saveSettings()
+}
+
+// mailDigest mails the digest d of the items of s as of now, unless
+// there is nothing to report.
+func mailDigest(s store.Store, d digest, now time.Time) error {
+	open := false
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	sections := []struct {
+		title string
+		q     store.Query
+	}{
+		{"Overdue", store.Query{Done: &open, DueBefore: now, Sort: store.SortDue}},
+		{"Due today", store.Query{Done: &open, DueAfter: now, DueBefore: today.AddDate(0, 0, 1), Sort: store.SortDue}},
+		{"Added since " + d.Sent.In(time.Local).Format(todow.DueFormat), store.Query{Done: &open, CreatedAfter: d.Sent}},
+	}
+
+	var body bytes.Buffer
+	counts := make([]int, len(sections))
+	for i, sec := range sections {
+		col, _, err := s.Find(sec.q)
+		if err != nil {
+			return err
+		}
+		counts[i] = len(col)
+		if len(col) == 0 {
+			continue
+		}
+		fmt.Fprintf(&body, "%s:\r\n", sec.title)
+		for _, item := range col {
+			fmt.Fprintf(&body, "  #%d %s", item.ID, item.Body)
+			if !item.Due.IsZero() {
+				fmt.Fprintf(&body, " (due %s)", item.Due.In(time.Local).Format(todow.DueFormat))
+			}
+			fmt.Fprint(&body, "\r\n")
+		}
+		fmt.Fprint(&body, "\r\n")
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	subject := fmt.Sprintf("Todow digest: %d overdue, %d due today, %d new", counts[0], counts[1], counts[2])
+	return newMailNotifier(d.To).send(subject, body.String())
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/j1436go/todow/store"
+)
+
+// checkDuplicate responds with 409 Conflict and returns false if the
+// body of an item about to be added closely matches that of open items,
+// see store.Duplicates, unless the "force" query parameter is true.
+// Shared lists would fill up with the same item added by each member.
+func checkDuplicate(w http.ResponseWriter, r *http.Request, body string) bool {
+	if r.URL.Query().Get("force") == "true" {
+		return true
+	}
+
+	dups, err := store.Duplicates(userStore(r), body)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if len(dups) > 0 {
+		refs := make([]string, len(dups))
+		for i, item := range dups {
+			refs[i] = fmt.Sprintf("#%d %q", item.ID, item.Body)
+		}
+		httpError(w, r, fmt.Sprintf("similar to open items: %s, add it anyway by forcing it", strings.Join(refs, ", ")), http.StatusConflict)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/j1436go/todow"
+)
+
+// streamFlushEvery is the number of items writeItems writes between
+// flushes.
+const streamFlushEvery = 500
+
+// writeJSON writes v as JSON, see writeTagged.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeTagged(w, r, "application/json", buf.Bytes())
+}
+
+// writeTagged writes body tagged with an ETag derived from its content.
+// If the request's If-None-Match header names that tag, only
+// 304 Not Modified is sent, so pollers don't download unchanged
+// responses again.
+func writeTagged(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	sum := sha1.Sum(body)
+	if notModified(w, r, sum[:]) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(body); err != nil {
+		requestLog(r).Debug("unable to write response", "err", err)
+	}
+}
+
+// writeItems writes col as JSON array like writeJSON, but encodes one
+// item at a time instead of the whole array at once, so memory stays
+// flat for large collections. The items are encoded twice, first for
+// the ETag, then to the client, flushing every streamFlushEvery items.
+func writeItems(w http.ResponseWriter, r *http.Request, col []*todow.Item) {
+	h := sha1.New()
+	if err := encodeItems(h, col); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, h.Sum(nil)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := encodeItems(w, col); err != nil {
+		requestLog(r).Debug("unable to write response", "err", err)
+	}
+}
+
+// encodeItems writes col as JSON array to w, flushing it every
+// streamFlushEvery items if it is an http.Flusher.
+func encodeItems(w io.Writer, col []*todow.Item) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	bw.WriteString("[")
+	for i, item := range col {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok && (i+1)%streamFlushEvery == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			f.Flush()
+		}
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+// notModified sets the ETag derived from the hash sum of a response
+// body. If the request's If-None-Match header names it, it responds
+// with 304 Not Modified and returns true.
+func notModified(w http.ResponseWriter, r *http.Request, sum []byte) bool {
+	etag := `"` + hex.EncodeToString(sum) + `"`
+	w.Header().Set("ETag", etag)
+
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatch reports whether the If-None-Match header value h matches etag.
+// Weak comparison is used as RFC 7232 requires for If-None-Match.
+func etagMatch(h, etag string) bool {
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// Kinds of change events.
+const (
+	eventAdded     = "added"
+	eventUpdated   = "updated"
+	eventCompleted = "completed"
+	eventRemoved   = "removed"
+
+	// eventReminder is published when the reminder of an item fires.
+	eventReminder = "reminder"
+)
+
+// An event describes a change to an item. Item is nil for removals.
+type event struct {
+	Type string
+	ID   int64
+	Item *todow.Item `json:",omitempty"`
+
+	// user owns the item.
+	user string
+}
+
+// A broker fans out events to all subscribed streams.
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan event]struct{}
+}
+
+var events = &broker{subs: map[chan event]struct{}{}}
+
+// subscribe returns a channel receiving events, buffering up to n of them.
+func (b *broker) subscribe(n int) chan event {
+	ch := make(chan event, n)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *broker) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+// publish sends e to all subscribers. Subscribers that fall behind
+// miss events rather than blocking the handler that made the change.
+func (b *broker) publish(e event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publishItem publishes an event of type typ for the item identified
+// by id, which belongs to the user who sent r. The item is loaded even
+// if the client went away, as the change has been made.
+func publishItem(r *http.Request, typ string, id int64) {
+	e := event{Type: typ, ID: id, user: storeOwner(r)}
+	if typ != eventRemoved {
+		item, err := userStore(r).WithContext(context.Background()).Get(id)
+		if err != nil {
+			requestLog(r).Error("unable to load item for event", "item", id, "event", typ, "err", err)
+			return
+		}
+		e.Item = item
+	}
+	events.publish(e)
+}
+
+// streamEvents streams changes to the items of the requesting user
+// as Server-Sent Events until the client disconnects.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, r, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	noWriteTimeout(w, r)
+	ch := events.subscribe(16)
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	f.Flush()
+
+	// Comments keep idle connections from being closed by proxies.
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e := <-ch:
+			if e.user != storeOwner(r) {
+				continue
+			}
+			if l, ok := requestList(r); ok && e.Item != nil && !e.Item.HasTag(l.tag) {
+				continue
+			}
+			j, err := json.Marshal(e)
+			if err != nil {
+				requestLog(r).Error("unable to encode event", "item", e.ID, "event", e.Type, "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, j)
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		f.Flush()
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// export writes all items, including done ones, as a downloadable file.
+// The format is read from the "format" query parameter, json, csv,
+// org for an Emacs org-mode outline or md for a Markdown task list.
+func export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	var write func(w http.ResponseWriter, col []*todow.Item) error
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		write = func(w http.ResponseWriter, col []*todow.Item) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			return enc.Encode(col)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		write = func(w http.ResponseWriter, col []*todow.Item) error {
+			return todow.WriteCSV(w, col)
+		}
+	case "org":
+		w.Header().Set("Content-Type", "text/x-org; charset=utf-8")
+		write = func(w http.ResponseWriter, col []*todow.Item) error {
+			return todow.WriteOrg(w, col)
+		}
+	case "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		write = func(w http.ResponseWriter, col []*todow.Item) error {
+			return todow.WriteMarkdown(w, col)
+		}
+	default:
+		httpError(w, r, fmt.Sprintf("unsupported export format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	col, err := userStore(r).All()
+	if err != nil {
+		w.Header().Del("Content-Type")
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := fmt.Sprintf("todow-%s.%s", time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	if err := write(w, col); err != nil {
+		requestLog(r).Error("export failed", "err", err)
+	}
+}
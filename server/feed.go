@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// feedSize is the maximum number of entries in the feed.
+const feedSize = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Author  string   `xml:"author>name"`
+	Content string   `xml:"content"`
+
+	t time.Time
+}
+
+// feed serves an Atom feed of recently added and completed items.
+func feed(w http.ResponseWriter, r *http.Request) {
+	col, err := userStore(r).All()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+
+	entry := func(item *todow.Item, verb string, t time.Time) atomEntry {
+		content := item.Body
+		for _, t := range item.Tags {
+			content += " " + todow.TagPrefix + t
+		}
+		return atomEntry{
+			Title:   fmt.Sprintf("%s: %s", verb, item.Body),
+			ID:      fmt.Sprintf("%s%s%d#%s", base, todow.APIPath, item.ID, verb),
+			Updated: t.Format(time.RFC3339),
+			Link:    atomLink{Href: fmt.Sprintf("%s%s%d", base, todow.APIPath, item.ID)},
+			Author:  "todow",
+			Content: content,
+			t:       t,
+		}
+	}
+
+	var entries []atomEntry
+	for _, item := range col {
+		if !item.Created.IsZero() {
+			entries = append(entries, entry(item, "Added", item.Created))
+		}
+		if item.Done && !item.CompletedAt.IsZero() {
+			entries = append(entries, entry(item, "Completed", item.CompletedAt))
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].t.After(entries[j].t) })
+	if len(entries) > feedSize {
+		entries = entries[:feedSize]
+	}
+
+	updated := time.Unix(0, 0)
+	if len(entries) > 0 {
+		updated = entries[0].t
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err = enc.Encode(atomFeed{
+		Title:   "Todow",
+		ID:      base + "/",
+		Updated: updated.Format(time.RFC3339),
+		Link:    atomLink{Href: base + "/feed.atom", Rel: "self"},
+		Entries: entries,
+	})
+	if err != nil {
+		requestLog(r).Debug("unable to write feed", "err", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow/store"
+)
+
+// A savedFilter is a filter expression, see store.FilterValues, saved
+// by a user under a name. The listings select its items with the
+// "filter" query parameter, which the web UI shows as a list of its own.
+type savedFilter struct {
+	Name string
+	Expr string
+}
+
+// validFilters returns an error if a filter of filters has no, a
+// duplicate or an invalid name, or an invalid expression.
+func validFilters(filters []savedFilter) error {
+	seen := map[string]bool{}
+	for _, f := range filters {
+		name := strings.TrimSpace(f.Name)
+		switch {
+		case name == "":
+			return fmt.Errorf("missing filter name")
+		case name != f.Name:
+			return fmt.Errorf("filter name %q starts or ends with spaces", f.Name)
+		case strings.Contains(name, ":"):
+			// The settings page separates names from expressions by colons.
+			return fmt.Errorf("filter name %q contains a colon", name)
+		case seen[name]:
+			return fmt.Errorf("duplicate filter %q", name)
+		}
+		seen[name] = true
+		if _, err := store.FilterValues(f.Expr, time.Now()); err != nil {
+			return fmt.Errorf("filter %q: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// filterValues returns the query parameters v of a listing requested
+// by r with the saved filter named by the "filter" parameter, if any,
+// in its place. The filter takes precedence over the parameters it sets.
+func filterValues(r *http.Request, v url.Values) (url.Values, error) {
+	name := v.Get("filter")
+	if name == "" {
+		return v, nil
+	}
+
+	for _, f := range settingsOf(userName(r)).Filters {
+		if f.Name != name {
+			continue
+		}
+		fv, err := store.FilterValues(f.Expr, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %s", name, err)
+		}
+		res := url.Values{}
+		for k, vs := range v {
+			res[k] = vs
+		}
+		for k, vs := range fv {
+			res[k] = vs
+		}
+		res.Del("filter")
+		return res, nil
+	}
+	return nil, fmt.Errorf("no filter named %q", name)
+}
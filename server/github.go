@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var (
+	githubRepos    stringList
+	githubToken    = flags.String("github-token", "", "GitHub token the issues of -github-repo are synced with")
+	githubUser     = flags.String("github-user", "", "User whose items the GitHub issues are synced to, the default one if empty")
+	githubTag      = flags.String("github-tag", "github", "Tag of the items synced with GitHub issues, the list they make up")
+	githubInterval = flags.Duration("github-interval", 15*time.Minute, "Interval between GitHub syncs")
+	githubClose    = flags.Bool("github-close", false, "Close the GitHub issues of completed items")
+	githubAPI      = flags.String("github-api", "https://api.github.com", "URL of the GitHub API, which differs for GitHub Enterprise")
+)
+
+func init() {
+	flags.Var(&githubRepos, "github-repo", "GitHub repository OWNER/REPO whose open issues assigned to the owner of -github-token "+
+		"are synced to items, may be given multiple times")
+}
+
+// githubMetaKey is the metadata field linking items to the issues they
+// mirror, like "owner/repo#12".
+const githubMetaKey = "github"
+
+// A githubIssue is an issue as the GitHub API returns it.
+type githubIssue struct {
+	Number  int
+	Title   string
+	HTMLURL string `json:"html_url"`
+	// PullRequest is set for pull requests, which the API lists as issues.
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+// syncGitHub syncs the open issues of -github-repo assigned to the owner
+// of -github-token with the items of -github-user every -github-interval.
+// It never returns.
+func syncGitHub() {
+	for {
+		if err := githubSync(stores[*githubUser]); err != nil {
+			slog.Error("unable to sync GitHub issues", "err", err)
+		}
+		time.Sleep(*githubInterval)
+	}
+}
+
+// githubSync adds an item tagged with -github-tag to s for each open
+// issue assigned to the owner of -github-token that has none. It updates
+// the bodies of open items to the issues' titles, and completes those
+// whose issue was closed or is no longer assigned. With -github-close,
+// the issues of completed items are closed.
+func githubSync(s store.Store) error {
+	var me struct{ Login string }
+	if err := githubDo("GET", "/user", nil, &me); err != nil {
+		return err
+	}
+
+	issues := map[string]githubIssue{}
+	for _, repo := range githubRepos {
+		for page := 1; ; page++ {
+			var col []githubIssue
+			path := fmt.Sprintf("/repos/%s/issues?state=open&assignee=%s&per_page=100&page=%d", repo, me.Login, page)
+			if err := githubDo("GET", path, nil, &col); err != nil {
+				return err
+			}
+			for _, issue := range col {
+				if issue.PullRequest == nil {
+					issues[fmt.Sprintf("%s#%d", repo, issue.Number)] = issue
+				}
+			}
+			if len(col) < 100 {
+				break
+			}
+		}
+	}
+
+	// Archived items mustn't be added again while their issue is open.
+	var col []*todow.Item
+	for _, archived := range []bool{false, true} {
+		found, _, err := s.Find(store.Query{Tags: []string{*githubTag}, Archived: archived, Deferred: true})
+		if err != nil {
+			return err
+		}
+		col = append(col, found...)
+	}
+	mirrored := map[string]bool{}
+	for _, item := range col {
+		ref := item.Meta[githubMetaKey]
+		if ref == "" {
+			continue
+		}
+		mirrored[ref] = true
+		issue, open := issues[ref]
+
+		switch {
+		case item.Done && open && *githubClose:
+			if err := githubCloseIssue(ref); err != nil {
+				slog.Error("unable to close GitHub issue", "issue", ref, "item", item.ID, "err", err)
+				continue
+			}
+			slog.Info("closed GitHub issue", "issue", ref, "item", item.ID)
+		case item.Done:
+		case !open:
+			githubUpdate(s, item.ID, func(i *todow.Item) { i.SetDone(true) })
+		case issue.Title != item.Body:
+			githubUpdate(s, item.ID, func(i *todow.Item) { i.Body = issue.Title })
+		}
+	}
+
+	for ref, issue := range issues {
+		if mirrored[ref] {
+			continue
+		}
+		item := todow.Item{
+			Body:    issue.Title,
+			Notes:   issue.HTMLURL,
+			Tags:    []string{*githubTag},
+			Meta:    map[string]string{githubMetaKey: ref},
+			Created: time.Now(),
+		}
+		if err := s.Add(&item); err != nil {
+			return err
+		}
+		events.publish(event{Type: eventAdded, ID: item.ID, Item: &item, user: *githubUser})
+	}
+	return nil
+}
+
+// githubUpdate applies fn to the item of s identified by id and
+// publishes the change. Failures are logged.
+func githubUpdate(s store.Store, id int64, fn func(*todow.Item)) {
+	var item *todow.Item
+	err := s.Update(id, func(i *todow.Item) {
+		fn(i)
+		c := *i
+		item = &c
+	}, nil)
+	if err != nil {
+		slog.Error("unable to update item synced with GitHub", "item", id, "err", err)
+		return
+	}
+	typ := eventUpdated
+	if item.Done {
+		typ = eventCompleted
+	}
+	events.publish(event{Type: typ, ID: id, Item: item, user: *githubUser})
+}
+
+// githubCloseIssue closes the issue ref, like "owner/repo#12".
+func githubCloseIssue(ref string) error {
+	i := strings.LastIndex(ref, "#")
+	path := fmt.Sprintf("/repos/%s/issues/%s", ref[:i], ref[i+1:])
+	return githubDo("PATCH", path, map[string]string{"state": "closed"}, nil)
+}
+
+// githubDo sends a request with the JSON encoded body, if not nil, to
+// the GitHub API and decodes the response into v, if not nil.
+func githubDo(method, path string, body, v interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(*githubAPI, "/")+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*githubToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	c := http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: GitHub responded with %s", method, path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+	"golang.org/x/oauth2"
+)
+
+var (
+	googleClientID     = flags.String("google-client-id", "", "OAuth client ID users connect their items to Google Tasks with on the settings page")
+	googleClientSecret = flags.String("google-client-secret", "", "OAuth client secret of -google-client-id")
+	googleInterval     = flags.Duration("google-interval", 5*time.Minute, "Interval between Google Tasks syncs")
+)
+
+// googleTasksAPI is the URL of the Google Tasks API.
+const googleTasksAPI = "https://tasks.googleapis.com/tasks/v1"
+
+// googleMetaKey is the metadata field linking items to the Google Tasks
+// tasks they are pushed to.
+const googleMetaKey = "google-task"
+
+// googleTasks connects the items of a user to a task list of their
+// Google Tasks. Open items with due date are pushed to it as tasks,
+// completions are synced both ways.
+type googleTasks struct {
+	// List is the ID of the task list.
+	List      string
+	Token     *oauth2.Token `json:",omitempty"`
+	Connected time.Time
+}
+
+// googleConfig returns the OAuth configuration redirecting to redirect.
+func googleConfig(redirect string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     *googleClientID,
+		ClientSecret: *googleClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+			TokenURL: "https://oauth2.googleapis.com/token",
+		},
+		Scopes:      []string{"https://www.googleapis.com/auth/tasks"},
+		RedirectURL: redirect,
+	}
+}
+
+// connectGoogle sends the user to Google to allow access to their tasks,
+// and creates the task list their items are pushed to once Google sends
+// them back to settings/google/callback.
+func connectGoogle(w http.ResponseWriter, r *http.Request) {
+	if *googleClientID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	conf := googleConfig(fmt.Sprintf("%s://%s/settings/google/callback", scheme, r.Host))
+
+	if r.URL.Path == "/settings/google" {
+		// The CSRF token ties the callback to the user who asked for it.
+		http.Redirect(w, r, conf.AuthCodeURL(csrfToken(r), oauth2.AccessTypeOffline, oauth2.ApprovalForce), http.StatusFound)
+		return
+	}
+
+	q := r.URL.Query()
+	if !hmac.Equal([]byte(q.Get("state")), []byte(csrfToken(r))) {
+		httpError(w, r, "invalid OAuth state", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		httpError(w, r, fmt.Sprintf("Google refused access: %s", e), http.StatusForbidden)
+		return
+	}
+	tok, err := conf.Exchange(r.Context(), q.Get("code"))
+	if err != nil {
+		httpError(w, r, fmt.Sprintf("unable to get Google token: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	var list struct{ ID string }
+	c := conf.Client(r.Context(), tok)
+	if err := googleDo(c, "POST", "/users/@me/lists", map[string]string{"title": "todow"}, &list); err != nil {
+		httpError(w, r, fmt.Sprintf("unable to create Google task list: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	settings.Lock()
+	defer settings.Unlock()
+	s, ok := settings.users[userName(r)]
+	if !ok {
+		s = &userSettings{}
+	}
+	old := *s
+	s.Google = &googleTasks{List: list.ID, Token: tok, Connected: time.Now()}
+	settings.users[userName(r)] = s
+	if err := saveSettings(); err != nil {
+		*s = old
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, "", auditGoogle, list.ID)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// syncGoogle syncs the items of all users connected to Google Tasks
+// every -google-interval. It never returns.
+func syncGoogle() {
+	for range time.Tick(*googleInterval) {
+		for name, s := range stores {
+			g := settingsOf(name).Google
+			if g == nil || g.Token == nil {
+				continue
+			}
+			if err := googleSync(name, s, *g); err != nil {
+				slog.Error("unable to sync items with Google Tasks", "user", name, "err", err)
+			}
+		}
+	}
+}
+
+// A googleTask is a task as the Google Tasks API returns it.
+type googleTask struct {
+	ID     string `json:"id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+	Status string `json:"status,omitempty"`
+	// Due is an RFC 3339 time, of which only the date is used.
+	Due string `json:"due,omitempty"`
+}
+
+// googleDue returns the due date of tasks due at t.
+func googleDue(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02") + "T00:00:00.000Z"
+}
+
+// googleSync pushes the open items of s, which belongs to the named user,
+// that are due to their Google task list g, updates the tasks of changed
+// items and completes the tasks of completed items and the other way
+// round.
+func googleSync(name string, s store.Store, g googleTasks) error {
+	ctx := context.Background()
+	tok, err := googleConfig("").TokenSource(ctx, g.Token).Token()
+	if err != nil {
+		return err
+	}
+	if tok.AccessToken != g.Token.AccessToken {
+		googleTokenRefreshed(name, tok)
+	}
+	c := oauth2.NewClient(ctx, oauth2.StaticTokenSource(tok))
+	path := "/lists/" + url.PathEscape(g.List) + "/tasks"
+
+	tasks := map[string]googleTask{}
+	for page := ""; ; {
+		var resp struct {
+			Items         []googleTask
+			NextPageToken string
+		}
+		q := url.Values{"showCompleted": {"true"}, "showHidden": {"true"}, "maxResults": {"100"}}
+		if page != "" {
+			q.Set("pageToken", page)
+		}
+		if err := googleDo(c, "GET", path+"?"+q.Encode(), nil, &resp); err != nil {
+			return err
+		}
+		for _, t := range resp.Items {
+			tasks[t.ID] = t
+		}
+		if page = resp.NextPageToken; page == "" {
+			break
+		}
+	}
+
+	col, _, err := s.Find(store.Query{Deferred: true})
+	if err != nil {
+		return err
+	}
+	for _, item := range col {
+		id := item.Meta[googleMetaKey]
+		if id == "" {
+			if item.Done || item.Due.IsZero() {
+				continue
+			}
+			var t googleTask
+			task := googleTask{Title: item.Body, Notes: item.Notes, Due: googleDue(item.Due)}
+			if err := googleDo(c, "POST", path, task, &t); err != nil {
+				return err
+			}
+			googleUpdate(name, s, item.ID, func(i *todow.Item) {
+				meta := map[string]string{googleMetaKey: t.ID}
+				for k, v := range i.Meta {
+					meta[k] = v
+				}
+				i.Meta = meta
+			})
+			continue
+		}
+
+		// Tasks removed in Google Tasks stay so.
+		t, ok := tasks[id]
+		if !ok {
+			continue
+		}
+		var patch googleTask
+		switch {
+		case t.Status == "completed" && !item.Done:
+			googleUpdate(name, s, item.ID, func(i *todow.Item) { i.SetDone(true) })
+			continue
+		case item.Done && t.Status != "completed":
+			patch.Status = "completed"
+		case item.Done:
+			continue
+		case t.Title != item.Body || !item.Due.IsZero() && t.Due != googleDue(item.Due):
+			patch.Title = item.Body
+			if !item.Due.IsZero() {
+				patch.Due = googleDue(item.Due)
+			}
+		default:
+			continue
+		}
+		if err := googleDo(c, "PATCH", path+"/"+url.PathEscape(id), patch, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// googleTokenRefreshed saves the refreshed Google token of the named user.
+func googleTokenRefreshed(name string, tok *oauth2.Token) {
+	settings.Lock()
+	defer settings.Unlock()
+	s, ok := settings.users[name]
+	if !ok || s.Google == nil {
+		return
+	}
+	// settingsOf copies share the connection, so it is replaced.
+	g := *s.Google
+	g.Token = tok
+	s.Google = &g
+	if err := saveSettings(); err != nil {
+		slog.Error("unable to save settings", "user", name, "err", err)
+	}
+}
+
+// googleUpdate applies fn to the item of s, which belongs to the named
+// user, identified by id and publishes the change. Failures are logged.
+func googleUpdate(name string, s store.Store, id int64, fn func(*todow.Item)) {
+	var item *todow.Item
+	err := s.Update(id, func(i *todow.Item) {
+		fn(i)
+		c := *i
+		item = &c
+	}, nil)
+	if err != nil {
+		slog.Error("unable to update item synced with Google Tasks", "item", id, "err", err)
+		return
+	}
+	typ := eventUpdated
+	if item.Done {
+		typ = eventCompleted
+	}
+	events.publish(event{Type: typ, ID: id, Item: item, user: name})
+}
+
+// googleDo sends a request with the JSON encoded body, if not nil, to
+// the Google Tasks API with c and decodes the response into v, if not nil.
+func googleDo(c *http.Client, method, path string, body, v interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, googleTasksAPI+path, &buf)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	c.Timeout = 30 * time.Second
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: Google responded with %s", method, path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	loginMaxFailures = flags.Int("login-max-failures", 5, "Failed logins from an IP or for a user before further attempts are locked out")
+	loginLockout     = flags.Duration("login-lockout", time.Minute, "Duration of the first lockout, doubled with every further failed login")
+	loginExempt      = flags.String("login-exempt", "", "Comma separated CIDRs, e.g. 192.168.0.0/16, whose logins are never locked out")
+)
+
+// maxLockout caps escalating lockouts. Failures older than it are forgotten.
+const maxLockout = 24 * time.Hour
+
+// A loginGuard tracks failed logins per IP and user name and locks out
+// further attempts once there were too many.
+type loginGuard struct {
+	mu       sync.Mutex
+	failures map[string]*failures
+	exempt   []*net.IPNet
+}
+
+type failures struct {
+	n     int
+	last  time.Time
+	until time.Time
+}
+
+var guard = &loginGuard{failures: map[string]*failures{}}
+
+// parseExempt sets the networks exempt from lockouts from a comma
+// separated list of CIDRs.
+func (g *loginGuard) parseExempt(cidrs string) error {
+	for _, s := range strings.Split(cidrs, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		g.exempt = append(g.exempt, n)
+	}
+	return nil
+}
+
+func (g *loginGuard) isExempt(ip net.IP) bool {
+	for _, n := range g.exempt {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// keys returns the keys failed logins of r are tracked under,
+// or nil if r comes from an exempt network. If the address of the
+// client is unknown, they are only tracked by user, so clients behind
+// the same proxy don't lock each other out.
+func (g *loginGuard) keys(r *http.Request, user string) []string {
+	host := remoteHost(r)
+	if ip := net.ParseIP(host); ip != nil && g.isExempt(ip) {
+		return nil
+	}
+
+	var keys []string
+	if host != "" {
+		keys = append(keys, "ip "+host)
+	}
+	if user != "" {
+		keys = append(keys, "user "+user)
+	}
+	return keys
+}
+
+// lockedFor returns how long logins under any of keys are still locked out.
+func (g *loginGuard) lockedFor(keys []string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var d time.Duration
+	now := time.Now()
+	for _, k := range keys {
+		if f, ok := g.failures[k]; ok && f.until.Sub(now) > d {
+			d = f.until.Sub(now)
+		}
+	}
+	return d
+}
+
+// fail records a failed login under keys, locking them out if
+// there were too many.
+func (g *loginGuard) fail(keys []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.forget(now)
+
+	for _, k := range keys {
+		f, ok := g.failures[k]
+		if !ok {
+			f = &failures{}
+			g.failures[k] = f
+		}
+		f.n++
+		f.last = now
+
+		if f.n < *loginMaxFailures {
+			continue
+		}
+		d := *loginLockout << uint(f.n-*loginMaxFailures)
+		if d > maxLockout || d <= 0 {
+			d = maxLockout
+		}
+		f.until = now.Add(d)
+		slog.Warn("locked out after failed logins", "key", k, "duration", d, "failures", f.n)
+	}
+}
+
+// succeed forgets the failed logins for the user of keys. Those of the
+// IP are kept, otherwise an attacker knowing one password could guess
+// those of other users without ever being locked out.
+func (g *loginGuard) succeed(keys []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, k := range keys {
+		if strings.HasPrefix(k, "user ") {
+			delete(g.failures, k)
+		}
+	}
+}
+
+// forget drops failures that happened long ago. The caller must hold g.mu.
+func (g *loginGuard) forget(now time.Time) {
+	for k, f := range g.failures {
+		if now.Sub(f.last) > maxLockout && now.After(f.until) {
+			delete(g.failures, k)
+		}
+	}
+}
+
+// tooManyLogins replies that logins are locked out for d.
+func tooManyLogins(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	secs := int(d/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	httpError(w, r, fmt.Sprintf("too many failed logins, retry in %ds", secs), http.StatusTooManyRequests)
+}
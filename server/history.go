@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+// itemChanges returns the changes from old to item recorded in the
+// history of the item.
+func itemChanges(old, item *todow.Item) []store.Change {
+	var changes []store.Change
+	for _, f := range []struct{ name, old, new string }{
+		{"body", old.Body, item.Body},
+		{"notes", old.Notes, item.Notes},
+		{"checklist", todow.FormatChecklist(old.Checklist), todow.FormatChecklist(item.Checklist)},
+		{"tags", strings.Join(old.Tags, " "), strings.Join(item.Tags, " ")},
+		{"priority", old.Priority, item.Priority},
+	} {
+		if f.old != f.new {
+			changes = append(changes, store.Change{Kind: store.ChangeEdited, Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	if !old.Due.Equal(item.Due) {
+		changes = append(changes, store.Change{Kind: store.ChangeDue, Old: historyTime(old.Due), New: historyTime(item.Due)})
+	}
+	switch {
+	case item.Done && !old.Done:
+		changes = append(changes, store.Change{Kind: store.ChangeCompleted})
+	case !item.Done && old.Done:
+		changes = append(changes, store.Change{Kind: store.ChangeReopened})
+	}
+	return changes
+}
+
+// historyTime formats t for the history, the zero time as "".
+func historyTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// recording wraps fn, which changes items, to collect the changes it
+// makes by item ID into changes.
+func recording(fn func(*todow.Item), changes map[int64][]store.Change) func(*todow.Item) {
+	if fn == nil {
+		return nil
+	}
+	return func(item *todow.Item) {
+		old := *item
+		fn(item)
+		changes[item.ID] = append(changes[item.ID], itemChanges(&old, item)...)
+	}
+}
+
+// recordChanges appends changes made by the user of r to the histories
+// of their items, if the store keeps histories. Failures are logged,
+// the request proceeds regardless.
+func recordChanges(r *http.Request, changes map[int64][]store.Change) {
+	h, ok := stores[storeOwner(r)].(store.Historian)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	name := loginName(userName(r))
+	for id, col := range changes {
+		for _, c := range col {
+			c.Time, c.User = now, name
+			if err := h.Record(id, c); err != nil {
+				requestLog(r).Error("unable to record change in item history", "change", c.Kind, "item", id, "err", err)
+			}
+		}
+	}
+}
+
+// recordCreated records the creation of the item identified by id.
+func recordCreated(r *http.Request, id int64) {
+	recordChanges(r, map[int64][]store.Change{id: {{Kind: store.ChangeCreated}}})
+}
+
+// itemHistory lists the changes of an item as JSON, oldest first.
+func itemHistory(w http.ResponseWriter, r *http.Request, id int64) {
+	h, ok := stores[storeOwner(r)].WithContext(storeContext(r)).(store.Historian)
+	if !ok {
+		httpError(w, r, fmt.Sprintf("item histories of %s stores are not supported", *storeKind), http.StatusNotImplemented)
+		return
+	}
+
+	// Items of shared lists must be part of the list.
+	switch _, err := userStore(r).Get(id); err.(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+		return
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	col, err := h.History(id)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, col)
+}
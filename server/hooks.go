@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/quickadd"
+)
+
+var hookTokens stringList
+
+func init() {
+	flags.Var(&hookTokens, "hook-token", "Token accepted by the incoming webhook "+todow.APIPath+"hooks/TOKEN, may be given multiple times. "+
+		"Prefix it with USER: to add items for another user than the default one")
+}
+
+// hook adds an item posted to the incoming webhook. Instead of HTTP Basic
+// credentials, the request is authenticated by the token in its path.
+// The body is either plain text, parsed by quickadd for tags, priority
+// and due date, or a JSON object with such a body and optional notes,
+// tags and due date.
+func hook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	name, ok := hookUser(strings.TrimPrefix(r.URL.Path, todow.APIPath+"hooks/"))
+	if !ok {
+		audit(r, "", auditHook, "unknown token")
+		httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	r = withUser(r, name)
+	audit(r, "", auditHook, "")
+
+	p, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		httpError(w, r, fmt.Sprintf("unable to read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var item todow.Item
+
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "application/json" {
+		var payload struct {
+			Body  string
+			Notes string
+			Tags  []string
+			Due   string
+		}
+		if err := json.Unmarshal(p, &payload); err != nil {
+			httpError(w, r, fmt.Sprintf("unable to decode payload: %s", err), http.StatusBadRequest)
+			return
+		}
+		item = quickadd.Parse(payload.Body, time.Now())
+		item.Notes = payload.Notes
+		item.Tags = append(item.Tags, payload.Tags...)
+		if payload.Due != "" {
+			item.Due, err = time.ParseInLocation(todow.DueFormat, payload.Due, time.Local)
+			if err != nil {
+				httpError(w, r, fmt.Sprintf("unable to parse due date: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+	} else {
+		item = quickadd.Parse(string(p), time.Now())
+	}
+	item.Created = time.Now()
+
+	if item.Body == "" {
+		httpError(w, r, "item body must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	if err := userStore(r).Add(&item); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordCreated(r, item.ID)
+	publishItem(r, eventAdded, item.ID)
+
+	w.WriteHeader(201)
+	fmt.Fprintf(w, "Added item #%d\n", item.ID)
+}
+
+// hookUser returns the name of the user token adds items for.
+func hookUser(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for _, t := range hookTokens {
+		var name string
+		if i := strings.Index(t, ":"); i >= 0 {
+			name, t = t[:i], t[i+1:]
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			_, ok := stores[name]
+			return name, ok
+		}
+	}
+	return "", false
+}
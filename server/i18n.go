@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var lang = flags.String("lang", "en", "Language of the web UI for browsers preferring none of the available ones, en or de")
+
+// messages are the strings of the web UI in one language by key.
+// Templates get them as .T, e.g. {{.T.add}}, and scripts as the JSON
+// in the data-messages attribute of the body. The key lang holds the
+// language.
+type messages map[string]string
+
+// JSON returns m encoded as JSON.
+func (m messages) JSON() string {
+	p, _ := json.Marshal(m)
+	return string(p)
+}
+
+// bundles are the messages of the web UI by language, parsed by loadMessages.
+var bundles map[string]messages
+
+// loadMessages parses the message bundles of the web UI, web/i18n/LANG.json.
+// Strings missing from a bundle are taken from the English one.
+func loadMessages() error {
+	files := webDir("i18n", "")
+	names, err := fs.Glob(files, "*.json")
+	if err != nil {
+		return err
+	}
+
+	bundles = map[string]messages{}
+	for _, name := range names {
+		p, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		var m messages
+		if err := json.Unmarshal(p, &m); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		l := strings.TrimSuffix(name, ".json")
+		m["lang"] = l
+		bundles[l] = m
+	}
+
+	en, ok := bundles["en"]
+	if !ok {
+		return fmt.Errorf("no English messages")
+	}
+	for _, m := range bundles {
+		for k, v := range en {
+			if _, ok := m[k]; !ok {
+				m[k] = v
+			}
+		}
+	}
+
+	if _, ok := bundles[*lang]; !ok {
+		return fmt.Errorf("unsupported language %q, use one of %s", *lang, strings.Join(languages(), ", "))
+	}
+	return nil
+}
+
+// languages returns the languages of the bundles in order.
+func languages() []string {
+	var l []string
+	for k := range bundles {
+		l = append(l, k)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// requestMessages returns the messages in the language the browser that
+// sent r prefers, and notes in the response headers that they depend on
+// the Accept-Language header.
+func requestMessages(w http.ResponseWriter, r *http.Request) messages {
+	w.Header().Add("Vary", "Accept-Language")
+	return bundles[acceptLanguage(r.Header.Get("Accept-Language"))]
+}
+
+// acceptLanguage returns the language of the bundles the Accept-Language
+// header value accept prefers, or -lang if it accepts none of them.
+// Regional variants like de-AT select their language.
+func acceptLanguage(accept string) string {
+	best, bestQ := *lang, 0.0
+	for _, r := range strings.Split(accept, ",") {
+		fields := strings.Split(r, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		tag = strings.SplitN(tag, "-", 2)[0]
+		if _, ok := bundles[tag]; !ok {
+			continue
+		}
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v := strings.TrimSpace(f); strings.HasPrefix(v, "q=") {
+				if f, err := strconv.ParseFloat(v[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// addKeyTTL is how long the Idempotency-Key of an add is remembered.
+const addKeyTTL = 24 * time.Hour
+
+// addKeys maps the Idempotency-Key header of adds to the item they
+// added. The offline queue of the web UI replays adds whose response
+// was lost, which must not add the item twice.
+var addKeys = struct {
+	sync.Mutex
+	m map[string]addedItem
+}{m: map[string]addedItem{}}
+
+type addedItem struct {
+	id int64
+	at time.Time
+}
+
+// addKey returns the key r is remembered by, or "" if it has none.
+// Keys are scoped to the user, who can't replay the adds of others.
+func addKey(r *http.Request) string {
+	k := r.Header.Get("Idempotency-Key")
+	if k == "" {
+		return ""
+	}
+	return userName(r) + "\x00" + k
+}
+
+// addedBefore returns the item added by a previous request with the
+// Idempotency-Key of r.
+func addedBefore(r *http.Request) (int64, bool) {
+	k := addKey(r)
+	if k == "" {
+		return 0, false
+	}
+	addKeys.Lock()
+	defer addKeys.Unlock()
+	a, ok := addKeys.m[k]
+	if !ok || time.Since(a.at) > addKeyTTL {
+		return 0, false
+	}
+	return a.id, true
+}
+
+// rememberAdd remembers that r added the item id, and forgets keys
+// older than addKeyTTL.
+func rememberAdd(r *http.Request, id int64) {
+	k := addKey(r)
+	if k == "" {
+		return
+	}
+	addKeys.Lock()
+	defer addKeys.Unlock()
+	for old, a := range addKeys.m {
+		if time.Since(a.at) > addKeyTTL {
+			delete(addKeys.m, old)
+		}
+	}
+	addKeys.m[k] = addedItem{id: id, at: time.Now()}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/todotxt"
+)
+
+// importFormats maps import formats to the content types that select them.
+var importFormats = map[string]string{
+	"application/json": "json",
+	"text/csv":         "csv",
+	"text/plain":       "todotxt",
+}
+
+// importItems adds all items of the request body. The format is read from
+// the "format" query parameter or derived from the content type.
+// Imported items get new IDs, references to parents and blocking items
+// are rewritten accordingly. Items equal to an existing item or to an
+// item imported earlier are skipped, their children are added below the
+// item they duplicate.
+func importItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importFormats[ct]
+	}
+
+	col, err := decodeItems(format, r.Body)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := userStore(r)
+
+	existing, err := db.All()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// seen maps duplicate keys to the IDs of the items they belong to.
+	seen := map[string]int64{}
+	for _, v := range existing {
+		seen[duplicateKey(v)] = v.ID
+	}
+
+	var added, skipped int
+	ids := map[int64]int64{}
+	// blockers holds the blockers of added items by their new IDs,
+	// set once all items got theirs.
+	blockers := map[int64][]int64{}
+
+	// Nesting orders parents before their children.
+	for _, v := range todow.Nest(col) {
+		item := v.Item
+
+		oldID := item.ID
+
+		key := duplicateKey(item)
+		if id, ok := seen[key]; ok {
+			// Children of a duplicate go below the item it duplicates.
+			if oldID != 0 {
+				ids[oldID] = id
+			}
+			skipped++
+			continue
+		}
+
+		item.ParentID = ids[item.ParentID]
+		blockedBy := item.BlockedBy
+		item.BlockedBy = nil
+		if item.Created.IsZero() {
+			item.Created = time.Now()
+		}
+
+		if err := db.Add(item); err != nil {
+			httpError(w, r, fmt.Sprintf("imported %d items, then failed: %s", added, err), http.StatusInternalServerError)
+			return
+		}
+		recordCreated(r, item.ID)
+		events.publish(event{Type: eventAdded, ID: item.ID, Item: item, user: storeOwner(r)})
+		if oldID != 0 {
+			ids[oldID] = item.ID
+		}
+		if len(blockedBy) > 0 {
+			blockers[item.ID] = blockedBy
+		}
+		seen[key] = item.ID
+		added++
+	}
+
+	// Blockers that weren't imported are dropped.
+	for id, blockedBy := range blockers {
+		var mapped []int64
+		for _, b := range blockedBy {
+			if n, ok := ids[b]; ok {
+				mapped = append(mapped, n)
+			}
+		}
+		if len(mapped) == 0 {
+			continue
+		}
+		if err := db.Update(id, func(item *todow.Item) { item.BlockedBy = mapped }, nil); err != nil {
+			httpError(w, r, fmt.Sprintf("imported %d items, then failed: %s", added, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	requestLog(r).Info("imported items", "added", added, "duplicates", skipped)
+	w.WriteHeader(201)
+	fmt.Fprintf(w, "Imported %d items, skipped %d duplicates\n", added, skipped)
+}
+
+func decodeItems(format string, r io.Reader) ([]*todow.Item, error) {
+	switch format {
+	case "json":
+		var col []*todow.Item
+		if err := json.NewDecoder(r).Decode(&col); err != nil {
+			return nil, fmt.Errorf("unable to decode items: %s", err)
+		}
+		return col, nil
+	case "csv":
+		return todow.ReadCSV(r)
+	case "todotxt":
+		return todotxt.Read(r)
+	case "":
+		return nil, fmt.Errorf("unknown import format, set the format parameter or content type")
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+// duplicateKey is equal for items considered duplicates.
+func duplicateKey(item *todow.Item) string {
+	return fmt.Sprintf("%t:%s", item.Done, strings.ToLower(strings.Join(strings.Fields(item.Body), " ")))
+}
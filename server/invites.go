@@ -0,0 +1,411 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var (
+	invitesFile = flags.String("invites", "todow.invites", "File the invitations to shared lists are kept in")
+	externalURL = flags.String("url", "", "URL the server is reached at from outside, like https://todo.example.com, which mailed invitations link to. "+
+		"Invitations by mail are refused if empty")
+)
+
+// An invite grants another user read or write access to the items of
+// Owner tagged with Tag, a shared list. Invitations by mail have no
+// invitee until someone follows the mailed link and accepts them.
+type invite struct {
+	Token    string
+	Owner    string `json:",omitempty"`
+	Tag      string
+	Invitee  string `json:",omitempty"`
+	Email    string `json:",omitempty"`
+	Accepted bool
+	Write    bool
+	Created  time.Time
+}
+
+// invites holds all invitations, which are persisted to invitesFile.
+var invites struct {
+	sync.Mutex
+	all []invite
+}
+
+// loadInvites reads the invites file, if it exists.
+func loadInvites() error {
+	p, err := ioutil.ReadFile(*invitesFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, &invites.all)
+}
+
+// saveInvites atomically replaces the invites file.
+// The caller must hold the invites lock.
+func saveInvites() error {
+	p, err := json.MarshalIndent(invites.all, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(*invitesFile, p)
+}
+
+// loginName returns the name the user known internally as name logs in
+// with, the empty name denoting the default user.
+func loginName(name string) string {
+	if name == "" {
+		return *user
+	}
+	return name
+}
+
+// internalName is the inverse of loginName.
+func internalName(login string) string {
+	if login == *user {
+		return ""
+	}
+	return login
+}
+
+// listName returns the name shared lists are selected by, OWNER/TAG.
+func listName(owner, tag string) string {
+	return loginName(owner) + "/" + tag
+}
+
+// A listAccess is the shared list a request works on.
+type listAccess struct {
+	owner string
+	tag   string
+	write bool
+}
+
+// withList selects the shared list named by the "list" query parameter
+// or the X-Todow-List header of r, if any. It fails if the user of r
+// is neither the owner of the list nor invited to it.
+func withList(r *http.Request) (*http.Request, error) {
+	name := r.URL.Query().Get("list")
+	if name == "" {
+		name = r.Header.Get("X-Todow-List")
+	}
+	if name == "" {
+		return r, nil
+	}
+
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return r, fmt.Errorf("list %q is not of the form OWNER/TAG", name)
+	}
+	l := listAccess{
+		owner: internalName(name[:i]),
+		tag:   strings.TrimPrefix(name[i+1:], todow.TagPrefix),
+	}
+	if _, ok := stores[l.owner]; !ok {
+		return r, fmt.Errorf("no list %s shared with you", name)
+	}
+
+	if l.owner == userName(r) {
+		l.write = true
+	} else {
+		// A user may have joined through several invitations.
+		invites.Lock()
+		var ok bool
+		for _, inv := range invites.all {
+			if inv.Accepted && inv.Owner == l.owner && inv.Tag == l.tag && inv.Invitee == userName(r) {
+				ok, l.write = true, l.write || inv.Write
+			}
+		}
+		invites.Unlock()
+		if !ok {
+			return r, fmt.Errorf("no list %s shared with you", name)
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), listKey, l)), nil
+}
+
+// requestList returns the shared list r works on.
+func requestList(r *http.Request) (listAccess, bool) {
+	l, ok := r.Context().Value(listKey).(listAccess)
+	return l, ok
+}
+
+// storeOwner returns the name of the user owning the items r works on,
+// which differs from the user of r for shared lists.
+func storeOwner(r *http.Request) string {
+	if l, ok := requestList(r); ok {
+		return l.owner
+	}
+	return userName(r)
+}
+
+// homeURL returns the path of the index page of the items r works on.
+func homeURL(r *http.Request) string {
+	if l, ok := requestList(r); ok {
+		return "/?" + url.Values{"list": {listName(l.owner, l.tag)}}.Encode()
+	}
+	return "/"
+}
+
+// manageInvites lists, creates, accepts and revokes invitations to
+// shared lists. POST with the "tag" query parameter invites the user
+// named by "user" right away, or mails a link to "email"; "write"
+// grants write access. POST on invites/TOKEN accepts a mailed
+// invitation, DELETE revokes it or leaves the list.
+func manageInvites(w http.ResponseWriter, r *http.Request) {
+	me := userName(r)
+	token := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, todow.APIPath+"invites"), "/")
+	if r.Method == "POST" && token == "" {
+		createInvite(w, r)
+		return
+	}
+
+	invites.Lock()
+	defer invites.Unlock()
+
+	switch {
+	case r.Method == "GET" && token == "":
+		own := []invite{}
+		for _, inv := range invites.all {
+			if inv.Owner == me || inv.Accepted && inv.Invitee == me {
+				inv.Owner = loginName(inv.Owner)
+				if inv.Accepted {
+					inv.Invitee = loginName(inv.Invitee)
+				}
+				own = append(own, inv)
+			}
+		}
+		writeJSON(w, r, own)
+	case r.Method == "POST":
+		for i, inv := range invites.all {
+			if inv.Token != token || inv.Accepted {
+				continue
+			}
+			if inv.Owner == me {
+				httpError(w, r, "you can't accept your own invitation", http.StatusBadRequest)
+				return
+			}
+
+			invites.all[i].Invitee, invites.all[i].Accepted = me, true
+			if err := saveInvites(); err != nil {
+				invites.all[i] = inv
+				httpError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			name := listName(inv.Owner, inv.Tag)
+			audit(r, "", auditAcceptInvite, name)
+			if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+				http.Redirect(w, r, "/?"+url.Values{"list": {name}}.Encode(), 303)
+				return
+			}
+			fmt.Fprintf(w, "Joined list %s\n", name)
+			return
+		}
+		http.NotFound(w, r)
+	case r.Method == "DELETE":
+		for i, inv := range invites.all {
+			if inv.Token != token || !(inv.Owner == me || inv.Accepted && inv.Invitee == me) {
+				continue
+			}
+
+			old := invites.all
+			invites.all = append(append([]invite{}, old[:i]...), old[i+1:]...)
+			if err := saveInvites(); err != nil {
+				invites.all = old
+				httpError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			audit(r, "", auditUninvite, listName(inv.Owner, inv.Tag))
+			fmt.Fprintf(w, "Revoked invitation %s\n", token)
+			return
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// createInvite adds an invitation, and mails its link if it is one by
+// mail.
+func createInvite(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tag := strings.TrimPrefix(q.Get("tag"), todow.TagPrefix)
+	if tag == "" {
+		httpError(w, r, "missing tag of the list to share", http.StatusBadRequest)
+		return
+	}
+	write, _ := strconv.ParseBool(q.Get("write"))
+
+	token, err := newShareToken()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	inv := invite{
+		Token:   token,
+		Owner:   userName(r),
+		Tag:     tag,
+		Write:   write,
+		Created: time.Now(),
+	}
+
+	switch {
+	case q.Get("user") != "":
+		accountsMu.RLock()
+		_, ok := accounts[q.Get("user")]
+		accountsMu.RUnlock()
+		if !ok {
+			httpError(w, r, fmt.Sprintf("unknown user %q", q.Get("user")), http.StatusBadRequest)
+			return
+		}
+		inv.Invitee, inv.Accepted = internalName(q.Get("user")), true
+		if inv.Invitee == inv.Owner {
+			httpError(w, r, "you can't invite yourself", http.StatusBadRequest)
+			return
+		}
+	case q.Get("email") != "" && *externalURL == "":
+		httpError(w, r, "invitations by mail need the server's -url", http.StatusNotImplemented)
+		return
+	case q.Get("email") != "":
+		inv.Email = q.Get("email")
+	default:
+		httpError(w, r, "missing user or email to invite", http.StatusBadRequest)
+		return
+	}
+
+	// Inviting a member again changes their access.
+	invites.Lock()
+	old := invites.all
+	invites.all = []invite{}
+	for _, v := range old {
+		if !(inv.Accepted && v.Accepted && v.Owner == inv.Owner && v.Tag == inv.Tag && v.Invitee == inv.Invitee) {
+			invites.all = append(invites.all, v)
+		}
+	}
+	invites.all = append(invites.all, inv)
+	err = saveInvites()
+	if err != nil {
+		invites.all = old
+	}
+	invites.Unlock()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := listName(inv.Owner, inv.Tag)
+	if inv.Email != "" {
+		body := fmt.Sprintf("%s invited you to the todo list %s.\r\n\r\nTo join it, open %s\r\n", loginName(inv.Owner), name, inviteURL(token))
+		if err := newMailNotifier(inv.Email).send("Invitation to "+name, body); err != nil {
+			removeInvite(token)
+			httpError(w, r, fmt.Sprintf("unable to mail invitation: %s", err), http.StatusBadGateway)
+			return
+		}
+	}
+
+	w.WriteHeader(201)
+	if inv.Email != "" {
+		audit(r, "", auditInvite, fmt.Sprintf("%s for %s", name, inv.Email))
+		fmt.Fprintf(w, "Mailed invitation to %s to %s\n", name, inv.Email)
+		return
+	}
+	audit(r, "", auditInvite, fmt.Sprintf("%s for %s", name, loginName(inv.Invitee)))
+	fmt.Fprintf(w, "Shared list %s with %s\n", name, loginName(inv.Invitee))
+}
+
+// removeInvite removes the invitation with the given token, which
+// couldn't be mailed.
+func removeInvite(token string) {
+	invites.Lock()
+	defer invites.Unlock()
+	old := invites.all
+	invites.all = []invite{}
+	for _, v := range old {
+		if v.Token != token {
+			invites.all = append(invites.all, v)
+		}
+	}
+	if err := saveInvites(); err != nil {
+		slog.Error("unable to remove invitation", "err", err)
+	}
+}
+
+// inviteURL returns the link of the invitation with the given token
+// below -url.
+func inviteURL(token string) string {
+	return strings.TrimSuffix(*externalURL, "/") + "/invite/" + token
+}
+
+// parseExternalURL checks -url, if it's set.
+func parseExternalURL() error {
+	if *externalURL == "" {
+		return nil
+	}
+	u, err := url.Parse(*externalURL)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q is not an absolute http or https URL", *externalURL)
+	}
+	return nil
+}
+
+// viewInvite shows a mailed invitation with a button to accept it.
+func viewInvite(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/invite/")
+
+	invites.Lock()
+	var inv invite
+	var ok bool
+	for _, v := range invites.all {
+		if v.Token == token && !v.Accepted {
+			inv, ok = v, true
+			break
+		}
+	}
+	invites.Unlock()
+
+	if token == "" || !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := inviteTmpl.Execute(w, struct {
+		Owner, List, Action, CSRF string
+		Write                     bool
+		T                         messages
+	}{
+		loginName(inv.Owner),
+		listName(inv.Owner, inv.Tag),
+		todow.APIPath + "invites/" + token,
+		csrfToken(r),
+		inv.Write,
+		requestMessages(w, r),
+	}); err != nil {
+		requestLog(r).Error("unable to render page", "err", err)
+	}
+}
+
+// listStore returns the store of the shared list l.
+func listStore(l listAccess) store.Store {
+	return store.List(stores[l.owner], l.tag, l.write)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ipRuleFlags stringList
+
+func init() {
+	flags.Var(&ipRuleFlags, "ip-rule", `Rule "allow|deny CIDR [PATHS]" evaluated before authentication, may be given multiple times. `+
+		`PATHS is a comma separated list of path prefixes or "admin" for the admin endpoints, all paths if omitted. `+
+		`The first matching rule decides, requests matching none are allowed`)
+}
+
+// An ipRule allows or denies requests from a network to some paths.
+type ipRule struct {
+	allow bool
+	net   *net.IPNet
+	paths []string
+}
+
+// ipRules are the rules parsed from -ip-rule, in order.
+var ipRules []ipRule
+
+// adminPaths are the patterns of admin endpoints, the paths of
+// rules naming "admin".
+var adminPaths = map[string]bool{}
+
+// parseIPRules sets ipRules from -ip-rule.
+func parseIPRules() error {
+	for _, s := range ipRuleFlags {
+		fields := strings.Fields(s)
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("rule %q is not of the form \"allow|deny CIDR [PATHS]\"", s)
+		}
+
+		var rule ipRule
+		switch fields[0] {
+		case "allow":
+			rule.allow = true
+		case "deny":
+		default:
+			return fmt.Errorf("rule %q neither allows nor denies", s)
+		}
+
+		cidr := fields[1]
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("rule %q: %s", s, err)
+		}
+		rule.net = n
+
+		if len(fields) == 3 {
+			rule.paths = strings.Split(fields[2], ",")
+		}
+		ipRules = append(ipRules, rule)
+	}
+	return nil
+}
+
+func (rule ipRule) matches(ip net.IP, path string) bool {
+	if !rule.net.Contains(ip) {
+		return false
+	}
+	if rule.paths == nil {
+		return true
+	}
+	for _, p := range rule.paths {
+		if p == "admin" && adminPaths[path] || p != "admin" && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter answers requests denied by ipRules with 403 before they
+// reach h. Without rules, h is returned unchanged.
+func ipFilter(h http.Handler) http.Handler {
+	if len(ipRules) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(remoteHost(r))
+		if ip == nil {
+			// Without the address of the client, no rule can be told
+			// to apply or not.
+			httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		for _, rule := range ipRules {
+			if !rule.matches(ip, r.URL.Path) {
+				continue
+			}
+			if !rule.allow {
+				httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			break
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+var (
+	readHeaderTimeout = flags.Duration("read-header-timeout", 10*time.Second, "Longest time a client may take to send the headers of a request")
+	readTimeout       = flags.Duration("read-timeout", time.Minute, "Longest time a client may take to send a request including its body, 0 for no limit")
+	writeTimeout      = flags.Duration("write-timeout", time.Minute, "Longest time a response may take once the request headers have been read, 0 for no limit. "+
+		"Event streams and backups are exempt")
+	idleTimeout    = flags.Duration("idle-timeout", 2*time.Minute, "Longest time an idle keep-alive connection is kept open")
+	maxHeaderBytes = flags.Int("max-header-bytes", 64<<10, "Largest size of the headers of a request")
+	maxBodyBytes   = flags.Int64("max-body-bytes", 10<<20, "Largest size of the body of a request, e.g. of an import")
+)
+
+// limitBody fails reads of request bodies larger than -max-body-bytes,
+// which closes the connection once the handler responds.
+func limitBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, *maxBodyBytes)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// noWriteTimeout lifts -write-timeout for the response w, which is
+// streamed for as long as it takes.
+func noWriteTimeout(w http.ResponseWriter, r *http.Request) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		requestLog(r).Warn("unable to lift write timeout", "err", err)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	logLevel  = flags.String("log-level", "info", "Least severe level of the messages logged, debug, info, warn or error")
+	logFormat = flags.String("log-format", "text", "Format of the messages logged, text or json")
+
+	proxyIPHeader = flags.String("proxy-ip-header", "", "Header a reverse proxy connecting over a Unix socket passes the address of the client in, "+
+		"e.g. X-Real-IP or X-Forwarded-For. Only trusted for requests over Unix sockets")
+)
+
+// setupLogging makes the default logger log as -log-level and
+// -log-format tell, to stderr. Messages of the log package, e.g. those
+// of the store, are logged at info level.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -log-level %q, use debug, info, warn or error", *logLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("invalid -log-format %q, use text or json", *logFormat)
+	}
+	return nil
+}
+
+// fatal logs msg with the key-value pairs args as error and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	userKey
+	listKey
+	storeCtxKey
+	loginKey
+)
+
+// logRequests tags every request with a generated ID, sent back in the
+// X-Request-ID header, and logs it once it has been handled, also to
+// the access log if there is one.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID()
+		w.Header().Set("X-Request-ID", id)
+		login := new(string)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		r = r.WithContext(context.WithValue(ctx, loginKey, login))
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		if accessLog != nil {
+			logAccess(r, *login, sw, start)
+		}
+		slog.Info("request", "request", id, "method", r.Method, "path", r.URL.Path, "status", sw.status, "duration", time.Since(start))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of r, or "-" if it has none.
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey).(string); ok {
+		return id
+	}
+	return "-"
+}
+
+// remoteHost returns the IP address of the client of r. Requests over
+// Unix sockets come from a reverse proxy, which passes it in the
+// -proxy-ip-header header; "" is returned if it doesn't.
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && net.ParseIP(host) != nil {
+		return host
+	}
+	if *proxyIPHeader == "" {
+		return ""
+	}
+
+	// Proxies append the address they received the request from.
+	v := r.Header.Values(*proxyIPHeader)
+	if len(v) == 0 {
+		return ""
+	}
+	addrs := strings.Split(v[len(v)-1], ",")
+	host := strings.TrimSpace(addrs[len(addrs)-1])
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+// setLogin records the name of the user who sent r for the access log.
+func setLogin(r *http.Request, name string) {
+	if login, ok := r.Context().Value(loginKey).(*string); ok {
+		*login = loginName(name)
+	}
+}
+
+// requestLog returns the logger for messages about r, which adds its ID.
+func requestLog(r *http.Request) *slog.Logger {
+	return slog.With("request", requestID(r))
+}
+
+// httpError replies with an error message including the request ID,
+// so clients can report it. Server errors are also logged.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if code >= 500 {
+		requestLog(r).Error(msg, "status", code)
+	}
+	http.Error(w, fmt.Sprintf("%s (request %s)", msg, requestID(r)), code)
+}
@@ -0,0 +1,770 @@
+// Package server implements the todow web server, started by todow serve.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/quickadd"
+	"github.com/j1436go/todow/store"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type reqType int
+
+const (
+	reqTypeCLI = iota
+	reqTypeForm
+)
+
+// flags are the command line flags of the server, separate from
+// those of the client.
+var flags = flag.NewFlagSet("todow serve", flag.ExitOnError)
+
+var (
+	listenAddr = flags.String("a", ":9999", "Listen address, or unix:PATH for a Unix socket. Ignored if started by systemd socket activation")
+	user       = flags.String("u", todow.DefaultUser, "Name of the default user, who owns the items created before there were users")
+	pass       = flags.String("p", "", "Password of the default user, overriding the users file")
+
+	storeKind   = flags.String("store", "bolt", "Storage backend, bolt, postgres or todotxt")
+	storeSource = flags.String("db", "todos.db", "Bolt database file, PostgreSQL connection string or todo.txt file")
+	maxOpenConn = flags.Int("db-max-open", 10, "Maximum number of open PostgreSQL connections")
+	maxIdleConn = flags.Int("db-max-idle", 2, "Maximum number of idle PostgreSQL connections")
+
+	storeTimeout = flags.Duration("store-timeout", 30*time.Second, "Longest time the storage operations of a request may take, 0 for no limit. "+
+		"They are also given up once the client goes away")
+
+	backupFile  = flags.String("backup", "", "Write a backup of the store to the given file and exit")
+	restoreFile = flags.String("restore", "", "Replace the store with the given backup file and exit")
+	compactOnly = flags.Bool("compact", false, "Compact the store and exit")
+
+	db store.Store
+
+	idRegexp = regexp.MustCompile(todow.APIPath + "([0-9]+)")
+)
+
+// Main runs the server with the command line arguments args,
+// those following the serve command.
+func Main(args []string) {
+	flags.Parse(args)
+	if err := configure(); err != nil {
+		log.Fatalf("unable to configure: %s", err)
+	}
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+
+	if flags.Arg(0) == "adduser" {
+		if flags.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "usage: todow serve [-users FILE] adduser NAME")
+			os.Exit(2)
+		}
+		if err := addUser(flags.Arg(1)); err != nil {
+			fatal("unable to add user", "err", err)
+		}
+		return
+	}
+
+	if *restoreFile != "" {
+		if err := restore(*restoreFile); err != nil {
+			fatal("unable to restore store", "file", *restoreFile, "err", err)
+		}
+		slog.Info("restored store", "store", *storeSource, "file", *restoreFile)
+		return
+	}
+
+	var err error
+	db, err = store.Open(*storeKind, *storeSource)
+	if err != nil {
+		fatal("unable to open store", "kind", *storeKind, "err", err)
+	}
+	defer db.Close()
+
+	if *backupFile != "" {
+		if err := backupTo(*backupFile); err != nil {
+			fatal("unable to write backup", "err", err)
+		}
+		slog.Info("wrote backup", "file", *backupFile)
+		return
+	}
+
+	if *compactOnly {
+		c, ok := db.(store.Compacter)
+		if !ok {
+			fatal("compacting the store is not supported", "kind", *storeKind)
+		}
+		if _, _, err := c.Compact(); err != nil {
+			fatal("unable to compact store", "err", err)
+		}
+		return
+	}
+
+	accounts, err = loadAccounts(*usersFile)
+	if os.IsNotExist(err) && *pass != "" {
+		accounts, err = map[string][]byte{}, nil
+	}
+	if os.IsNotExist(err) {
+		accounts, err = firstRunAccounts()
+	}
+	if err != nil {
+		fatal("unable to load users", "err", err)
+	}
+	if *pass != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*pass), bcrypt.DefaultCost)
+		if err != nil {
+			fatal("unable to hash password", "err", err)
+		}
+		accounts[*user] = hash
+	}
+	if _, ok := accounts[*user]; !ok {
+		slog.Warn("default user has no account, add it with todow serve adduser", "user", *user)
+	}
+	if err := openUserStores(); err != nil {
+		fatal("unable to open user stores", "err", err)
+	}
+	if err := guard.parseExempt(*loginExempt); err != nil {
+		fatal("invalid -login-exempt", "err", err)
+	}
+	if err := parseIPRules(); err != nil {
+		fatal("invalid -ip-rule", "err", err)
+	}
+	if err := loadShares(); err != nil {
+		fatal("unable to load shares", "err", err)
+	}
+	if err := parseExternalURL(); err != nil {
+		fatal("invalid -url", "err", err)
+	}
+	if err := loadInvites(); err != nil {
+		fatal("unable to load invitations", "err", err)
+	}
+	if err := loadSettings(); err != nil {
+		fatal("unable to load settings", "err", err)
+	}
+	if err := loadTemplates(); err != nil {
+		fatal("unable to load templates", "err", err)
+	}
+	if err := loadMessages(); err != nil {
+		fatal("unable to load messages", "err", err)
+	}
+
+	if pg, ok := db.(*store.Postgres); ok {
+		pg.SetMaxOpenConns(*maxOpenConn)
+		pg.SetMaxIdleConns(*maxIdleConn)
+	}
+
+	if b, ok := db.(*store.Bolt); ok {
+		b.ObserveTx = observeBoltTx
+	}
+
+	handle(todow.APIPath, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if strings.HasSuffix(r.URL.Path, "/history") {
+				authMiddleware(withID(itemHistory))(w, r)
+				return
+			}
+			if idRegexp.MatchString(r.URL.Path) {
+				authMiddleware(withID(getItem))(w, r)
+				return
+			}
+			authMiddleware(allItems)(w, r)
+		case "POST":
+			if strings.HasSuffix(r.URL.Path, "/snooze") {
+				authMiddleware(withID(snoozeItem))(w, r)
+				return
+			}
+			authMiddleware(addItem)(w, r)
+		case "DELETE":
+			authMiddleware(withID(removeItem))(w, r)
+		case "PATCH":
+			authMiddleware(withID(patchItem))(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	handle(todow.APIPath+"bulk", authMiddleware(bulk))
+	handle(todow.APIPath+"trash", authMiddleware(trashItems))
+	handle(todow.APIPath+"trash/", authMiddleware(trashItems))
+	handleAdmin(todow.APIPath+"backup", backup)
+	handle(todow.APIPath+"export", authMiddleware(export))
+	handle(todow.APIPath+"import", authMiddleware(importItems))
+	handleAdmin(todow.APIPath+"compact", compact)
+	handleAdmin(todow.APIPath+"audit", auditLog)
+	handle(todow.APIPath+"search", authMiddleware(search))
+	handle(todow.APIPath+"stats", authMiddleware(itemStats))
+	handle(todow.APIPath+"events", authMiddleware(streamEvents))
+	handle(todow.APIPath+"hooks/", hook)
+	handle(todow.APIPath+"triggers/", authMiddleware(trigger))
+	handle(todow.APIPath+"actions/", authMiddleware(action))
+	handle(todow.APIPath+"shares", authMiddleware(manageShares))
+	handle(todow.APIPath+"shares/", authMiddleware(manageShares))
+	handle("/share/", viewShare)
+	handle(todow.APIPath+"invites", authMiddleware(manageInvites))
+	handle(todow.APIPath+"invites/", authMiddleware(manageInvites))
+	handle("/invite/", authMiddleware(viewInvite))
+	handle("/feed.atom", authMiddleware(feed))
+	handle("/stats", authMiddleware(statsPage))
+	handle(todow.APIPath+"settings", authMiddleware(manageSettings))
+	handle(todow.APIPath+"settings/", authMiddleware(manageSettings))
+	handle("/settings", authMiddleware(settingsPage))
+	handle("/settings/google", authMiddleware(connectGoogle))
+	handle("/settings/google/callback", authMiddleware(connectGoogle))
+	handle("/static/", static())
+	handle("/sw.js", serviceWorker)
+	handleAdmin("/metrics", promhttp.Handler().ServeHTTP)
+
+	handle("/", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		// The index shows open items unless done is given,
+		// all items with done=all.
+		v := r.URL.Query()
+		if v.Get("done") == "" {
+			v.Set("done", "false")
+		}
+		done := v.Get("done")
+		v.Del("done")
+
+		// The tabs select by completion state, whatever the filter does.
+		fv, err := filterValues(r, v)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fv.Del("done")
+		q, err := store.ParseQuery(fv)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var tag string
+		if len(q.Tags) > 0 {
+			tag = q.Tags[0]
+		}
+
+		var list string
+		if l, ok := requestList(r); ok {
+			list = listName(l.owner, l.tag)
+		}
+
+		col, _, err := userStore(r).Find(q)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		t := requestMessages(w, r)
+		tabs, col, err := indexTabs(v, done, col, t)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		blocked, err := blockedItems(userStore(r), col)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.Execute(w, struct {
+			Items    []todow.NestedItem
+			Tabs     []tab
+			Done     string
+			APIPath  string
+			Tag      string
+			List     string
+			Archived bool
+			Deferred bool
+			Filters  []savedFilter
+			Filter   string
+			Blocked  map[int64]string
+			CSRF     string
+			T        messages
+		}{
+			todow.Nest(col),
+			tabs,
+			done,
+			todow.APIPath,
+			tag,
+			list,
+			q.Archived,
+			q.Deferred,
+			settingsOf(userName(r)).Filters,
+			v.Get("filter"),
+			blocked,
+			csrfToken(r),
+			t,
+		}); err != nil {
+			requestLog(r).Error("unable to render page", "err", err)
+		}
+	}))
+
+	go remind(notifiers())
+	// Users may add webhooks at any time.
+	go dispatchWebhooks(events.subscribe(256))
+	go mailDigests()
+	if *mqttBroker != "" {
+		go publishMQTT(events.subscribe(256))
+	}
+	if *snapshotDir != "" {
+		go snapshots()
+	}
+	if *trashRetention > 0 {
+		go purgeTrash()
+	}
+	if *archiveAfter > 0 {
+		go archiveCompleted()
+	}
+	if *captureAddr != "" {
+		go captureMail()
+	}
+	if *googleClientID != "" {
+		go syncGoogle()
+	}
+	if *githubToken != "" && len(githubRepos) > 0 {
+		if _, ok := stores[*githubUser]; !ok {
+			fatal("invalid -github-user: no such user", "user", *githubUser)
+		}
+		go syncGitHub()
+	}
+	if *matrixToken != "" && *matrixRoom != "" {
+		if _, ok := stores[*matrixUser]; !ok {
+			fatal("invalid -matrix-user: no such user", "user", *matrixUser)
+		}
+		go serveMatrix()
+	}
+
+	if *accessLogPath != "" {
+		accessLog, err = openRotatingFile(*accessLogPath, *accessLogMaxSize, *accessLogMaxAge, *accessLogKeep)
+		if err != nil {
+			fatal("unable to open access log", "err", err)
+		}
+	}
+
+	fatal("unable to serve", "err", serve(logRequests(ipFilter(limitBody(cors(http.DefaultServeMux))))))
+}
+
+// A tab of the index page selects items by completion state.
+type tab struct {
+	Name   string
+	URL    string
+	Count  int
+	Active bool
+}
+
+// indexTabs returns the tabs of the index page, whose other query
+// parameters are v, and those items of col the selected tab shows.
+// done is the value of the done parameter, "true", "false" or "all".
+// The tabs are named in the language of t.
+func indexTabs(v url.Values, done string, col []*todow.Item, t messages) ([]tab, []*todow.Item, error) {
+	tabs := []tab{{Name: t["active"]}, {Name: t["completed"]}, {Name: t["all"]}}
+	params := []string{"false", "true", "all"}
+
+	var show []*todow.Item
+	selected := false
+	for i, p := range params {
+		u := url.Values{}
+		for k, vs := range v {
+			u[k] = vs
+		}
+		u.Set("done", p)
+		tabs[i].URL = "/?" + u.Encode()
+		tabs[i].Active = p == done
+		selected = selected || tabs[i].Active
+
+		for _, item := range col {
+			if p == "all" || item.Done == (p == "true") {
+				tabs[i].Count++
+				if tabs[i].Active {
+					show = append(show, item)
+				}
+			}
+		}
+	}
+	if !selected {
+		return nil, nil, fmt.Errorf("invalid done %q, use true, false or all", done)
+	}
+	return tabs, show, nil
+}
+
+// handle registers h for pattern, instrumented for metrics and with
+// the storage operations of requests limited by -store-timeout.
+func handle(pattern string, h http.HandlerFunc) {
+	http.HandleFunc(pattern, instrument(pattern, withStoreTimeout(h)))
+}
+
+// handleAdmin registers h for pattern, restricted to the default user.
+func handleAdmin(pattern string, h http.HandlerFunc) {
+	adminPaths[pattern] = true
+	handle(pattern, authMiddleware(adminOnly(h)))
+}
+
+func withID(h func(w http.ResponseWriter, r *http.Request, id int64)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := idRegexp.FindStringSubmatch(r.URL.Path)
+		if len(m) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		id, _ := strconv.ParseInt(m[1], 10, 64)
+		h(w, r, id)
+	}
+}
+
+func addItem(w http.ResponseWriter, r *http.Request) {
+	var item todow.Item
+
+	var typ reqType
+
+	if r.Header.Get("Content-Type") == "application/json" {
+		typ = reqTypeCLI
+		err := json.NewDecoder(r.Body).Decode(&item)
+		if err != nil {
+			httpError(w, r, fmt.Sprintf("unable to decode todo item: %s", err), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if err := todow.CheckMeta(item.Meta); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i, c := range item.Checklist {
+			if strings.TrimSpace(c.Text) == "" || strings.ContainsAny(c.Text, "\r\n") {
+				httpError(w, r, fmt.Sprintf("checklist entry %d must be a single non-empty line", i+1), http.StatusBadRequest)
+				return
+			}
+		}
+		if !item.Archived.IsZero() || !item.Deleted.IsZero() {
+			httpError(w, r, "new items can't be archived or removed", http.StatusBadRequest)
+			return
+		}
+	} else if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		typ = reqTypeForm
+		r.ParseForm()
+		item = quickadd.Parse(r.FormValue("body"), time.Now())
+		item.Created = time.Now()
+		if due := r.FormValue("due"); due != "" {
+			t, err := time.ParseInLocation(todow.DueFormat, due, time.Local)
+			if err != nil {
+				// Date pickers send the date and time separated by a T.
+				t, err = time.ParseInLocation(dueInputFormat, due, time.Local)
+			}
+			if err != nil {
+				httpError(w, r, fmt.Sprintf("unable to parse due date %q", due), http.StatusBadRequest)
+				return
+			}
+			item.Due = t
+		}
+		if parent := r.FormValue("parent"); parent != "" {
+			id, err := strconv.ParseInt(parent, 10, 64)
+			if err != nil {
+				httpError(w, r, fmt.Sprintf("invalid parent id: %s", err), http.StatusBadRequest)
+				return
+			}
+			item.ParentID = id
+		}
+	} else {
+		httpError(w, r, "content type not supported", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(item.Body) == "" {
+		httpError(w, r, "item body must not be empty", http.StatusBadRequest)
+		return
+	}
+	if !todow.ValidPriority(item.Priority) {
+		httpError(w, r, fmt.Sprintf("invalid priority %q, use A to Z", item.Priority), http.StatusBadRequest)
+		return
+	}
+
+	if id, ok := addedBefore(r); ok {
+		// The add is replayed, the item was added then.
+		respondAdded(w, r, typ, id)
+		return
+	}
+	if !checkDuplicate(w, r, item.Body) {
+		return
+	}
+
+	setDefaultReminder(userName(r), &item)
+	switch err := userStore(r).Add(&item).(type) {
+	case store.ErrNotFound:
+		httpError(w, r, fmt.Sprintf("parent item #%d not found", item.ParentID), http.StatusBadRequest)
+		return
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rememberAdd(r, item.ID)
+	recordCreated(r, item.ID)
+	publishItem(r, eventAdded, item.ID)
+
+	respondAdded(w, r, typ, item.ID)
+}
+
+// respondAdded tells the client of r that the item id was added.
+func respondAdded(w http.ResponseWriter, r *http.Request, typ reqType, id int64) {
+	switch typ {
+	case reqTypeCLI:
+		w.WriteHeader(201)
+		fmt.Fprintf(w, "Added item #%d\n", id)
+	case reqTypeForm:
+		http.Redirect(w, r, homeURL(r), 303)
+	default:
+		http.Redirect(w, r, homeURL(r), 303)
+	}
+}
+
+func removeItem(w http.ResponseWriter, r *http.Request, id int64) {
+	db := userStore(r)
+	item, err := db.Get(id)
+	if err != nil {
+		if _, ok := err.(store.ErrNotFound); ok {
+			http.NotFound(w, r)
+		} else {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	switch err := db.Remove(id).(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	case nil:
+		audit(r, "", auditRemove, fmt.Sprintf("item #%d: %s", id, item.Body))
+		events.publish(event{Type: eventRemoved, ID: id, user: storeOwner(r)})
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "Removed item #%d\n", id)
+	}
+}
+
+func patchItem(w http.ResponseWriter, r *http.Request, id int64) {
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, r, fmt.Sprintf("unable to read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// An empty PATCH marks the item complete.
+	if len(bytes.TrimSpace(p)) == 0 {
+		completeItem(w, r, id)
+		return
+	}
+
+	var patch todow.ItemPatch
+	if err := json.Unmarshal(p, &patch); err != nil {
+		httpError(w, r, fmt.Sprintf("unable to decode patch: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if patch.Body != nil && strings.TrimSpace(*patch.Body) == "" {
+		httpError(w, r, "item body must not be empty", http.StatusBadRequest)
+		return
+	}
+	if patch.Priority != nil && !todow.ValidPriority(*patch.Priority) {
+		httpError(w, r, fmt.Sprintf("invalid priority %q, use A to Z", *patch.Priority), http.StatusBadRequest)
+		return
+	}
+	if patch.Checklist != nil {
+		for i, c := range *patch.Checklist {
+			if strings.TrimSpace(c.Text) == "" || strings.ContainsAny(c.Text, "\r\n") {
+				httpError(w, r, fmt.Sprintf("checklist entry %d must be a single non-empty line", i+1), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if err := todow.CheckMeta(patch.Meta); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if patch.BlockedBy != nil && !checkBlockers(w, r, id, *patch.BlockedBy) {
+		return
+	}
+	if patch.Done != nil && *patch.Done && !checkBlocked(w, r, []int64{id}) {
+		return
+	}
+
+	// Cascading only ever propagates the completion state.
+	var cascade func(*todow.Item)
+	if patch.Done != nil && r.URL.Query().Get("cascade") == "true" {
+		done := *patch.Done
+		cascade = func(item *todow.Item) { item.SetDone(done) }
+	}
+
+	changes := map[int64][]store.Change{}
+	switch err := userStore(r).Update(id, recording(patch.Apply, changes), recording(cascade, changes)).(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	case nil:
+		recordChanges(r, changes)
+		if patch.Done != nil && *patch.Done {
+			publishItem(r, eventCompleted, id)
+		} else {
+			publishItem(r, eventUpdated, id)
+		}
+		w.WriteHeader(200)
+		if patch.Done != nil && !*patch.Done {
+			fmt.Fprintf(w, "Reopened item #%d\n", id)
+			return
+		}
+		fmt.Fprintf(w, "Updated item #%d\n", id)
+	}
+}
+
+func completeItem(w http.ResponseWriter, r *http.Request, id int64) {
+	if !checkBlocked(w, r, []int64{id}) {
+		return
+	}
+
+	complete := func(item *todow.Item) { item.SetDone(true) }
+
+	var cascade func(*todow.Item)
+	if r.URL.Query().Get("cascade") == "true" {
+		cascade = complete
+	}
+
+	changes := map[int64][]store.Change{}
+	switch err := userStore(r).Update(id, recording(complete, changes), recording(cascade, changes)).(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	case nil:
+		recordChanges(r, changes)
+		publishItem(r, eventCompleted, id)
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "Completed item #%d\n", id)
+	}
+}
+
+func getItem(w http.ResponseWriter, r *http.Request, id int64) {
+	item, err := userStore(r).Get(id)
+	switch err.(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+		return
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, item)
+}
+
+// allItems lists the items matching the query parameters. Depending on
+// the Accept header, they are written as JSON, which is the default,
+// as CSV (text/csv) or as tab-separated text (text/plain).
+func allItems(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	format := negotiate(r.Header.Get("Accept"), "application/json", "text/csv", "text/plain")
+	if format == "" {
+		httpError(w, r, "acceptable formats are application/json, text/csv and text/plain", http.StatusNotAcceptable)
+		return
+	}
+
+	v, err := filterValues(r, r.URL.Query())
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q, err := store.ParseQuery(v)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	col, total, err := userStore(r).Find(q)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	var buf bytes.Buffer
+	switch format {
+	case "application/json":
+		writeItems(w, r, col)
+		return
+	case "text/csv":
+		err = todow.WriteCSV(&buf, col)
+	case "text/plain":
+		err = todow.WriteTSV(&buf, col)
+	}
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeTagged(w, r, format+"; charset=utf-8", buf.Bytes())
+}
+
+func authMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, p, hasAuth := r.BasicAuth()
+		if t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); !hasAuth && strings.HasPrefix(t, tokenPrefix) {
+			u, _ = tokenUser(t)
+			p, hasAuth = t, true
+		}
+
+		keys := guard.keys(r, u)
+		if d := guard.lockedFor(keys); d > 0 {
+			if hasAuth {
+				audit(r, u, auditLockedOut, "")
+			}
+			tooManyLogins(w, r, d)
+			return
+		}
+
+		name, ok := authenticate(u, p)
+		if !ok {
+			// Browsers ask without credentials first.
+			if hasAuth {
+				guard.fail(keys)
+				audit(r, u, auditLoginFailed, "")
+			}
+			w.Header().Set("WWW-Authenticate", "Basic")
+			httpError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		guard.succeed(keys)
+
+		r = withUser(r, name)
+		setLogin(r, name)
+		auditLoginOf(r)
+		if !validCSRF(r) {
+			httpError(w, r, "invalid CSRF token, reload the page", http.StatusForbidden)
+			return
+		}
+
+		r, err := withList(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		if l, ok := requestList(r); ok && !l.write && r.Method != "GET" && r.Method != "HEAD" {
+			httpError(w, r, "list is read-only", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/quickadd"
+	"github.com/j1436go/todow/store"
+)
+
+var (
+	matrixHomeserver = flags.String("matrix-homeserver", "https://matrix.org", "URL of the Matrix homeserver of -matrix-token")
+	matrixToken      = flags.String("matrix-token", "", "Access token of the Matrix account that joins -matrix-room as todo bot")
+	matrixRoom       = flags.String("matrix-room", "", "Matrix room ID or alias, like #todo:matrix.org, where messages starting with !todo add items, "+
+		"reactions to them complete the items and reminders are posted. Anyone in the room may add and complete items")
+	matrixUser = flags.String("matrix-user", "", "User whose items the Matrix room works on, the default one if empty")
+)
+
+// matrixMetaKey is the metadata field linking items to the ID of the
+// Matrix message they were added with.
+const matrixMetaKey = "matrix"
+
+// matrixPrefix starts the messages adding items.
+const matrixPrefix = "!todo"
+
+// A matrixEvent is a room event as the Matrix client-server API
+// returns it, with the fields of messages and reactions.
+type matrixEvent struct {
+	Type    string
+	EventID string `json:"event_id"`
+	Sender  string
+	Content struct {
+		Body      string
+		RelatesTo struct {
+			RelType   string `json:"rel_type"`
+			EventID   string `json:"event_id"`
+			InReplyTo struct {
+				EventID string `json:"event_id"`
+			} `json:"m.in_reply_to"`
+		} `json:"m.relates_to"`
+	}
+}
+
+// A matrixBot adds and completes the items of -matrix-user as told in
+// a room.
+type matrixBot struct {
+	// user is the Matrix ID of the bot, room the ID of the room.
+	user, room string
+}
+
+// serveMatrix joins -matrix-room, retrying until it succeeds, and then
+// works on the messages and reactions posted to it and posts the
+// reminders of the items of -matrix-user. It never returns.
+func serveMatrix() {
+	var me struct {
+		UserID string `json:"user_id"`
+	}
+	var room struct {
+		RoomID string `json:"room_id"`
+	}
+	for {
+		err := matrixDo("GET", "/account/whoami", nil, &me)
+		if err == nil {
+			err = matrixDo("POST", "/join/"+url.PathEscape(*matrixRoom), struct{}{}, &room)
+		}
+		if err == nil {
+			break
+		}
+		slog.Error("unable to join Matrix room, retrying in a minute", "room", *matrixRoom, "err", err)
+		time.Sleep(time.Minute)
+	}
+
+	b := matrixBot{user: me.UserID, room: room.RoomID}
+	go b.remind(events.subscribe(64))
+	b.sync()
+}
+
+// sync handles the events of the room as they arrive. Those sent before
+// the bot started are skipped.
+func (b matrixBot) sync() {
+	filter, _ := json.Marshal(map[string]interface{}{
+		"room": map[string]interface{}{
+			"rooms":    []string{b.room},
+			"timeline": map[string]interface{}{"types": []string{"m.room.message", "m.reaction"}},
+		},
+		"presence":     map[string]interface{}{"types": []string{}},
+		"account_data": map[string]interface{}{"types": []string{}},
+	})
+
+	since := ""
+	for {
+		q := url.Values{"filter": {string(filter)}, "timeout": {"30000"}}
+		if since != "" {
+			q.Set("since", since)
+		}
+		var resp struct {
+			NextBatch string `json:"next_batch"`
+			Rooms     struct {
+				Join map[string]struct {
+					Timeline struct{ Events []matrixEvent }
+				}
+			}
+		}
+		if err := matrixDo("GET", "/sync?"+q.Encode(), nil, &resp); err != nil {
+			slog.Error("unable to sync Matrix room", "room", b.room, "err", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		if since != "" {
+			for _, e := range resp.Rooms.Join[b.room].Timeline.Events {
+				b.handle(e)
+			}
+		}
+		since = resp.NextBatch
+	}
+}
+
+// handle adds an item for messages starting with !todo, parsed by
+// quickadd, and completes the item of the message, or the bot's reply
+// to it, reacted to.
+func (b matrixBot) handle(e matrixEvent) {
+	if e.Sender == b.user {
+		return
+	}
+	switch e.Type {
+	case "m.room.message":
+		words := strings.Fields(e.Content.Body)
+		if len(words) == 0 || words[0] != matrixPrefix {
+			return
+		}
+		item := quickadd.Parse(strings.Join(words[1:], " "), time.Now())
+		if item.Body == "" {
+			b.reply(e.EventID, fmt.Sprintf("Usage: %s BODY [+TAG]... [!PRIORITY] [WHEN]", matrixPrefix))
+			return
+		}
+		if err := b.add(&item, e); err != nil {
+			slog.Error("unable to add item from Matrix message", "message", e.EventID, "err", err)
+			b.reply(e.EventID, "Unable to add the item")
+			return
+		}
+		b.reply(e.EventID, fmt.Sprintf("Added item #%d: %s, react to complete it", item.ID, item.Body))
+	case "m.reaction":
+		if e.Content.RelatesTo.RelType == "m.annotation" {
+			b.complete(e.Content.RelatesTo.EventID, e.Sender)
+		}
+	}
+}
+
+// add adds item, sent with the message e, to the items of -matrix-user.
+func (b matrixBot) add(item *todow.Item, e matrixEvent) error {
+	item.Meta = map[string]string{matrixMetaKey: e.EventID}
+	item.Created = time.Now()
+	setDefaultReminder(*matrixUser, item)
+
+	s := stores[*matrixUser]
+	if err := s.Add(item); err != nil {
+		return err
+	}
+	b.record(s, item.ID, store.Change{Kind: store.ChangeCreated, Time: item.Created, User: e.Sender})
+	events.publish(event{Type: eventAdded, ID: item.ID, Item: item, user: *matrixUser})
+	return nil
+}
+
+// complete completes the open item added with the message identified by
+// id, or with the message the bot replied to with it, for sender.
+func (b matrixBot) complete(id, sender string) {
+	s := stores[*matrixUser]
+	item, err := b.find(s, id)
+	if err == nil && item == nil {
+		var reply matrixEvent
+		err = matrixDo("GET", fmt.Sprintf("/rooms/%s/event/%s", url.PathEscape(b.room), url.PathEscape(id)), nil, &reply)
+		if err == nil && reply.Sender == b.user && reply.Content.RelatesTo.InReplyTo.EventID != "" {
+			item, err = b.find(s, reply.Content.RelatesTo.InReplyTo.EventID)
+		}
+	}
+	if err != nil {
+		slog.Error("unable to find item of Matrix message", "message", id, "err", err)
+		return
+	}
+	if item == nil {
+		return
+	}
+
+	open, err := store.OpenBlockers(s, item)
+	if err != nil {
+		slog.Error("unable to find blockers of item", "item", item.ID, "err", err)
+		return
+	}
+	if len(open) > 0 {
+		b.reply(id, fmt.Sprintf("Item #%d is blocked by open items %s, complete them first", item.ID, formatRefs(open)))
+		return
+	}
+
+	err = s.Update(item.ID, func(i *todow.Item) {
+		i.SetDone(true)
+		c := *i
+		item = &c
+	}, nil)
+	if err != nil {
+		slog.Error("unable to complete item", "item", item.ID, "err", err)
+		return
+	}
+	b.record(s, item.ID, store.Change{Kind: store.ChangeCompleted, Time: item.CompletedAt, User: sender})
+	events.publish(event{Type: eventCompleted, ID: item.ID, Item: item, user: *matrixUser})
+	b.reply(id, fmt.Sprintf("Completed item #%d: %s", item.ID, item.Body))
+}
+
+// find returns the open item of s added with the message identified by
+// id, or nil if there is none.
+func (b matrixBot) find(s store.Store, id string) (*todow.Item, error) {
+	open := false
+	col, _, err := s.Find(store.Query{Done: &open, Deferred: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range col {
+		if item.Meta[matrixMetaKey] == id {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+// record appends c to the history of the item of s identified by id,
+// if s keeps histories. Failures are logged.
+func (b matrixBot) record(s store.Store, id int64, c store.Change) {
+	h, ok := s.(store.Historian)
+	if !ok {
+		return
+	}
+	if err := h.Record(id, c); err != nil {
+		slog.Error("unable to record change in item history", "change", c.Kind, "item", id, "err", err)
+	}
+}
+
+// remind posts the reminders of the items of -matrix-user received from
+// ch to the room.
+func (b matrixBot) remind(ch chan event) {
+	for e := range ch {
+		if e.Type != eventReminder || e.user != *matrixUser {
+			continue
+		}
+		msg := fmt.Sprintf("Reminder for item #%d: %s", e.ID, e.Item.Body)
+		if !e.Item.Due.IsZero() {
+			msg += ", due " + e.Item.Due.In(time.Local).Format(todow.DueFormat)
+		}
+		b.reply(e.Item.Meta[matrixMetaKey], msg)
+	}
+}
+
+// matrixTxn numbers the messages sent, which the homeserver uses to
+// tell retries from new messages.
+var matrixTxn int64
+
+// reply posts msg to the room as notice, in reply to the message
+// identified by to unless it is empty. Failures are logged.
+func (b matrixBot) reply(to, msg string) {
+	content := map[string]interface{}{"msgtype": "m.notice", "body": msg}
+	if to != "" {
+		content["m.relates_to"] = map[string]interface{}{"m.in_reply_to": map[string]string{"event_id": to}}
+	}
+	txn := fmt.Sprintf("todow-%d-%d", time.Now().Unix(), atomic.AddInt64(&matrixTxn, 1))
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(b.room), txn)
+	if err := matrixDo("PUT", path, content, nil); err != nil {
+		slog.Error("unable to post to Matrix room", "room", b.room, "err", err)
+	}
+}
+
+// matrixDo sends a request with the JSON encoded body, if not nil, to
+// the client-server API of -matrix-homeserver and decodes the response
+// into v, if not nil.
+func matrixDo(method, path string, body, v interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(*matrixHomeserver, "/")+"/_matrix/client/v3"+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*matrixToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Syncs wait up to 30 seconds for events.
+	c := http.Client{Timeout: time.Minute}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: Matrix responded with %s", method, path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/j1436go/todow/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "todow_http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "todow_http_request_duration_seconds",
+		Help: "Duration of HTTP requests by route and method.",
+	}, []string{"route", "method"})
+
+	boltTxDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "todow_bolt_transaction_duration_seconds",
+		Help:    "Duration of bolt transactions by type.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"type"})
+
+	itemsDesc = prometheus.NewDesc("todow_items", "Number of items by state.", []string{"state"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, boltTxDuration, itemCollector{})
+}
+
+// observeBoltTx records the duration of a bolt transaction.
+func observeBoltTx(writable bool, d time.Duration) {
+	typ := "read"
+	if writable {
+		typ = "write"
+	}
+	boltTxDuration.WithLabelValues(typ).Observe(d.Seconds())
+}
+
+// itemCollector counts the items in the store whenever metrics are scraped.
+type itemCollector struct{}
+
+func (itemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- itemsDesc
+}
+
+// Items of all users are counted together.
+func (itemCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, state := range []struct {
+		name string
+		done bool
+	}{{"pending", false}, {"done", true}} {
+		done := state.done
+
+		var sum int
+		for _, s := range stores {
+			for _, archived := range []bool{false, true} {
+				_, total, err := s.Find(store.Query{Done: &done, Archived: archived, Deferred: true, Limit: 1})
+				if err != nil {
+					ch <- prometheus.NewInvalidMetric(itemsDesc, err)
+					return
+				}
+				sum += total
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(itemsDesc, prometheus.GaugeValue, float64(sum), state.name)
+	}
+}
+
+// instrument counts requests to h and observes their durations
+// labeled with route.
+func instrument(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h(sw, r)
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+	}
+}
+
+// statusWriter remembers the status code and size of the body written
+// through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush lets streaming handlers flush through the wrapper.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
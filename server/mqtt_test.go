@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMQTTWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		header []byte
+	}{
+		{"empty", 0, []byte{0x30, 0x00}},
+		{"one byte length", 127, []byte{0x30, 0x7f}},
+		{"two byte length", 128, []byte{0x30, 0x80, 0x01}},
+		{"longest two byte length", 16383, []byte{0x30, 0xff, 0x7f}},
+		{"three byte length", 16384, []byte{0x30, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		body := bytes.Repeat([]byte{'x'}, tt.length)
+		if err := mqttWrite(&buf, 0x30, body[:tt.length/2], body[tt.length/2:]); err != nil {
+			t.Fatalf("%s: mqttWrite failed: %s", tt.name, err)
+		}
+		p := buf.Bytes()
+		if !bytes.HasPrefix(p, tt.header) {
+			t.Errorf("%s: header = % x, want % x", tt.name, p[:len(tt.header)], tt.header)
+		}
+		if len(p) != len(tt.header)+tt.length {
+			t.Errorf("%s: packet has %d bytes, want %d", tt.name, len(p), len(tt.header)+tt.length)
+		}
+	}
+}
+
+func TestMQTTString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []byte
+	}{
+		{"", []byte{0x00, 0x00}},
+		{"MQTT", []byte{0x00, 0x04, 'M', 'Q', 'T', 'T'}},
+		{"ä", []byte{0x00, 0x02, 0xc3, 0xa4}},
+		{strings.Repeat("a", 300), append([]byte{0x01, 0x2c}, strings.Repeat("a", 300)...)},
+	}
+	for _, tt := range tests {
+		if got := mqttString(tt.s); !bytes.Equal(got, tt.want) {
+			t.Errorf("mqttString(%.10q) = % x, want % x", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTConnect(t *testing.T) {
+	tests := []struct {
+		name string
+		code byte
+		err  string
+	}{
+		{"accepted", 0, ""},
+		{"bad password", 4, "bad user name or password"},
+		{"unknown code", 42, "return code 42"},
+	}
+	for _, tt := range tests {
+		client, broker := net.Pipe()
+		got := make(chan []byte, 1)
+		go func() {
+			// CONNECT of user u with password p: fixed header, protocol
+			// name and level, flags, keep alive, client ID, user name
+			// and password.
+			p := make([]byte, 42)
+			io.ReadFull(broker, p)
+			got <- p
+			broker.Write([]byte{0x20, 0x02, 0x00, tt.code})
+		}()
+
+		err := mqttConnect(client, url.UserPassword("u", "p"))
+		p := <-got
+		client.Close()
+		broker.Close()
+
+		want := []byte{0x10, 40, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0xc2, 0x00, 60, 0x00, 22, 't', 'o', 'd', 'o', 'w', '-'}
+		if !bytes.HasPrefix(p, want) {
+			t.Errorf("%s: CONNECT starts with % x, want % x", tt.name, p[:len(want)], want)
+		}
+		if tail := []byte{0x00, 0x01, 'u', 0x00, 0x01, 'p'}; !bytes.HasSuffix(p, tail) {
+			t.Errorf("%s: CONNECT ends with % x, want % x", tt.name, p[len(p)-len(tail):], tail)
+		}
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: mqttConnect failed: %s", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: mqttConnect = %v, want error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
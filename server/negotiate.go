@@ -0,0 +1,62 @@
+package server
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// negotiate returns the media type of offers the Accept header value
+// accept prefers, or "" if it accepts none of them. Ties go to the
+// earlier offer, so the first one is the default for clients sending
+// no or a wildcard Accept header.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	var best string
+	bestQ := 0.0
+	for _, offer := range offers {
+		q := acceptQuality(accept, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality value accept assigns to the media
+// type offer, using the most specific matching range.
+func acceptQuality(accept, offer string) float64 {
+	q, specificity := 0.0, -1
+	for _, r := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+
+		var s int
+		switch {
+		case typ == offer:
+			s = 2
+		case typ == "*/*":
+			s = 0
+		case strings.HasSuffix(typ, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(typ, "*")):
+			s = 1
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return q
+}
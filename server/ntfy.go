@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var ntfyServer = flags.String("ntfy-server", "https://ntfy.sh", "ntfy server push subscriptions publish to unless they name another")
+
+// A pushSubscription publishes the reminders of its user's items, and
+// the items as they fall due, to a topic of an ntfy server, see
+// https://ntfy.sh. Anyone knowing the topic may subscribe to it.
+type pushSubscription struct {
+	ID    string
+	Topic string
+	// Server is the ntfy server's URL, -ntfy-server if empty.
+	Server  string `json:",omitempty"`
+	Created time.Time
+}
+
+// ntfyTopicRegexp matches the topic names ntfy accepts.
+var ntfyTopicRegexp = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+// newPushSubscription returns a subscription to topic on server, or
+// an error if either is invalid.
+func newPushSubscription(topic, server string) (pushSubscription, error) {
+	if !ntfyTopicRegexp.MatchString(topic) {
+		return pushSubscription{}, fmt.Errorf("invalid topic %q, use up to 64 letters, digits, - and _", topic)
+	}
+	if server != "" {
+		u, err := url.Parse(server)
+		if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+			return pushSubscription{}, fmt.Errorf("invalid ntfy server %q, use http or https", server)
+		}
+		if !publicHost(u.Hostname()) {
+			return pushSubscription{}, fmt.Errorf("invalid ntfy server %q, the host must be public", server)
+		}
+	}
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return pushSubscription{}, err
+	}
+	return pushSubscription{
+		ID:      hex.EncodeToString(b),
+		Topic:   topic,
+		Server:  strings.TrimSuffix(server, "/"),
+		Created: time.Now(),
+	}, nil
+}
+
+// pushNotifiers returns notifiers for the push subscriptions of the
+// named user.
+func pushNotifiers(name string) []ntfyNotifier {
+	var ns []ntfyNotifier
+	for _, p := range settingsOf(name).Push {
+		// Only servers chosen by the user are restricted to public
+		// addresses, -ntfy-server may be a local one.
+		if p.Server == "" {
+			ns = append(ns, ntfyNotifier{strings.TrimSuffix(*ntfyServer, "/") + "/" + p.Topic, webhookClient})
+		} else {
+			ns = append(ns, ntfyNotifier{p.Server + "/" + p.Topic, userWebhookClient})
+		}
+	}
+	return ns
+}
+
+// An ntfyNotifier publishes to the ntfy topic at url with c.
+type ntfyNotifier struct {
+	url string
+	c   *http.Client
+}
+
+func (n ntfyNotifier) notify(item *todow.Item) error {
+	return n.publish("Reminder", "alarm_clock", item)
+}
+
+// publish publishes item with the title prefix and the ntfy tag,
+// which shows as an emoji.
+func (n ntfyNotifier) publish(title, tag string, item *todow.Item) error {
+	msg := item.Body
+	if !item.Due.IsZero() {
+		msg += "\nDue " + item.Due.In(time.Local).Format(todow.DueFormat)
+	}
+	req, err := http.NewRequest("POST", n.url, strings.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", fmt.Sprintf("%s: item #%d", title, item.ID))
+	req.Header.Set("Tags", tag)
+	if item.Priority == "A" {
+		req.Header.Set("Priority", "high")
+	}
+
+	resp, err := n.c.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to publish to %s: %s", n.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ntfy topic %s responded with %s", n.url, resp.Status)
+	}
+	return nil
+}
+
+// pushDue publishes the open items of s, which belongs to the named
+// user, that fell due at or after from and before to to ns.
+func pushDue(s store.Store, name string, ns []ntfyNotifier, from, to time.Time) {
+	open := false
+	col, _, err := s.Find(store.Query{Done: &open, DueAfter: from, DueBefore: to})
+	if err != nil {
+		slog.Error("unable to find items falling due", "user", name, "err", err)
+		return
+	}
+	for _, item := range col {
+		for _, n := range ns {
+			if err := n.publish("Due", "hourglass", item); err != nil {
+				slog.Error("unable to push due item", "item", item.ID, "err", err)
+			}
+		}
+	}
+}
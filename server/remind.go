@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var (
+	remindInterval = flags.Duration("remind-interval", 30*time.Second, "Interval between reminder checks")
+	defaultSnooze  = flags.Duration("snooze", 10*time.Minute, "Default snooze duration")
+
+	notifyLog     = flags.Bool("notify-log", true, "Log reminders")
+	notifyWebhook = flags.String("notify-webhook", "", "URL reminders are POSTed to as JSON")
+	notifyMailTo  = flags.String("notify-mail-to", "", "Address reminders are mailed to")
+	notifyMailVia = flags.String("notify-mail-smtp", "localhost:25", "SMTP server used for reminder, invitation and digest mails")
+	notifyMailFrm = flags.String("notify-mail-from", "todow@localhost", "Sender address of mails")
+	notifyMailUsr = flags.String("notify-mail-user", "", "SMTP username, if the server requires authentication")
+	notifyMailPwd = flags.String("notify-mail-pass", "", "SMTP password")
+)
+
+// A notifier delivers a reminder for an item.
+type notifier interface {
+	notify(item *todow.Item) error
+}
+
+// notifiers returns the notifiers enabled by flags.
+func notifiers() []notifier {
+	var ns []notifier
+	if *notifyLog {
+		ns = append(ns, logNotifier{})
+	}
+	if *notifyWebhook != "" {
+		ns = append(ns, webhookNotifier{*notifyWebhook})
+	}
+	if *notifyMailTo != "" {
+		ns = append(ns, newMailNotifier(*notifyMailTo))
+	}
+	return ns
+}
+
+type logNotifier struct{}
+
+func (logNotifier) notify(item *todow.Item) error {
+	slog.Info("reminder", "item", item.ID, "body", item.Body)
+	return nil
+}
+
+type webhookNotifier struct {
+	url string
+}
+
+func (n webhookNotifier) notify(item *todow.Item) error {
+	j, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("unable to marshal item: %s", err)
+	}
+
+	c := http.Client{Timeout: 10 * time.Second}
+	resp, err := c.Post(n.url, "application/json", bytes.NewReader(j))
+	if err != nil {
+		return fmt.Errorf("unable to POST %s: %s", n.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with %s", n.url, resp.Status)
+	}
+	return nil
+}
+
+type mailNotifier struct {
+	addr, from, to string
+	user, pass     string
+}
+
+// newMailNotifier returns a notifier mailing to the given address
+// via the server set by flags.
+func newMailNotifier(to string) mailNotifier {
+	return mailNotifier{
+		addr: *notifyMailVia,
+		from: *notifyMailFrm,
+		to:   to,
+		user: *notifyMailUsr,
+		pass: *notifyMailPwd,
+	}
+}
+
+func (n mailNotifier) notify(item *todow.Item) error {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Reminder for item #%d: %s\r\n", item.ID, item.Body)
+	if !item.Due.IsZero() {
+		fmt.Fprintf(&body, "Due: %s\r\n", item.Due.Format(todow.DueFormat))
+	}
+	return n.send("Reminder: "+item.Body, body.String())
+}
+
+// send mails a message with the given subject and body.
+func (n mailNotifier) send(subject, body string) error {
+	// Line breaks, e.g. in item bodies, would start further headers.
+	if strings.ContainsAny(n.from+n.to, "\r\n") {
+		return fmt.Errorf("invalid mail address %q", n.to)
+	}
+	subject = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(subject)
+
+	var auth smtp.Auth
+	if n.user != "" {
+		host := n.addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.user, n.pass, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", n.to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	fmt.Fprint(&msg, body)
+
+	return smtp.SendMail(n.addr, auth, n.from, []string{n.to}, msg.Bytes())
+}
+
+// remind periodically dispatches reminders that are due to ns and the
+// push subscriptions of their users, who are also pushed the items
+// falling due since the last check. It never returns.
+func remind(ns []notifier) {
+	last := time.Now()
+	for range time.Tick(*remindInterval) {
+		now := time.Now()
+		for name, s := range stores {
+			pushes := pushNotifiers(name)
+			if len(pushes) > 0 {
+				pushDue(s, name, pushes, last, now)
+			}
+
+			items, err := s.DueReminders(now)
+			if err != nil {
+				slog.Error("unable to check reminders", "user", name, "err", err)
+				continue
+			}
+
+			for _, item := range items {
+				events.publish(event{Type: eventReminder, ID: item.ID, Item: item, user: name})
+				for _, n := range ns {
+					if err := n.notify(item); err != nil {
+						slog.Error("unable to send reminder", "item", item.ID, "err", err)
+					}
+				}
+				for _, n := range pushes {
+					if err := n.notify(item); err != nil {
+						slog.Error("unable to push reminder", "item", item.ID, "err", err)
+					}
+				}
+			}
+		}
+		last = now
+	}
+}
+
+// snoozeItem moves the reminder of an item to the given duration from now.
+// The duration is read from the "for" query parameter, and defaults to
+// the snooze duration of the user's settings or -snooze.
+func snoozeItem(w http.ResponseWriter, r *http.Request, id int64) {
+	d := snoozeOf(userName(r))
+	if s := r.URL.Query().Get("for"); s != "" {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			httpError(w, r, fmt.Sprintf("invalid snooze duration %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	at := time.Now().Add(d)
+	switch err := userStore(r).Update(id, func(item *todow.Item) { item.RemindAt = at }, nil).(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	case nil:
+		publishItem(r, eventUpdated, id)
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "Snoozed item #%d until %s\n", id, at.Format(todow.DueFormat))
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// search returns the items matching the "q" query parameter.
+func search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query().Get("q")
+	if strings.TrimSpace(q) == "" {
+		httpError(w, r, "missing search text", http.StatusBadRequest)
+		return
+	}
+
+	col, err := userStore(r).Search(q)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(col)))
+	writeItems(w, r, col)
+}
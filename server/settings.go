@@ -0,0 +1,525 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/j1436go/todow"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var settingsFile = flags.String("settings", "todow.settings", "File the settings users change in the web UI are kept in, like API tokens and webhooks")
+
+// tokenPrefix starts all API tokens, telling them apart from passwords.
+const tokenPrefix = "todow_"
+
+// userSettings are the settings a user changes with the settings
+// endpoints rather than the flags of the server.
+type userSettings struct {
+	// Snooze is the default snooze duration, -snooze if zero.
+	Snooze duration `json:",omitempty"`
+
+	// RemindBefore sets the reminders of new items due at a time
+	// this long before it, if it is positive and they have none.
+	RemindBefore duration `json:",omitempty"`
+
+	// Webhooks are URLs the events of the user's items are POSTed
+	// to, besides those given with -webhook.
+	Webhooks []string `json:",omitempty"`
+
+	// WebhookSecrets are the keys the payloads POSTed to Webhooks are
+	// signed with, by URL. Each is shown only when its webhook is added.
+	WebhookSecrets map[string]string `json:",omitempty"`
+
+	// Filters are the user's saved filters.
+	Filters []savedFilter `json:",omitempty"`
+
+	// Digest mails a summary of the user's items on a schedule,
+	// if they opted in.
+	Digest *digest `json:",omitempty"`
+
+	// Push are the user's push subscriptions.
+	Push []pushSubscription `json:",omitempty"`
+
+	// Google connects the user's items to Google Tasks, if they did so.
+	Google *googleTasks `json:",omitempty"`
+
+	Tokens []apiToken `json:",omitempty"`
+}
+
+// An apiToken logs in its user in place of their password, as Basic
+// Auth password or Bearer token. Only its hash is kept.
+type apiToken struct {
+	ID      string
+	Name    string
+	Hash    string `json:",omitempty"`
+	Created time.Time
+}
+
+// settingsPatch is the body of a PATCH request to the settings.
+// Nil fields are left untouched.
+type settingsPatch struct {
+	Snooze       *duration
+	RemindBefore *duration
+	Webhooks     *[]string
+	Filters      *[]savedFilter
+
+	// Digest opts in to digests, or out of them if it has neither
+	// address nor schedule.
+	Digest *digest
+}
+
+// duration is a time.Duration encoded in JSON like "1h30m".
+type duration time.Duration
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// settings holds the settings of all users by name, which are persisted
+// to settingsFile.
+var settings struct {
+	sync.Mutex
+	users map[string]*userSettings
+}
+
+// loadSettings reads the settings file, if it exists.
+func loadSettings() error {
+	settings.users = map[string]*userSettings{}
+	p, err := ioutil.ReadFile(*settingsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, &settings.users)
+}
+
+// saveSettings atomically replaces the settings file.
+// The caller must hold the settings lock.
+func saveSettings() error {
+	p, err := json.MarshalIndent(settings.users, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(*settingsFile, p)
+}
+
+// settingsOf returns a copy of the settings of the named user.
+func settingsOf(name string) userSettings {
+	settings.Lock()
+	defer settings.Unlock()
+	if s, ok := settings.users[name]; ok {
+		return *s
+	}
+	return userSettings{}
+}
+
+// snoozeOf returns the default snooze duration of the named user.
+func snoozeOf(name string) time.Duration {
+	if d := settingsOf(name).Snooze; d > 0 {
+		return time.Duration(d)
+	}
+	return *defaultSnooze
+}
+
+// setDefaultReminder sets the reminder of the new item of the named
+// user as their settings ask for.
+func setDefaultReminder(name string, item *todow.Item) {
+	before := time.Duration(settingsOf(name).RemindBefore)
+	if before <= 0 || item.Due.IsZero() || !item.RemindAt.IsZero() {
+		return
+	}
+	if at := item.Due.Add(-before); at.After(time.Now()) {
+		item.RemindAt = at
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validToken reports whether token is an API token of the named user.
+func validToken(name, token string) bool {
+	h := []byte(hashToken(token))
+	valid := false
+	for _, t := range settingsOf(name).Tokens {
+		if subtle.ConstantTimeCompare(h, []byte(t.Hash)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// tokenUser returns the login name of the user owning the API token.
+func tokenUser(token string) (string, bool) {
+	h := hashToken(token)
+	settings.Lock()
+	defer settings.Unlock()
+	for name, s := range settings.users {
+		for _, t := range s.Tokens {
+			if t.Hash == h {
+				return loginName(name), true
+			}
+		}
+	}
+	return "", false
+}
+
+// manageSettings shows and changes the settings of the requesting user.
+// PATCH applies a settingsPatch, POST on settings/password changes the
+// password, given the current one. POST on settings/tokens creates an
+// API token named by the "name" field of the body, which is shown only
+// in the response, DELETE on settings/tokens/ID revokes it. POST on
+// settings/push subscribes to the ntfy topic named by the "Topic" field
+// of the body, on the server of the "Server" field if given, DELETE on
+// settings/push/ID unsubscribes. DELETE on settings/google disconnects
+// the user's items from Google Tasks, see connectGoogle.
+func manageSettings(w http.ResponseWriter, r *http.Request) {
+	me := userName(r)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, todow.APIPath+"settings"), "/")
+
+	switch {
+	case path == "password" && r.Method == "POST":
+		changePassword(w, r)
+		return
+	case path == "" && r.Method == "GET":
+		s := settingsOf(me)
+		tokens := []apiToken{}
+		for _, t := range s.Tokens {
+			t.Hash = ""
+			tokens = append(tokens, t)
+		}
+		s.Tokens = tokens
+		s.WebhookSecrets = nil
+		if s.Google != nil {
+			g := *s.Google
+			g.Token = nil
+			s.Google = &g
+		}
+		writeJSON(w, r, s)
+		return
+	}
+
+	settings.Lock()
+	defer settings.Unlock()
+	prev, ok := settings.users[me]
+	s := &userSettings{}
+	if ok {
+		*s = *prev
+	}
+
+	// resp writes the response once the settings are saved.
+	var resp func()
+	var action, detail string
+	switch {
+	case path == "" && r.Method == "PATCH":
+		// The secrets of added webhooks, shown only in the response.
+		added := map[string]string{}
+		var p settingsPatch
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			httpError(w, r, fmt.Sprintf("unable to decode settings: %s", err), http.StatusBadRequest)
+			return
+		}
+		if p.Webhooks != nil {
+			for _, u := range *p.Webhooks {
+				if err := validWebhook(u); err != nil {
+					httpError(w, r, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			secrets := map[string]string{}
+			for _, u := range *p.Webhooks {
+				if secret, ok := s.WebhookSecrets[u]; ok {
+					secrets[u] = secret
+					continue
+				}
+				secret, err := newWebhookSecret()
+				if err != nil {
+					httpError(w, r, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				secrets[u], added[u] = secret, secret
+			}
+			s.Webhooks = *p.Webhooks
+			s.WebhookSecrets = secrets
+		}
+		if p.Filters != nil {
+			if err := validFilters(*p.Filters); err != nil {
+				httpError(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.Filters = *p.Filters
+		}
+		if d := p.Digest; d != nil && d.To == "" && d.Schedule == "" {
+			s.Digest = nil
+		} else if d != nil {
+			if err := validDigest(*d); err != nil {
+				httpError(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// Items added before don't show up in the first digest.
+			s.Digest = &digest{To: d.To, Schedule: d.Schedule, Sent: time.Now()}
+		}
+		if p.Snooze != nil {
+			s.Snooze = *p.Snooze
+		}
+		if p.RemindBefore != nil {
+			s.RemindBefore = *p.RemindBefore
+		}
+		action = auditSettings
+		resp = func() {
+			fmt.Fprintln(w, "Updated settings")
+			for _, u := range *p.Webhooks {
+				if secret, ok := added[u]; ok {
+					fmt.Fprintf(w, "Secret of webhook %s: %s\n", u, secret)
+				}
+			}
+		}
+	case path == "tokens" && r.Method == "POST":
+		var body struct{ Name string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+			httpError(w, r, "missing token name", http.StatusBadRequest)
+			return
+		}
+		token, t, err := newAPIToken(strings.TrimSpace(body.Name))
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.Tokens = append(append([]apiToken(nil), s.Tokens...), t)
+		action, detail = auditToken, t.ID+" "+t.Name
+		resp = func() {
+			t.Hash = ""
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(struct {
+				apiToken
+				Token string
+			}{t, token})
+		}
+	case strings.HasPrefix(path, "tokens/") && r.Method == "DELETE":
+		id := strings.TrimPrefix(path, "tokens/")
+		var kept []apiToken
+		for _, t := range s.Tokens {
+			if t.ID != id {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == len(s.Tokens) {
+			http.NotFound(w, r)
+			return
+		}
+		s.Tokens = kept
+		action, detail = auditRevokeToken, id
+		resp = func() { fmt.Fprintf(w, "Revoked token %s\n", id) }
+	case path == "push" && r.Method == "POST":
+		var body struct{ Topic, Server string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			httpError(w, r, fmt.Sprintf("unable to decode subscription: %s", err), http.StatusBadRequest)
+			return
+		}
+		p, err := newPushSubscription(strings.TrimSpace(body.Topic), strings.TrimSpace(body.Server))
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Push = append(append([]pushSubscription(nil), s.Push...), p)
+		action, detail = auditPush, p.ID+" "+p.Topic
+		resp = func() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(p)
+		}
+	case strings.HasPrefix(path, "push/") && r.Method == "DELETE":
+		id := strings.TrimPrefix(path, "push/")
+		var kept []pushSubscription
+		for _, p := range s.Push {
+			if p.ID != id {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == len(s.Push) {
+			http.NotFound(w, r)
+			return
+		}
+		s.Push = kept
+		action, detail = auditRemovePush, id
+		resp = func() { fmt.Fprintf(w, "Removed push subscription %s\n", id) }
+	case path == "google" && r.Method == "DELETE":
+		if s.Google == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.Google = nil
+		action = auditUngoogle
+		resp = func() { fmt.Fprintln(w, "Disconnected from Google Tasks") }
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	settings.users[me] = s
+	if err := saveSettings(); err != nil {
+		if ok {
+			settings.users[me] = prev
+		} else {
+			delete(settings.users, me)
+		}
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, "", action, detail)
+	resp()
+}
+
+// newAPIToken returns a new API token named name, and its entry.
+func newAPIToken(name string) (string, apiToken, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", apiToken{}, err
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, apiToken{
+		ID:      hashToken(token)[:8],
+		Name:    name,
+		Hash:    hashToken(token),
+		Created: time.Now(),
+	}, nil
+}
+
+// newWebhookSecret returns a new key to sign webhook payloads with.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validWebhook returns an error if u isn't an absolute HTTP(S) URL on
+// a public host.
+func validWebhook(u string) error {
+	p, err := url.Parse(u)
+	if err != nil || p.Host == "" || p.Scheme != "http" && p.Scheme != "https" {
+		return fmt.Errorf("invalid webhook URL %q, use http or https", u)
+	}
+	if !publicHost(p.Hostname()) {
+		return fmt.Errorf("invalid webhook URL %q, the host must be public", u)
+	}
+	return nil
+}
+
+// changePassword sets the password of the requesting user to the "New"
+// field of the body, if "Current" is their current password.
+func changePassword(w http.ResponseWriter, r *http.Request) {
+	var body struct{ Current, New string }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpError(w, r, fmt.Sprintf("unable to decode password change: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	login := loginName(userName(r))
+	if login == *user && *pass != "" {
+		httpError(w, r, "the password of the default user is set with -p and can't be changed here", http.StatusConflict)
+		return
+	}
+	if body.New == "" {
+		httpError(w, r, "empty password", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(body.New), bcrypt.DefaultCost)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	old, ok := accounts[login]
+	if !ok || bcrypt.CompareHashAndPassword(old, []byte(body.Current)) != nil {
+		httpError(w, r, "wrong current password", http.StatusForbidden)
+		return
+	}
+
+	// Accounts added with adduser since the start are kept.
+	accts, err := loadAccounts(*usersFile)
+	if os.IsNotExist(err) {
+		accts, err = map[string][]byte{}, nil
+	}
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	accts[login] = hash
+	if err := saveAccounts(*usersFile, accts); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	accounts[login] = hash
+
+	audit(r, "", auditPassword, "")
+	fmt.Fprintln(w, "Changed password")
+}
+
+// settingsPage shows the settings of the requesting user.
+func settingsPage(w http.ResponseWriter, r *http.Request) {
+	s := settingsOf(userName(r))
+	login := loginName(userName(r))
+	if err := settingsTmpl.Execute(w, struct {
+		Settings      userSettings
+		Snooze        time.Duration
+		NtfyServer    string
+		Google        bool
+		PasswordFixed bool
+		User, CSRF    string
+		T             messages
+	}{
+		s,
+		*defaultSnooze,
+		*ntfyServer,
+		*googleClientID != "",
+		login == *user && *pass != "",
+		login,
+		csrfToken(r),
+		requestMessages(w, r),
+	}); err != nil {
+		requestLog(r).Error("unable to render page", "err", err)
+	}
+}
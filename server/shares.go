@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var sharesFile = flags.String("shares", "todow.shares", "File the read-only share links are kept in")
+
+// A share grants read-only access to the items of a user having a tag,
+// or to all items if Tag is empty, to everyone knowing its token.
+type share struct {
+	Token   string
+	User    string `json:",omitempty"`
+	Tag     string `json:",omitempty"`
+	Created time.Time
+}
+
+// shares holds all share links, which are persisted to sharesFile.
+var shares struct {
+	sync.Mutex
+	all []share
+}
+
+// loadShares reads the shares file, if it exists.
+func loadShares() error {
+	p, err := ioutil.ReadFile(*sharesFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, &shares.all)
+}
+
+// saveShares atomically replaces the shares file.
+// The caller must hold the shares lock.
+func saveShares() error {
+	p, err := json.MarshalIndent(shares.all, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(*sharesFile, p)
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func shareURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/share/%s", scheme, r.Host, token)
+}
+
+// manageShares lists, creates and revokes the share links of the
+// requesting user. POST creates a link to the items tagged with
+// the "tag" query parameter, DELETE on shares/TOKEN revokes it.
+func manageShares(w http.ResponseWriter, r *http.Request) {
+	user := userName(r)
+
+	shares.Lock()
+	defer shares.Unlock()
+
+	switch r.Method {
+	case "GET":
+		own := []share{}
+		for _, s := range shares.all {
+			if s.User == user {
+				own = append(own, s)
+			}
+		}
+		writeJSON(w, r, own)
+	case "POST":
+		token, err := newShareToken()
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s := share{
+			Token:   token,
+			User:    user,
+			Tag:     strings.TrimPrefix(r.URL.Query().Get("tag"), todow.TagPrefix),
+			Created: time.Now(),
+		}
+		shares.all = append(shares.all, s)
+		if err := saveShares(); err != nil {
+			shares.all = shares.all[:len(shares.all)-1]
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		audit(r, "", auditShare, fmt.Sprintf("tag %q", s.Tag))
+		w.WriteHeader(201)
+		fmt.Fprintf(w, "Shared at %s\n", shareURL(r, token))
+	case "DELETE":
+		token := strings.TrimPrefix(r.URL.Path, todow.APIPath+"shares/")
+		for i, s := range shares.all {
+			if s.Token != token || s.User != user {
+				continue
+			}
+
+			old := shares.all
+			shares.all = append(append([]share{}, old[:i]...), old[i+1:]...)
+			if err := saveShares(); err != nil {
+				shares.all = old
+				httpError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			audit(r, "", auditUnshare, fmt.Sprintf("tag %q", s.Tag))
+			fmt.Fprintf(w, "Revoked share %s\n", token)
+			return
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// viewShare renders the read-only view of a share link. It needs no
+// authentication, the token in the path being the credential.
+func viewShare(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/share/")
+
+	shares.Lock()
+	var s *share
+	for i := range shares.all {
+		if shares.all[i].Token == token {
+			s = &shares.all[i]
+			break
+		}
+	}
+	shares.Unlock()
+
+	if token == "" || s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	audit(withUser(r, s.User), "", auditShareView, fmt.Sprintf("tag %q", s.Tag))
+
+	db, ok := stores[s.User]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var q store.Query
+	if s.Tag != "" {
+		q.Tags = []string{s.Tag}
+	}
+	col, _, err := db.WithContext(storeContext(r)).Find(q)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = shareTmpl.Execute(&buf, struct {
+		Items []todow.NestedItem
+		Tag   string
+		T     messages
+	}{todow.Nest(col), s.Tag, requestMessages(w, r)})
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Keep the token out of Referer headers sent to other sites.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	buf.WriteTo(w)
+}
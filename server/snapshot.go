@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	snapshotDir      = flags.String("snapshot-dir", "", "Directory periodic snapshots are written to, disabled if empty")
+	snapshotInterval = flags.Duration("snapshot-interval", 24*time.Hour, "Interval between snapshots")
+	snapshotKeep     = flags.Int("snapshot-keep", 7, "Number of snapshots to keep, 0 keeps all")
+)
+
+const snapshotPrefix = "todow-"
+
+// snapshots writes a snapshot to the snapshot directory every
+// snapshot interval and prunes old ones. It never returns.
+func snapshots() {
+	if err := os.MkdirAll(*snapshotDir, 0700); err != nil {
+		slog.Error("unable to create snapshot directory", "err", err)
+		return
+	}
+
+	for range time.Tick(*snapshotInterval) {
+		path, err := snapshot(time.Now())
+		if err != nil {
+			slog.Error("unable to write snapshot", "err", err)
+			continue
+		}
+		slog.Info("wrote snapshot", "file", path)
+
+		if err := pruneSnapshots(*snapshotKeep); err != nil {
+			slog.Error("unable to prune snapshots", "err", err)
+		}
+	}
+}
+
+// snapshot writes a snapshot named after t. The snapshot is written to
+// a temporary file first so the directory never holds partial snapshots.
+func snapshot(t time.Time) (string, error) {
+	name := fmt.Sprintf("%s%s.%s", snapshotPrefix, t.Format("20060102-150405"), *storeKind)
+	path := filepath.Join(*snapshotDir, name)
+
+	tmp := filepath.Join(*snapshotDir, "."+name)
+	if err := backupTo(tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// pruneSnapshots removes all but the newest keep snapshots.
+func pruneSnapshots(keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	fis, err := ioutil.ReadDir(*snapshotDir)
+	if err != nil {
+		return err
+	}
+
+	// Timestamps in the names make lexical order chronological.
+	var names []string
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), snapshotPrefix) {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(*snapshotDir, names[0])); err != nil {
+			return err
+		}
+		slog.Info("removed snapshot", "file", names[0])
+		names = names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// stats summarizes the items of a user.
+type stats struct {
+	Open, Done int
+
+	// Weeks counts the items added and completed per week,
+	// oldest week first.
+	Weeks []weekStats
+
+	// OldestOpen is the open item created first, if any.
+	OldestOpen *todow.Item `json:",omitempty"`
+
+	// AvgCompletion is the mean time from creation to completion
+	// of the done items.
+	AvgCompletion time.Duration
+
+	// Tags counts the open and done items by tag, the tag of the
+	// most items first.
+	Tags []tagStats
+}
+
+type weekStats struct {
+	// Start is midnight of the Monday the week starts with.
+	Start            time.Time
+	Added, Completed int
+
+	// Backlog is the number of items open at the end of the week,
+	// or now for the current week.
+	Backlog int
+}
+
+type tagStats struct {
+	Tag        string
+	Open, Done int
+}
+
+// Rate returns the percentage of the items that are done.
+func (t tagStats) Rate() int {
+	return t.Done * 100 / (t.Open + t.Done)
+}
+
+// itemStats returns the stats of the requesting user's items. The
+// "weeks" query parameter sets the number of weeks counted, 8 by default.
+func itemStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	weeks, err := statsWeeks(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	col, err := userStore(r).All()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, computeStats(col, time.Now(), weeks))
+}
+
+// statsWeeks returns the number of weeks the stats of r count.
+func statsWeeks(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("weeks")
+	if s == "" {
+		return 8, nil
+	}
+	weeks, err := strconv.Atoi(s)
+	if err != nil || weeks < 0 || weeks > 520 {
+		return 0, fmt.Errorf("invalid weeks %q", s)
+	}
+	return weeks, nil
+}
+
+// statsPage shows the stats of the requesting user's items as charts.
+// The "weeks" query parameter works as for the stats endpoint.
+func statsPage(w http.ResponseWriter, r *http.Request) {
+	weeks, err := statsWeeks(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	col, err := userStore(r).All()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s := computeStats(col, time.Now(), weeks)
+
+	// The charts are scaled to their largest values.
+	var max, maxBacklog int
+	for _, ws := range s.Weeks {
+		if ws.Added > max {
+			max = ws.Added
+		}
+		if ws.Completed > max {
+			max = ws.Completed
+		}
+		if ws.Backlog > maxBacklog {
+			maxBacklog = ws.Backlog
+		}
+	}
+
+	var list string
+	if l, ok := requestList(r); ok {
+		list = listName(l.owner, l.tag)
+	}
+
+	if err := statsTmpl.Execute(w, struct {
+		Stats           stats
+		Weeks           int
+		Spans           []int
+		Max, MaxBacklog int
+		Home, List      string
+		T               messages
+	}{
+		s,
+		weeks,
+		[]int{4, 8, 26, 52},
+		max,
+		maxBacklog,
+		homeURL(r),
+		list,
+		requestMessages(w, r),
+	}); err != nil {
+		requestLog(r).Error("unable to render page", "err", err)
+	}
+}
+
+func computeStats(col []*todow.Item, now time.Time, weeks int) stats {
+	s := stats{Weeks: make([]weekStats, weeks)}
+
+	// Weeks start on Monday.
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	for i := range s.Weeks {
+		s.Weeks[i].Start = monday.AddDate(0, 0, -7*(weeks-1-i))
+	}
+	// week returns the counts of the week t falls into, if counted.
+	week := func(t time.Time) *weekStats {
+		for i := len(s.Weeks) - 1; i >= 0; i-- {
+			if !t.Before(s.Weeks[i].Start) {
+				return &s.Weeks[i]
+			}
+		}
+		return nil
+	}
+
+	var total time.Duration
+	var timed int
+	for _, v := range col {
+		if ws := week(v.Created); ws != nil {
+			ws.Added++
+		}
+
+		if !v.Done {
+			s.Open++
+			if s.OldestOpen == nil || v.Created.Before(s.OldestOpen.Created) {
+				s.OldestOpen = v
+			}
+			continue
+		}
+
+		s.Done++
+		// Items completed before completion times were recorded lack them.
+		if v.CompletedAt.IsZero() {
+			continue
+		}
+		if ws := week(v.CompletedAt); ws != nil {
+			ws.Completed++
+		}
+		if !v.Created.IsZero() && v.CompletedAt.After(v.Created) {
+			total += v.CompletedAt.Sub(v.Created)
+			timed++
+		}
+	}
+	if timed > 0 {
+		s.AvgCompletion = total / time.Duration(timed)
+	}
+
+	for i := range s.Weeks {
+		end := s.Weeks[i].Start.AddDate(0, 0, 7)
+		if end.After(now) {
+			end = now
+		}
+		for _, v := range col {
+			// Items done at an unknown time are left out.
+			open := !v.Done || v.CompletedAt.After(end)
+			if open && v.Created.Before(end) {
+				s.Weeks[i].Backlog++
+			}
+		}
+	}
+
+	tags := map[string]*tagStats{}
+	for _, v := range col {
+		for _, tag := range v.Tags {
+			ts, ok := tags[tag]
+			if !ok {
+				ts = &tagStats{Tag: tag}
+				tags[tag] = ts
+			}
+			if v.Done {
+				ts.Done++
+			} else {
+				ts.Open++
+			}
+		}
+	}
+	for _, ts := range tags {
+		s.Tags = append(s.Tags, *ts)
+	}
+	sort.Slice(s.Tags, func(i, j int) bool {
+		a, b := s.Tags[i], s.Tags[j]
+		if a.Open+a.Done != b.Open+b.Done {
+			return a.Open+a.Done > b.Open+b.Done
+		}
+		return a.Tag < b.Tag
+	})
+	return s
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+var (
+	templatesDir = flags.String("templates", "", "Directory with templates overriding the built-in ones, index.html, share.html, invite.html, stats.html and settings.html")
+	staticDir    = flags.String("static", "", "Directory with assets served below /static/, overriding the built-in ones like todow.css")
+)
+
+//go:embed web
+var web embed.FS
+
+// Templates of the web pages, parsed by loadTemplates.
+var tmpl, shareTmpl, inviteTmpl, statsTmpl, settingsTmpl *template.Template
+
+// dueInputFormat is the layout of the values of datetime-local inputs.
+const dueInputFormat = "2006-01-02T15:04"
+
+// templateFuncs are the functions available to the templates.
+var templateFuncs = template.FuncMap{
+	// pct returns n as a percentage of total, 0 if total is.
+	"pct": func(n, total int) int {
+		if total == 0 {
+			return 0
+		}
+		return n * 100 / total
+	},
+	"days": todow.FormatDays,
+	// overdue reports whether the due time t has passed.
+	"overdue": func(t time.Time) bool {
+		return !t.IsZero() && t.Before(time.Now())
+	},
+	// deferred reports whether the start time t is still ahead.
+	"deferred": func(t time.Time) bool {
+		return t.After(time.Now())
+	},
+	// dueToday reports whether the due time t is today.
+	"dueToday": func(t time.Time) bool {
+		y, m, d := time.Now().Date()
+		dy, dm, dd := t.Local().Date()
+		return dy == y && dm == m && dd == d
+	},
+	// dueInput formats the due time t as value of a datetime-local input.
+	"dueInput": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(dueInputFormat)
+	},
+}
+
+// webDir returns the files of the embedded directory name, with those
+// of dir taking precedence if it isn't empty.
+func webDir(name, dir string) fs.FS {
+	embedded, err := fs.Sub(web, "web/"+name)
+	if err != nil {
+		panic(err)
+	}
+	if dir == "" {
+		return embedded
+	}
+	return overlayFS{os.DirFS(dir), embedded}
+}
+
+// loadTemplates parses the templates of the web pages.
+func loadTemplates() error {
+	files := webDir("templates", *templatesDir)
+	for name, t := range map[string]**template.Template{
+		"index.html":    &tmpl,
+		"share.html":    &shareTmpl,
+		"invite.html":   &inviteTmpl,
+		"stats.html":    &statsTmpl,
+		"settings.html": &settingsTmpl,
+	} {
+		p, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		if *t, err = template.New(name).Funcs(templateFuncs).Parse(string(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// static serves the stylesheets and scripts of the web pages.
+// They are public, as shared lists use them too.
+func static() http.HandlerFunc {
+	return http.StripPrefix("/static/", http.FileServer(http.FS(webDir("static", *staticDir)))).ServeHTTP
+}
+
+// serviceWorker serves the service worker of the web UI, sw.js among the
+// static assets. It is served from the root, as it only controls the
+// pages below its own path.
+func serviceWorker(w http.ResponseWriter, r *http.Request) {
+	p, err := fs.ReadFile(webDir("static", *staticDir), "sw.js")
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	// Browsers check for updates of the worker on every visit.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(p)
+}
+
+func init() {
+	mime.AddExtensionType(".webmanifest", "application/manifest+json")
+}
+
+// overlayFS opens files from the first of its file systems having them.
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	var err error
+	for _, fsys := range o {
+		var f fs.File
+		if f, err = fsys.Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return nil, err
+}
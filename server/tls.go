@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	tlsCert = flags.String("tls-cert", "", "TLS certificate file, serve HTTPS if given with -tls-key")
+	tlsKey  = flags.String("tls-key", "", "TLS private key file")
+
+	autocertDomain = flags.String("autocert", "", "Domain to obtain a Let's Encrypt certificate for, serve HTTPS on -a and answer ACME challenges on :80")
+	autocertCache  = flags.String("autocert-cache", "autocert", "Directory certificates obtained with -autocert are cached in")
+
+	socketMode = flags.String("socket-mode", "0660", "Permissions of the Unix socket of -a unix:PATH, e.g. to let a reverse proxy in the group of the server connect")
+)
+
+// listen returns the listener passed by systemd socket activation, or
+// else listens on the listen address, a Unix socket if it starts with
+// unix:. Stale sockets left by a previous run are replaced.
+func listen() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if n != 1 {
+			return nil, fmt.Errorf("systemd passed %d sockets, expected 1", n)
+		}
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		// Passed sockets start at file descriptor 3.
+		return net.FileListener(os.NewFile(3, "systemd socket"))
+	}
+
+	path := strings.TrimPrefix(*listenAddr, "unix:")
+	if path == *listenAddr {
+		return net.Listen("tcp", *listenAddr)
+	}
+
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -socket-mode %q", *socketMode)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// The socket is created in a directory only the server may enter
+	// and moved into place once it has its mode, so clients can't
+	// connect before.
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".todow-socket-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "socket")
+
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return movedListener{l, &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// movedListener is a listener whose socket was moved to addr.
+type movedListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l movedListener) Addr() net.Addr { return l.addr }
+
+// serve serves h on the listener of listen, over HTTPS if a
+// certificate is configured, with the timeouts and limits of the flags.
+// It only returns on errors.
+func serve(h http.Handler) error {
+	l, err := listen()
+	if err != nil {
+		return err
+	}
+	if l.Addr().Network() == "unix" && len(ipRules) > 0 && *proxyIPHeader == "" {
+		l.Close()
+		return fmt.Errorf("-ip-rule needs -proxy-ip-header on Unix sockets, which don't tell the addresses of clients")
+	}
+
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+	}
+
+	switch {
+	case *autocertDomain != "":
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(*autocertDomain),
+			Cache:      autocert.DirCache(*autocertCache),
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate}
+
+		// Plain HTTP is only used for challenges, and redirected otherwise.
+		go func() {
+			slog.Info("answering ACME challenges", "addr", ":80")
+			if err := http.ListenAndServe(":80", m.HTTPHandler(nil)); err != nil {
+				fatal("unable to answer ACME challenges", "err", err)
+			}
+		}()
+
+		slog.Info("listening", "addr", l.Addr().String(), "url", "https://"+*autocertDomain)
+		return srv.ServeTLS(l, "", "")
+	case *tlsCert != "" || *tlsKey != "":
+		slog.Info("listening with TLS", "addr", l.Addr().String())
+		return srv.ServeTLS(l, *tlsCert, *tlsKey)
+	default:
+		slog.Info("listening", "addr", l.Addr().String())
+		return srv.Serve(l)
+	}
+}
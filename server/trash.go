@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/store"
+)
+
+var trashRetention = flags.Duration("trash-retention", 30*24*time.Hour, "Time removed items are kept in the trash before they are purged, 0 keeps them forever")
+
+// trashItems lists the removed items of the requesting user or shared
+// list, most recently removed first. POST on trash/ID restores an item.
+func trashItems(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, todow.APIPath+"trash"), "/")
+
+	switch {
+	case path == "" && r.Method == "GET":
+		col, err := userStore(r).Trash()
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeItems(w, r, col)
+	case path != "" && r.Method == "POST":
+		id, err := strconv.ParseInt(path, 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		restoreItem(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// restoreItem moves an item out of the trash. Clients learn about it
+// like about a new item.
+func restoreItem(w http.ResponseWriter, r *http.Request, id int64) {
+	item, err := userStore(r).Restore(id)
+	switch err.(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case store.ErrReadOnly:
+		httpError(w, r, err.Error(), http.StatusForbidden)
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	case nil:
+		audit(r, "", auditRestore, fmt.Sprintf("item #%d: %s", id, item.Body))
+		events.publish(event{Type: eventAdded, ID: id, Item: item, user: storeOwner(r)})
+		fmt.Fprintf(w, "Restored item #%d\n", id)
+	}
+}
+
+// purgeTrash hourly deletes the items of all users that were removed
+// longer than -trash-retention ago. It never returns.
+func purgeTrash() {
+	for range time.Tick(time.Hour) {
+		before := time.Now().Add(-*trashRetention)
+		for name, s := range stores {
+			n, err := s.Purge(before)
+			if err != nil {
+				slog.Error("unable to purge trash", "user", name, "err", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("purged trash", "user", name, "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/quickadd"
+	"github.com/j1436go/todow/store"
+)
+
+// triggerLimit is the number of items polling triggers return by default.
+const triggerLimit = 50
+
+// A triggerItem is an item in the flat shape automation services like
+// Zapier and IFTTT expect. ID identifies the event, which services use
+// to tell items they have seen from new ones.
+type triggerItem struct {
+	ID          string       `json:"id"`
+	ItemID      int64        `json:"item_id"`
+	Body        string       `json:"body"`
+	Notes       string       `json:"notes"`
+	Tags        string       `json:"tags"`
+	Priority    string       `json:"priority"`
+	Created     string       `json:"created"`
+	Due         string       `json:"due"`
+	Done        bool         `json:"done"`
+	CompletedAt string       `json:"completed_at"`
+	Meta        *triggerMeta `json:"meta,omitempty"`
+}
+
+// triggerMeta holds the fields IFTTT reads the ID and time of an event from.
+type triggerMeta struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// newTriggerItem returns item as event with the given ID that happened at t.
+func newTriggerItem(id string, t time.Time, item *todow.Item) triggerItem {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return triggerItem{
+		ID:          id,
+		ItemID:      item.ID,
+		Body:        item.Body,
+		Notes:       item.Notes,
+		Tags:        strings.Join(item.Tags, " "),
+		Priority:    item.Priority,
+		Created:     format(item.Created),
+		Due:         format(item.Due),
+		Done:        item.Done,
+		CompletedAt: format(item.CompletedAt),
+		Meta:        &triggerMeta{ID: id, Timestamp: t.Unix()},
+	}
+}
+
+// trigger serves the polling triggers triggers/new_items and
+// triggers/completed_items, which list the items added or completed
+// most recently first. GET requests, as Zapier sends them, are answered
+// with a JSON array and take the query parameters "limit", "tag" and
+// "since", an RFC 3339 time only events after which are listed. POST
+// requests, as IFTTT sends them, take a JSON object with "limit" and
+// "triggerFields" with "tag" and are answered in its envelope.
+func trigger(w http.ResponseWriter, r *http.Request) {
+	kind := strings.TrimPrefix(r.URL.Path, todow.APIPath+"triggers/")
+	if kind != "new_items" && kind != "completed_items" || r.Method != "GET" && r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	limit, tag, ifttt := triggerLimit, "", r.Method == "POST"
+	var since time.Time
+	if ifttt {
+		req := struct {
+			Limit         *int
+			TriggerFields struct{ Tag string }
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, fmt.Sprintf("unable to decode trigger request: %s", err), http.StatusBadRequest)
+			return
+		}
+		if req.Limit != nil {
+			limit = *req.Limit
+		}
+		tag = req.TriggerFields.Tag
+	} else {
+		q := r.URL.Query()
+		if s := q.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				httpError(w, r, fmt.Sprintf("invalid limit %q", s), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if s := q.Get("since"); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				httpError(w, r, fmt.Sprintf("invalid since %q, use RFC 3339", s), http.StatusBadRequest)
+				return
+			}
+			since = t
+		}
+		tag = q.Get("tag")
+	}
+	if limit < 0 {
+		httpError(w, r, fmt.Sprintf("invalid limit %d", limit), http.StatusBadRequest)
+		return
+	}
+
+	query := store.Query{Deferred: true}
+	if tag != "" {
+		query.Tags = []string{tag}
+	}
+	if kind == "completed_items" {
+		done := true
+		query.Done = &done
+	}
+	col, _, err := userStore(r).Find(query)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Completions get IDs of their own, so items completed again
+	// trigger again.
+	items := []triggerItem{}
+	for _, item := range col {
+		id, t := strconv.FormatInt(item.ID, 10), item.Created
+		if kind == "completed_items" {
+			id, t = fmt.Sprintf("%d-%d", item.ID, item.CompletedAt.Unix()), item.CompletedAt
+		}
+		if t.After(since) {
+			items = append(items, newTriggerItem(id, t, item))
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Meta.Timestamp > items[j].Meta.Timestamp })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	if ifttt {
+		writeJSON(w, r, map[string]interface{}{"data": items})
+		return
+	}
+	for i := range items {
+		items[i].Meta = nil
+	}
+	writeJSON(w, r, items)
+}
+
+// actionFields are the fields actions take, given as JSON object or
+// form.
+type actionFields struct {
+	ID    json.Number `json:"id"`
+	Body  string      `json:"body"`
+	Notes string      `json:"notes"`
+	Tags  string      `json:"tags"`
+	Due   string      `json:"due"`
+}
+
+// action serves the actions actions/add_item, adding an item with the
+// body, parsed by quickadd, notes, tags separated by spaces or commas
+// and due date described like "tomorrow 3pm", and actions/complete_item,
+// completing the item identified by "id". The fields may be given as
+// form, as JSON object or, as IFTTT sends them, in "actionFields".
+// The response is the item as polling triggers return it, or for IFTTT
+// its envelope with the item ID.
+func action(w http.ResponseWriter, r *http.Request) {
+	kind := strings.TrimPrefix(r.URL.Path, todow.APIPath+"actions/")
+	if kind != "add_item" && kind != "complete_item" || r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var fields actionFields
+	ifttt := false
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		p, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			httpError(w, r, fmt.Sprintf("unable to read request body: %s", err), http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			actionFields
+			ActionFields *actionFields `json:"actionFields"`
+		}
+		if err := json.Unmarshal(p, &req); err != nil {
+			httpError(w, r, fmt.Sprintf("unable to decode action: %s", err), http.StatusBadRequest)
+			return
+		}
+		fields = req.actionFields
+		if req.ActionFields != nil {
+			fields, ifttt = *req.ActionFields, true
+		}
+	} else {
+		fields = actionFields{
+			ID:    json.Number(r.FormValue("id")),
+			Body:  r.FormValue("body"),
+			Notes: r.FormValue("notes"),
+			Tags:  r.FormValue("tags"),
+			Due:   r.FormValue("due"),
+		}
+	}
+
+	var item *todow.Item
+	var ok bool
+	if kind == "add_item" {
+		item, ok = addActionItem(w, r, fields)
+	} else {
+		item, ok = completeActionItem(w, r, fields)
+	}
+	if !ok {
+		return
+	}
+
+	if ifttt {
+		writeJSON(w, r, map[string]interface{}{"data": []map[string]string{{"id": strconv.FormatInt(item.ID, 10)}}})
+		return
+	}
+	res := newTriggerItem(strconv.FormatInt(item.ID, 10), time.Now(), item)
+	res.Meta = nil
+	writeJSON(w, r, res)
+}
+
+// addActionItem adds the item described by fields and returns it, or
+// responds with an error and returns false.
+func addActionItem(w http.ResponseWriter, r *http.Request, fields actionFields) (*todow.Item, bool) {
+	item := quickadd.Parse(fields.Body, time.Now())
+	if item.Body == "" {
+		httpError(w, r, "item body must not be empty", http.StatusBadRequest)
+		return nil, false
+	}
+	item.Notes = fields.Notes
+	item.Created = time.Now()
+	for _, t := range strings.FieldsFunc(fields.Tags, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if t = strings.TrimPrefix(t, todow.TagPrefix); t != "" && !item.HasTag(t) {
+			item.Tags = append(item.Tags, t)
+		}
+	}
+	if fields.Due != "" {
+		due, err := time.ParseInLocation(todow.DueFormat, fields.Due, time.Local)
+		if err != nil {
+			due, err = quickadd.ParseWhen(fields.Due, time.Now())
+		}
+		if err != nil {
+			httpError(w, r, fmt.Sprintf("unable to parse due date %q", fields.Due), http.StatusBadRequest)
+			return nil, false
+		}
+		item.Due = due
+	}
+	setDefaultReminder(userName(r), &item)
+
+	if err := userStore(r).Add(&item); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	recordCreated(r, item.ID)
+	publishItem(r, eventAdded, item.ID)
+	return &item, true
+}
+
+// completeActionItem completes the item identified by fields and returns
+// it, or responds with an error and returns false. Blocked items are
+// only completed if the "force" query parameter is true.
+func completeActionItem(w http.ResponseWriter, r *http.Request, fields actionFields) (*todow.Item, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(fields.ID.String(), "#"), 10, 64)
+	if err != nil {
+		httpError(w, r, fmt.Sprintf("invalid item id %q", fields.ID), http.StatusBadRequest)
+		return nil, false
+	}
+	if !checkBlocked(w, r, []int64{id}) {
+		return nil, false
+	}
+
+	var item *todow.Item
+	changes := map[int64][]store.Change{}
+	err = userStore(r).Update(id, recording(func(i *todow.Item) {
+		i.SetDone(true)
+		c := *i
+		item = &c
+	}, changes), nil)
+	switch err.(type) {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+		return nil, false
+	case error:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	recordChanges(r, changes)
+	publishItem(r, eventCompleted, id)
+	return item, true
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/j1436go/todow/store"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+var (
+	usersFile = flags.String("users", "todow.htpasswd", "File of user accounts, one NAME:BCRYPT-HASH per line as written by adduser")
+
+	// accounts maps user names to bcrypt hashes of their passwords.
+	// Once the server runs, accountsMu guards it.
+	accounts   = map[string][]byte{}
+	accountsMu sync.RWMutex
+
+	// stores maps user names to the stores of their items,
+	// the default user having the empty name.
+	stores = map[string]store.Store{}
+)
+
+// loadAccounts reads the users file at path. Empty lines and
+// lines starting with # are ignored.
+func loadAccounts(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	accts := map[string][]byte{}
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		i := strings.Index(l, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected NAME:HASH", line)
+		}
+		name, hash := l[:i], []byte(l[i+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("line %d: password of %s is not bcrypt hashed", line, name)
+		}
+		if _, ok := accts[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate user %s", line, name)
+		}
+		accts[name] = hash
+	}
+	return accts, s.Err()
+}
+
+// saveAccounts atomically replaces the users file at path with accts.
+func saveAccounts(path string, accts map[string][]byte) error {
+	names := make([]string, 0, len(accts))
+	for name := range accts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s:%s\n", name, accts[name])
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// writeFileAtomic replaces the file at path with p, so that readers
+// see either the old or the new content.
+func writeFileAtomic(path string, p []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(p); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// addUser adds the named user to the users file or changes their
+// password. The password is read from the terminal, or from the
+// first line of stdin if it isn't one.
+func addUser(name string) error {
+	if strings.ContainsAny(name, ":\n") || name == "" {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+
+	accts, err := loadAccounts(*usersFile)
+	if os.IsNotExist(err) {
+		accts = map[string][]byte{}
+	} else if err != nil {
+		return err
+	}
+
+	var pw []byte
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+		pw, err = term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+	} else {
+		var line string
+		line, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if line != "" {
+			err = nil
+		}
+		pw = []byte(strings.TrimRight(line, "\r\n"))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read password: %s", err)
+	}
+	if len(pw) == 0 {
+		return fmt.Errorf("empty password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(pw, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	accts[name] = hash
+
+	return saveAccounts(*usersFile, accts)
+}
+
+// firstRunAccounts creates the users file with an account for the
+// default user, whose password is generated and logged once.
+func firstRunAccounts() (map[string][]byte, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	pw := base64.RawURLEncoding.EncodeToString(b)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	accts := map[string][]byte{*user: hash}
+	if err := saveAccounts(*usersFile, accts); err != nil {
+		return nil, err
+	}
+
+	slog.Warn("created users file, the password of the default user is only shown this once", "file", *usersFile, "user", *user, "password", pw)
+	return accts, nil
+}
+
+// openUserStores opens the stores of all users.
+func openUserStores() error {
+	for name := range accounts {
+		if name == *user {
+			stores[""] = db
+			continue
+		}
+		s, err := db.User(name)
+		if err != nil {
+			return err
+		}
+		stores[name] = s
+	}
+	return nil
+}
+
+// authenticate returns the name of the user identified by u and p,
+// "" for the default user. p is their password or an API token.
+func authenticate(u, p string) (string, bool) {
+	accountsMu.RLock()
+	hash, ok := accounts[u]
+	accountsMu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	if strings.HasPrefix(p, tokenPrefix) {
+		ok = validToken(internalName(u), p)
+	} else {
+		ok = bcrypt.CompareHashAndPassword(hash, []byte(p)) == nil
+	}
+	return internalName(u), ok
+}
+
+// userName returns the name of the user who sent r.
+func userName(r *http.Request) string {
+	name, _ := r.Context().Value(userKey).(string)
+	return name
+}
+
+// userStore returns the store of the user who sent r,
+// or of the shared list selected by r.
+// Its operations are bound to storeContext(r).
+func userStore(r *http.Request) store.Store {
+	if l, ok := requestList(r); ok {
+		return listStore(l).WithContext(storeContext(r))
+	}
+	return stores[userName(r)].WithContext(storeContext(r))
+}
+
+// withStoreTimeout gives the storage operations of the requests h
+// handles a deadline of -store-timeout. Unlike a deadline of the
+// request, it doesn't cut short streaming responses.
+func withStoreTimeout(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *storeTimeout <= 0 {
+			h(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), *storeTimeout)
+		defer cancel()
+		h(w, r.WithContext(context.WithValue(r.Context(), storeCtxKey, ctx)))
+	}
+}
+
+// storeContext returns the context the storage operations of r are
+// bound to, which is done once the client goes away or -store-timeout
+// has passed.
+func storeContext(r *http.Request) context.Context {
+	if ctx, ok := r.Context().Value(storeCtxKey).(context.Context); ok {
+		return ctx
+	}
+	return r.Context()
+}
+
+func withUser(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey, name))
+}
+
+// adminOnly restricts h to the default user, as it affects
+// the items of all users.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if userName(r) != "" {
+			httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
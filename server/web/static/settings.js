@@ -0,0 +1,157 @@
+// The settings page changes the settings with the settings endpoints.
+(function() {
+	var messages = JSON.parse(document.body.dataset.messages);
+	var status = document.querySelector(".status");
+
+	// t returns the message key with its %s replaced by the other arguments.
+	function t(key) {
+		var args = arguments, i = 0;
+		return messages[key].replace(/%s/g, function() {
+			return args[++i];
+		});
+	}
+
+	// api sends body as JSON to the settings endpoint below path and calls
+	// onload with the response if it succeeded, showing the error if not.
+	function api(method, path, body, onload) {
+		var xhr = new XMLHttpRequest();
+		xhr.addEventListener("load", function() {
+			if (xhr.status === 200 || xhr.status === 201) {
+				onload(xhr.responseText);
+				return;
+			}
+			show(t("settingsFailed", xhr.responseText.trim()));
+		});
+		xhr.open(method, "/api/settings"+path);
+		xhr.setRequestHeader("X-CSRF-Token", document.body.dataset.csrf);
+		if (body) {
+			xhr.setRequestHeader("Content-Type", "application/json");
+		}
+		xhr.send(body ? JSON.stringify(body) : null);
+	}
+
+	function show(text) {
+		status.textContent = text;
+		status.hidden = false;
+	}
+
+	// on calls fn with the form of class name when it is submitted.
+	function on(name, fn) {
+		var form = document.querySelector("."+name);
+		if (!form) {
+			return;
+		}
+		form.addEventListener("submit", function(e) {
+			e.preventDefault();
+			fn(form);
+		});
+	}
+
+	// After a password change, the browser asks for the new one.
+	on("password-form", function(form) {
+		if (form.new.value !== form.repeat.value) {
+			show(t("passwordMismatch"));
+			return;
+		}
+		api("POST", "/password", {Current: form.current.value, New: form.new.value}, function() {
+			alert(t("passwordChanged"));
+			location.reload();
+		});
+	});
+
+	// New tokens are shown only once, and listed after a reload.
+	on("token-form", function(form) {
+		api("POST", "/tokens", {Name: form.elements["name"].value}, function(resp) {
+			var p = document.querySelector(".new-token");
+			p.querySelector("code").textContent = JSON.parse(resp).Token;
+			p.hidden = false;
+			form.reset();
+		});
+	});
+
+	document.querySelectorAll(".tokens .revoke").forEach(function(button) {
+		button.addEventListener("click", function() {
+			var row = button.closest("tr");
+			api("DELETE", "/tokens/"+row.dataset.id, null, function() {
+				row.remove();
+				show(t("tokenRevoked"));
+			});
+		});
+	});
+
+	on("push-form", function(form) {
+		api("POST", "/push", {Topic: form.topic.value.trim(), Server: form.server.value.trim()}, function() {
+			location.reload();
+		});
+	});
+
+	document.querySelectorAll(".push .revoke").forEach(function(button) {
+		button.addEventListener("click", function() {
+			var row = button.closest("tr");
+			api("DELETE", "/push/"+row.dataset.id, null, function() {
+				row.remove();
+				show(t("unsubscribed"));
+			});
+		});
+	});
+
+	var disconnect = document.querySelector(".google-disconnect");
+	if (disconnect) {
+		disconnect.addEventListener("click", function() {
+			api("DELETE", "/google", null, function() {
+				location.reload();
+			});
+		});
+	}
+
+	on("reminder-form", function(form) {
+		api("PATCH", "", {Snooze: form.snooze.value.trim(), RemindBefore: form.remindBefore.value.trim()}, function() {
+			show(t("saved"));
+		});
+	});
+
+	// Clearing both fields opts out of digests.
+	on("digest-form", function(form) {
+		api("PATCH", "", {Digest: {To: form.to.value.trim(), Schedule: form.schedule.value.trim()}}, function() {
+			show(t("saved"));
+		});
+	});
+
+	// Filters are given one per line as NAME: EXPRESSION.
+	on("filter-form", function(form) {
+		var filters = [];
+		form.filters.value.split("\n").forEach(function(line) {
+			if (line.trim() === "") {
+				return;
+			}
+			var i = line.indexOf(":");
+			if (i < 0) {
+				filters.push({Name: line.trim(), Expr: ""});
+				return;
+			}
+			filters.push({Name: line.slice(0, i).trim(), Expr: line.slice(i+1).trim()});
+		});
+		api("PATCH", "", {Filters: filters}, function() {
+			show(t("saved"));
+		});
+	});
+
+	// The secrets of new webhooks follow the first line of the response,
+	// and are shown only once.
+	on("webhook-form", function(form) {
+		var urls = form.webhooks.value.split("\n").map(function(u) {
+			return u.trim();
+		}).filter(function(u) {
+			return u !== "";
+		});
+		api("PATCH", "", {Webhooks: urls}, function(resp) {
+			var secrets = resp.trim().split("\n").slice(1);
+			if (secrets.length > 0) {
+				var div = document.querySelector(".new-webhook-secrets");
+				div.querySelector("pre").textContent = secrets.join("\n");
+				div.hidden = false;
+			}
+			show(t("saved"));
+		});
+	});
+})();
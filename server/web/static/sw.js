@@ -0,0 +1,298 @@
+// Service worker of the web UI, served as /sw.js to control all pages.
+// It keeps the pages and assets last fetched for offline use, and queues
+// changes made offline until the server can be reached again.
+
+var CACHE = "todow-v1";
+var SHELL = [
+	"/",
+	"/static/todow.css",
+	"/static/todow.js",
+	"/static/theme.js",
+	"/static/icon.svg",
+	"/static/manifest.webmanifest"
+];
+
+self.addEventListener("install", function(e) {
+	e.waitUntil(caches.open(CACHE).then(function(cache) {
+		// The index needs a login, which may be missing yet.
+		return cache.addAll(SHELL).catch(function() {});
+	}).then(function() {
+		return self.skipWaiting();
+	}));
+});
+
+self.addEventListener("activate", function(e) {
+	e.waitUntil(caches.keys().then(function(keys) {
+		return Promise.all(keys.filter(function(k) {
+			return k !== CACHE;
+		}).map(function(k) {
+			return caches.delete(k);
+		}));
+	}).then(function() {
+		return self.clients.claim();
+	}));
+});
+
+self.addEventListener("fetch", function(e) {
+	var req = e.request;
+	var url = new URL(req.url);
+	if (url.origin !== location.origin) {
+		return;
+	}
+
+	if (req.method === "GET") {
+		if (url.pathname.indexOf("/static/") === 0) {
+			e.respondWith(staleWhileRevalidate(req));
+		} else if (req.mode === "navigate") {
+			e.respondWith(networkFirst(req));
+		}
+		return;
+	}
+	if (url.pathname.indexOf("/api/") === 0) {
+		e.respondWith(sendOrQueue(req));
+	}
+});
+
+self.addEventListener("message", function(e) {
+	if (e.data && e.data.type === "sync") {
+		e.waitUntil(flush());
+	}
+});
+
+self.addEventListener("sync", function(e) {
+	if (e.tag === "todow-queue") {
+		e.waitUntil(flush());
+	}
+});
+
+// networkFirst loads pages from the server, and from the cache if it
+// can't be reached. Pages never loaded before fall back to the index.
+function networkFirst(req) {
+	return fetch(req).then(function(resp) {
+		if (resp.ok) {
+			var copy = resp.clone();
+			caches.open(CACHE).then(function(cache) {
+				cache.put(req, copy);
+			});
+		}
+		return resp;
+	}).catch(function() {
+		return caches.match(req).then(function(resp) {
+			return resp || caches.match("/");
+		});
+	});
+}
+
+// staleWhileRevalidate answers from the cache, if possible, and updates
+// it in the background so changed assets show on the next load.
+function staleWhileRevalidate(req) {
+	var update = fetch(req).then(function(resp) {
+		if (resp.ok) {
+			var copy = resp.clone();
+			caches.open(CACHE).then(function(cache) {
+				cache.put(req, copy);
+			});
+		}
+		return resp;
+	});
+	return caches.match(req).then(function(resp) {
+		if (resp) {
+			update.catch(function() {});
+			return resp;
+		}
+		return update;
+	});
+}
+
+// sendOrQueue sends a change to the server, or queues it if the server
+// can't be reached. Queued changes are answered with 202 Accepted and
+// the X-Todow-Queued header, forms by going back to their page.
+function sendOrQueue(req) {
+	var copy = req.clone();
+	return fetch(req).catch(function() {
+		return copy.text().then(function(body) {
+			return enqueue({
+				method: copy.method,
+				url: copy.url,
+				headers: {
+					"Content-Type": copy.headers.get("Content-Type") || "",
+					"X-CSRF-Token": copy.headers.get("X-CSRF-Token") || "",
+					"Idempotency-Key": copy.headers.get("Idempotency-Key") || ""
+				},
+				body: body
+			});
+		}).then(function() {
+			if (self.registration.sync) {
+				self.registration.sync.register("todow-queue").catch(function() {});
+			}
+			if (copy.mode === "navigate") {
+				return Response.redirect(copy.referrer || "/", 303);
+			}
+			return new Response("Queued until the server can be reached\n", {
+				status: 202,
+				headers: {"X-Todow-Queued": "true"}
+			});
+		});
+	});
+}
+
+var flushing = null;
+
+// flush sends the queued changes in order, stopping at the first one
+// the server can't be reached for or fails at, to try again later.
+// Changes the server rejects as invalid are dropped, as they won't
+// succeed later either. The CSRF token a change was queued with is
+// stale once the server restarted, so a fresh one is fetched if it is
+// refused. Pages are told to reload afterwards.
+function flush() {
+	if (flushing) {
+		return flushing;
+	}
+
+	var sent = 0, rejected = 0, token = null;
+	flushing = queued().then(function(entries) {
+		return entries.reduce(function(p, entry) {
+			return p.then(function() {
+				return replay(entry.value, token).then(function(resp) {
+					if (resp.status !== 403 || token) {
+						return resp;
+					}
+					return csrfToken().then(function(t) {
+						token = t;
+						return replay(entry.value, token);
+					});
+				}).then(function(resp) {
+					if (!resp.ok && !invalid(resp.status)) {
+						throw new Error("unable to replay change: "+resp.status);
+					}
+					sent++;
+					if (!resp.ok) {
+						rejected++;
+					}
+					return dequeue(entry.key);
+				});
+			});
+		}, Promise.resolve());
+	}).catch(function() {
+		// Still offline or failing, try again later.
+	}).then(function() {
+		flushing = null;
+		if (sent === 0) {
+			return;
+		}
+		return self.clients.matchAll().then(function(clients) {
+			clients.forEach(function(c) {
+				c.postMessage({type: "synced", sent: sent, rejected: rejected});
+			});
+		});
+	});
+	return flushing;
+}
+
+// replay sends the queued change r, with token as its CSRF token if set.
+function replay(r, token) {
+	var headers = Object.assign({}, r.headers);
+	if (token) {
+		headers["X-CSRF-Token"] = token;
+	}
+	return fetch(r.url, {
+		method: r.method,
+		headers: headers,
+		body: r.body || undefined,
+		credentials: "same-origin"
+	});
+}
+
+// invalid reports whether a change answered with status is invalid,
+// rather than refused for now, like a request missing the login or
+// sent too often.
+function invalid(status) {
+	switch (status) {
+	case 401:
+	case 408:
+	case 429:
+		return false;
+	}
+	return status >= 400 && status < 500;
+}
+
+// csrfToken resolves to the CSRF token of the index page.
+function csrfToken() {
+	return fetch("/", {credentials: "same-origin"}).then(function(resp) {
+		if (!resp.ok) {
+			throw new Error("unable to load index: "+resp.status);
+		}
+		return resp.text();
+	}).then(function(html) {
+		var m = /data-csrf="([^"]*)"/.exec(html);
+		if (!m) {
+			throw new Error("index without CSRF token");
+		}
+		return m[1];
+	});
+}
+
+// The queue of changes is kept in IndexedDB, which outlives the worker.
+
+function openQueue() {
+	return new Promise(function(resolve, reject) {
+		var open = indexedDB.open("todow", 1);
+		open.onupgradeneeded = function() {
+			open.result.createObjectStore("queue", {autoIncrement: true});
+		};
+		open.onsuccess = function() {
+			resolve(open.result);
+		};
+		open.onerror = function() {
+			reject(open.error);
+		};
+	});
+}
+
+// inQueue runs fn in a transaction on the queue store and resolves
+// to the result of the request fn returns, if any.
+function inQueue(mode, fn) {
+	return openQueue().then(function(db) {
+		return new Promise(function(resolve, reject) {
+			var tx = db.transaction("queue", mode);
+			var result;
+			fn(tx.objectStore("queue"), function(v) {
+				result = v;
+			});
+			tx.oncomplete = function() {
+				resolve(result);
+			};
+			tx.onerror = function() {
+				reject(tx.error);
+			};
+		});
+	});
+}
+
+function enqueue(r) {
+	return inQueue("readwrite", function(store) {
+		store.add(r);
+	});
+}
+
+function dequeue(key) {
+	return inQueue("readwrite", function(store) {
+		store.delete(key);
+	});
+}
+
+// queued resolves to the queued changes in order, as {key, value}.
+function queued() {
+	return inQueue("readonly", function(store, done) {
+		var entries = [];
+		store.openCursor().onsuccess = function(e) {
+			var cursor = e.target.result;
+			if (!cursor) {
+				done(entries);
+				return;
+			}
+			entries.push({key: cursor.key, value: cursor.value});
+			cursor.continue();
+		};
+	});
+}
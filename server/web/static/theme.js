@@ -0,0 +1,21 @@
+// theme applies the theme chosen with the toggle, if any, before the
+// page is drawn. Without a choice, the browser's preference is used.
+(function() {
+	var root = document.documentElement;
+	var theme = localStorage.getItem("todow-theme");
+	if (theme) {
+		root.dataset.theme = theme;
+	}
+
+	document.addEventListener("DOMContentLoaded", function() {
+		var toggle = document.querySelector(".theme-toggle");
+		if (!toggle) {
+			return;
+		}
+		toggle.addEventListener("click", function() {
+			var dark = root.dataset.theme ? root.dataset.theme === "dark" : matchMedia("(prefers-color-scheme: dark)").matches;
+			root.dataset.theme = dark ? "light" : "dark";
+			localStorage.setItem("todow-theme", root.dataset.theme);
+		});
+	});
+})();
@@ -0,0 +1,602 @@
+// The strings of the page in its language, see t.
+var messages = JSON.parse(document.body.dataset.messages);
+
+var items;
+bindItems();
+
+// t returns the message key with its %s replaced by the other arguments.
+function t(key) {
+	var args = arguments, i = 0;
+	return messages[key].replace(/%s/g, function() {
+		return args[++i];
+	});
+}
+
+// bindItems binds the buttons of the items on the page.
+function bindItems() {
+	items = document.querySelectorAll(".item");
+
+	for (var i = items.length-1; i >= 0; i--) {
+		var item = items[i];
+
+		bindRemove(item, item.querySelector(".rm-trigger"));
+		bindDone(item, item.querySelector(".done-trigger"));
+		bindDue(item, item.querySelector(".due input"));
+		bindPin(item, item.querySelector(".pin-trigger"));
+		bindHistory(item, item.querySelector(".history"));
+		bindChecklist(item, item.querySelector(".checklist"));
+		item.querySelector(".select input").addEventListener("change", updateBulk);
+	}
+}
+
+// send sends a request changing the item and calls onload when it
+// succeeded, with true if the change was queued while offline.
+function send(method, item, body, onload) {
+	var id = item.getAttribute("data-id");
+	var list = document.body.dataset.list;
+	var xhr = new XMLHttpRequest();
+
+	xhr.addEventListener("load", function(e) {
+		if (xhr.status === 200 || xhr.status === 202) {
+			onload(xhr.getResponseHeader("X-Todow-Queued") === "true");
+			return;
+		}
+
+		// Completing a blocked item is refused with the blockers.
+		alert(xhr.status === 409 ? t("changeRefused", id, xhr.responseText.trim()) : t("changeFailed", id));
+		console.log(xhr);
+		console.log(e);
+	});
+
+	xhr.open(method, "/api/"+id+(list ? "?list="+encodeURIComponent(list) : ""));
+	xhr.setRequestHeader("X-CSRF-Token", document.body.dataset.csrf);
+	if (body) {
+		xhr.setRequestHeader("Content-Type", "application/json");
+	}
+	xhr.send(body);
+}
+
+function bindRemove(item, trigger) {
+	trigger.addEventListener("click", function(e) {
+		removeLater([item], t("removed", item.getAttribute("data-id")));
+	});
+}
+
+// bindDone completes or reopens the item. The page is reloaded, as the
+// item moves to another tab, unless the change was queued while offline.
+function bindDone(item, trigger) {
+	trigger.addEventListener("click", function(e) {
+		trigger.disabled = true;
+		// An empty patch completes the item.
+		var body = item.getAttribute("data-done") === "true" ? JSON.stringify({Done: false}) : null;
+		send("PATCH", item, body, function(queued) {
+			if (!queued) {
+				location.reload();
+				return;
+			}
+			setDone(item, item.getAttribute("data-done") !== "true");
+			trigger.disabled = false;
+		});
+	});
+}
+
+// bindPin pins or unpins the item. The items are fetched again,
+// as pinned ones are listed first.
+function bindPin(item, trigger) {
+	trigger.addEventListener("click", function() {
+		var pinned = trigger.getAttribute("aria-pressed") !== "true";
+		send("PATCH", item, JSON.stringify({Pinned: pinned}), function(queued) {
+			trigger.setAttribute("aria-pressed", pinned);
+			trigger.textContent = pinned ? t("unpin") : t("pin");
+			if (!queued) {
+				refresh();
+			}
+		});
+	});
+}
+
+// bindDue sets the due time of the item to the one picked with input,
+// or clears it if the input is cleared. Times are those of the browser,
+// the server shows them in its own time zone. The items are fetched
+// again, as the item may be overdue now or sorted elsewhere.
+function bindDue(item, input) {
+	input.addEventListener("change", function() {
+		// The zero time clears the due time.
+		var due = input.value ? new Date(input.value).toISOString() : "0001-01-01T00:00:00Z";
+		send("PATCH", item, JSON.stringify({Due: due}), function(queued) {
+			if (!queued) {
+				refresh();
+			}
+		});
+	});
+}
+
+// bindChecklist sends the whole checklist of item, if it has one,
+// whenever one of its entries is ticked or unticked.
+function bindChecklist(item, ul) {
+	if (!ul) {
+		return;
+	}
+	var boxes = ul.querySelectorAll("input");
+	for (var i = 0; i < boxes.length; i++) {
+		boxes[i].addEventListener("change", function() {
+			var list = [];
+			for (var j = 0; j < boxes.length; j++) {
+				list.push({Text: boxes[j].parentNode.querySelector("span").textContent, Done: boxes[j].checked});
+			}
+			send("PATCH", item, JSON.stringify({Checklist: list}), function() {});
+		});
+	}
+}
+
+// bindHistory fetches the history of item when details is first opened.
+function bindHistory(item, details) {
+	details.addEventListener("toggle", function() {
+		if (!details.open || details.dataset.loaded) {
+			return;
+		}
+		var ol = details.querySelector("ol");
+		var list = document.body.dataset.list;
+		var xhr = new XMLHttpRequest();
+
+		xhr.addEventListener("load", function() {
+			if (xhr.status !== 200) {
+				ol.textContent = t("historyFailed", xhr.responseText.trim());
+				return;
+			}
+			details.dataset.loaded = "true";
+			var changes = JSON.parse(xhr.responseText);
+			if (changes.length === 0) {
+				ol.textContent = t("historyEmpty");
+				return;
+			}
+			changes.forEach(function(c) {
+				var li = document.createElement("li");
+				var time = document.createElement("time");
+				time.dateTime = c.Time;
+				time.textContent = formatTime(c.Time);
+				li.appendChild(time);
+				li.appendChild(document.createTextNode(" "+describeChange(c)));
+				ol.appendChild(li);
+			});
+		});
+
+		xhr.open("GET", "/api/"+item.getAttribute("data-id")+"/history"+(list ? "?list="+encodeURIComponent(list) : ""));
+		xhr.send();
+	});
+}
+
+// describeChange describes a change of the history of an item.
+function describeChange(c) {
+	var text;
+	switch (c.Kind) {
+	case "edited":
+		text = t("historyEdited", t(c.Field), c.Old || t("none"), c.New || t("none"));
+		break;
+	case "due":
+		text = t("historyDue", c.Old ? formatTime(c.Old) : t("none"), c.New ? formatTime(c.New) : t("none"));
+		break;
+	default:
+		var key = {created: "historyCreated", completed: "historyCompleted", reopened: "historyReopened"}[c.Kind];
+		text = key ? t(key) : c.Kind;
+	}
+	return c.User ? t("historyBy", text, c.User) : text;
+}
+
+// formatTime formats the RFC 3339 time s in the language of the page.
+function formatTime(s) {
+	return new Date(s).toLocaleString(document.documentElement.lang);
+}
+
+// setDone shows item as done or not, for changes queued while offline.
+function setDone(item, done) {
+	item.setAttribute("data-done", done);
+	item.classList.toggle("done", done);
+	item.querySelector(".done-trigger").textContent = done ? t("reopen") : t("complete");
+}
+
+var bulkBar = document.querySelector(".bulk");
+var selectAll = document.querySelector(".select-all");
+
+// selectAll selects or deselects all items the search shows.
+selectAll.addEventListener("change", function() {
+	for (var i = 0; i < items.length; i++) {
+		if (!items[i].hidden && items[i].isConnected) {
+			items[i].querySelector(".select input").checked = selectAll.checked;
+		}
+	}
+	updateBulk();
+});
+
+bulkBar.querySelector(".bulk-complete").addEventListener("click", function() {
+	sendBulk({Patch: {Done: true}});
+});
+
+bulkBar.querySelector(".bulk-remove").addEventListener("click", function() {
+	var selected = selectedItems();
+	removeLater(selected, t("removedSelected", selected.length));
+});
+
+// selectedItems returns the items on the page whose checkboxes are checked.
+function selectedItems() {
+	return Array.prototype.filter.call(items, function(item) {
+		return item.isConnected && item.querySelector(".select input").checked;
+	});
+}
+
+// updateBulk shows the bulk actions while items are selected.
+function updateBulk() {
+	var n = selectedItems().length;
+	bulkBar.hidden = n === 0;
+	bulkBar.querySelector(".selected").textContent = t("selected", n);
+	if (n === 0) {
+		selectAll.checked = false;
+	}
+}
+
+// sendBulk patches the selected items with one request to the bulk
+// endpoint, bulk being the request without the item IDs. The items are
+// fetched again afterwards, unless the change was queued while offline,
+// which is shown right away.
+function sendBulk(bulk) {
+	var selected = selectedItems();
+	bulk.IDs = selected.map(function(item) {
+		return parseInt(item.getAttribute("data-id"), 10);
+	});
+	var buttons = bulkBar.querySelectorAll("button");
+	buttons.forEach(function(b) {
+		b.disabled = true;
+	});
+
+	var list = document.body.dataset.list;
+	var xhr = new XMLHttpRequest();
+	xhr.addEventListener("loadend", function() {
+		buttons.forEach(function(b) {
+			b.disabled = false;
+		});
+	});
+	xhr.addEventListener("load", function() {
+		if (xhr.getResponseHeader("X-Todow-Queued") === "true") {
+			selected.forEach(function(item) {
+				item.querySelector(".select input").checked = false;
+				setDone(item, bulk.Patch.Done);
+			});
+			updateBulk();
+			return;
+		}
+		if (xhr.status !== 200) {
+			alert(t("bulkFailed", xhr.responseText.trim()));
+			return;
+		}
+		selected.forEach(function(item) {
+			item.querySelector(".select input").checked = false;
+		});
+		refresh();
+	});
+	xhr.open("POST", "/api/bulk"+(list ? "?list="+encodeURIComponent(list) : ""));
+	xhr.setRequestHeader("X-CSRF-Token", document.body.dataset.csrf);
+	xhr.setRequestHeader("Content-Type", "application/json");
+	xhr.send(JSON.stringify(bulk));
+}
+
+// The service worker keeps the page usable offline and queues changes,
+// which it sends once the server can be reached again.
+if ("serviceWorker" in navigator) {
+	navigator.serviceWorker.register("/sw.js");
+
+	var status = document.querySelector(".offline");
+	function showStatus() {
+		status.hidden = navigator.onLine;
+	}
+	function sync() {
+		showStatus();
+		navigator.serviceWorker.ready.then(function(reg) {
+			reg.active.postMessage({type: "sync"});
+		});
+	}
+	window.addEventListener("online", sync);
+	window.addEventListener("offline", showStatus);
+	sync();
+
+	navigator.serviceWorker.addEventListener("message", function(e) {
+		if (e.data.type !== "synced") {
+			return;
+		}
+		if (e.data.rejected > 0) {
+			alert(t("offlineRejected", e.data.rejected, e.data.sent));
+		}
+		location.reload();
+	});
+}
+
+// Lists with more items than searchLocalMax are searched by the server.
+var searchLocalMax = 300;
+
+var search = document.querySelector(".search");
+var searchTimer;
+search.addEventListener("input", function() {
+	clearTimeout(searchTimer);
+	searchTimer = setTimeout(filterItems, 150);
+});
+
+// filterItems shows only the items matching all words of the search,
+// with the matches highlighted.
+function filterItems() {
+	var words = search.value.toLowerCase().split(/\s+/).filter(function(w) {
+		return w !== "";
+	});
+	if (words.length === 0 || items.length <= searchLocalMax) {
+		showItems(words, function(item) {
+			return words.every(function(w) {
+				return itemText(item).indexOf(w) >= 0;
+			});
+		});
+		return;
+	}
+
+	var list = document.body.dataset.list;
+	var q = "q="+encodeURIComponent(search.value)+(list ? "&list="+encodeURIComponent(list) : "");
+	var xhr = new XMLHttpRequest();
+	xhr.addEventListener("load", function() {
+		if (xhr.status !== 200) {
+			console.log(xhr);
+			return;
+		}
+		var ids = {};
+		JSON.parse(xhr.responseText).forEach(function(v) {
+			ids[v.ID] = true;
+		});
+		showItems(words, function(item) {
+			return ids[item.getAttribute("data-id")];
+		});
+	});
+	xhr.open("GET", "/api/search?"+q);
+	xhr.send();
+}
+
+// itemText returns the body and tags of item in lower case.
+function itemText(item) {
+	var text = item.querySelector(".text").textContent;
+	item.querySelectorAll(".tag").forEach(function(tag) {
+		text += " "+tag.textContent;
+	});
+	return text.toLowerCase();
+}
+
+// showItems hides the items match rejects and highlights words
+// in the bodies of the others.
+function showItems(words, match) {
+	for (var i = 0; i < items.length; i++) {
+		var shown = match(items[i]);
+		items[i].hidden = !shown;
+		highlight(items[i].querySelector(".text"), shown ? words : []);
+	}
+}
+
+// highlight marks the occurrences of words in the text of el.
+function highlight(el, words) {
+	if (el.dataset.text === undefined) {
+		el.dataset.text = el.textContent;
+	}
+	var text = el.dataset.text;
+	el.textContent = "";
+
+	var lower = text.toLowerCase();
+	var pos = 0;
+	while (pos < text.length) {
+		var next = -1, len = 0;
+		words.forEach(function(w) {
+			var i = lower.indexOf(w, pos);
+			if (i >= 0 && (next < 0 || i < next || i === next && w.length > len)) {
+				next = i;
+				len = w.length;
+			}
+		});
+		if (next < 0) {
+			break;
+		}
+		el.appendChild(document.createTextNode(text.slice(pos, next)));
+		var mark = document.createElement("mark");
+		mark.textContent = text.slice(next, next+len);
+		el.appendChild(mark);
+		pos = next+len;
+	}
+	el.appendChild(document.createTextNode(text.slice(pos)));
+}
+
+// Removals are sent after undoDelay milliseconds, until then the toast
+// offers to undo them. There is a single pending removal, starting
+// another sends it right away.
+var undoDelay = 10000;
+var toast = document.querySelector(".toast");
+var pending = null;
+
+toast.querySelector(".undo").addEventListener("click", function() {
+	var p = pending;
+	clearTimeout(p.timer);
+	pending = null;
+	toast.hidden = true;
+
+	// Rows replaced by a refresh meanwhile are rendered anew.
+	if (!document.body.contains(p.rows[0].parent)) {
+		refresh();
+		return;
+	}
+	// Backwards, so the rows they were followed by are back already.
+	p.rows.reverse().forEach(function(r) {
+		r.parent.insertBefore(r.row, r.next && r.next.parentNode === r.parent ? r.next : null);
+	});
+});
+
+// Pages left before the delay has passed send their removal at once.
+window.addEventListener("pagehide", sendRemoval);
+
+// removeLater takes items off the page and removes them after undoDelay,
+// showing text in the toast until then.
+function removeLater(rows, text) {
+	sendRemoval();
+	var ids = {};
+	rows = rows.map(function(row) {
+		ids[row.getAttribute("data-id")] = true;
+		row.querySelector(".select input").checked = false;
+		var r = {row: row, parent: row.parentNode, next: row.nextSibling};
+		row.remove();
+		return r;
+	});
+	pending = {ids: ids, rows: rows, timer: setTimeout(sendRemoval, undoDelay)};
+	updateBulk();
+	toast.querySelector(".toast-text").textContent = text;
+	toast.hidden = false;
+}
+
+// sendRemoval removes the items of the pending removal, if any. It uses
+// fetch, whose keepalive requests outlive the page.
+function sendRemoval() {
+	if (!pending) {
+		return;
+	}
+	var ids = Object.keys(pending.ids).map(function(id) {
+		return parseInt(id, 10);
+	});
+	clearTimeout(pending.timer);
+	pending = null;
+	toast.hidden = true;
+
+	var list = document.body.dataset.list;
+	fetch("/api/bulk"+(list ? "?list="+encodeURIComponent(list) : ""), {
+		method: "POST",
+		headers: {
+			"Content-Type": "application/json",
+			"X-CSRF-Token": document.body.dataset.csrf
+		},
+		body: JSON.stringify({IDs: ids, Remove: true}),
+		keepalive: true
+	}).then(function(resp) {
+		if (resp.ok) {
+			return;
+		}
+		return resp.text().then(function(text) {
+			alert(t("removeFailed", text.trim()));
+			refresh();
+		});
+	}).catch(function(err) {
+		console.log(err);
+		refresh();
+	});
+}
+
+// The quick add form is sent in the background, so that an item closely
+// matching open ones, which the server refuses, can be added anyway.
+var quickAdd = document.querySelector(".quick-add");
+quickAdd.addEventListener("submit", function(e) {
+	e.preventDefault();
+	addItem(quickAdd, false);
+});
+
+// addItem posts the quick add form, forcing the item in if force is true.
+// Its Idempotency-Key keeps the item from being added twice if the offline
+// queue replays it.
+function addItem(form, force) {
+	var xhr = new XMLHttpRequest();
+	xhr.addEventListener("load", function() {
+		if (xhr.status === 409 && !force) {
+			if (confirm(t("addDuplicate", xhr.responseText.trim()))) {
+				addItem(form, true);
+			}
+			return;
+		}
+		if (xhr.status >= 400) {
+			alert(t("addFailed", xhr.responseText.trim()));
+			return;
+		}
+		form.reset();
+		if (xhr.getResponseHeader("X-Todow-Queued") !== "true") {
+			refresh();
+		}
+	});
+
+	var action = form.getAttribute("action");
+	if (force) {
+		action += (action.indexOf("?") < 0 ? "?" : "&") + "force=true";
+	}
+	xhr.open("POST", action);
+	xhr.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+	xhr.setRequestHeader("X-CSRF-Token", document.body.dataset.csrf);
+	xhr.setRequestHeader("Idempotency-Key", randomKey());
+	xhr.send(new URLSearchParams(new FormData(form)).toString());
+}
+
+// randomKey returns 128 random bits in hex.
+function randomKey() {
+	var b = new Uint8Array(16);
+	crypto.getRandomValues(b);
+	return Array.prototype.map.call(b, function(x) {
+		return ("0"+x.toString(16)).slice(-2);
+	}).join("");
+}
+
+// Changes made elsewhere, with the CLI or in other browsers, are streamed
+// by the server. The items and tabs are fetched again then, leaving the
+// forms and the search as they are.
+if (window.EventSource) {
+	var listParam = document.body.dataset.list ? "?list="+encodeURIComponent(document.body.dataset.list) : "";
+	var stream = new EventSource("/api/events"+listParam);
+	var refreshTimer;
+	["added", "updated", "completed", "removed"].forEach(function(type) {
+		stream.addEventListener(type, function() {
+			// Bulk changes send many events at once.
+			clearTimeout(refreshTimer);
+			refreshTimer = setTimeout(refresh, 200);
+		});
+	});
+
+	// Events sent while the stream was broken are lost, so the items
+	// are fetched again once it is reconnected.
+	var streamBroken = false;
+	stream.addEventListener("error", function() {
+		streamBroken = true;
+	});
+	stream.addEventListener("open", function() {
+		if (streamBroken) {
+			streamBroken = false;
+			refresh();
+		}
+	});
+}
+
+// refresh replaces the items and tabs with those of the page as the
+// server renders it now. Items stay selected if they are still shown.
+function refresh() {
+	var xhr = new XMLHttpRequest();
+	xhr.addEventListener("load", function() {
+		if (xhr.status !== 200) {
+			console.log(xhr);
+			return;
+		}
+		var selected = {};
+		selectedItems().forEach(function(item) {
+			selected[item.getAttribute("data-id")] = true;
+		});
+
+		var page = new DOMParser().parseFromString(xhr.responseText, "text/html");
+		// Items about to be removed stay hidden.
+		page.querySelectorAll(".item").forEach(function(item) {
+			if (pending && pending.ids[item.getAttribute("data-id")]) {
+				item.remove();
+			}
+		});
+		[".items tbody", ".tabs"].forEach(function(sel) {
+			document.querySelector(sel).replaceWith(page.querySelector(sel));
+		});
+		bindItems();
+		for (var i = 0; i < items.length; i++) {
+			items[i].querySelector(".select input").checked = selected[items[i].getAttribute("data-id")] === true;
+		}
+		updateBulk();
+		if (search.value !== "") {
+			filterItems();
+		}
+	});
+	xhr.open("GET", location.href);
+	xhr.send();
+}
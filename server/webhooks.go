@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// stringList is a flag that may be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+var (
+	webhookURLs    stringList
+	webhookSecret  = flags.String("webhook-secret", "", "Key used to sign the payloads of -webhook webhooks with HMAC-SHA256")
+	webhookRetries = flags.Int("webhook-retries", 5, "Number of retries of failed webhook deliveries")
+)
+
+func init() {
+	flags.Var(&webhookURLs, "webhook", "URL item events are POSTed to as JSON, may be given multiple times")
+}
+
+// webhookEvents are the event types delivered to webhooks.
+var webhookEvents = map[string]bool{
+	eventAdded:     true,
+	eventCompleted: true,
+	eventRemoved:   true,
+}
+
+// dispatchWebhooks delivers the events received from ch to the webhooks
+// given with -webhook, signed with -webhook-secret, and those of the
+// owner of the changed item, signed with their own secrets. It never
+// returns.
+func dispatchWebhooks(ch chan event) {
+	for e := range ch {
+		if !webhookEvents[e.Type] {
+			continue
+		}
+
+		payload, err := json.Marshal(struct {
+			event
+			User string `json:",omitempty"`
+			Time time.Time
+		}{e, e.user, time.Now()})
+		if err != nil {
+			slog.Error("unable to encode event", "item", e.ID, "event", e.Type, "err", err)
+			continue
+		}
+
+		for _, u := range webhookURLs {
+			go deliverWebhook(webhookClient, u, *webhookSecret, payload)
+		}
+		s := settingsOf(e.user)
+		for _, u := range s.Webhooks {
+			go deliverWebhook(userWebhookClient, u, s.WebhookSecrets[u], payload)
+		}
+	}
+}
+
+// webhookClient delivers to the webhooks given with -webhook.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// userWebhookClient delivers to the webhooks and ntfy servers of users,
+// which may only be on public addresses. Otherwise any user could make the server
+// send requests to itself, its network or the metadata service of its
+// cloud.
+var userWebhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// dialPublicOnly refuses connections to addresses that aren't public,
+// after names have been resolved and for every redirect.
+func dialPublicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is a globally routable unicast address.
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// publicHost reports whether host, the host of a URL chosen by a user,
+// isn't localhost or an address that isn't public. Names resolving to
+// such addresses are refused when connecting, see userWebhookClient.
+func publicHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return publicIP(ip)
+	}
+	return !strings.EqualFold(host, "localhost")
+}
+
+// deliverWebhook POSTs payload to url with c, signed with secret unless
+// it is empty, retrying with exponential backoff.
+func deliverWebhook(c *http.Client, url, secret string, payload []byte) {
+	backoff := time.Second
+	for try := 0; ; try++ {
+		err := postWebhook(c, url, secret, payload)
+		if err == nil {
+			return
+		}
+		if try == *webhookRetries {
+			slog.Error("giving up on webhook", "url", url, "err", err)
+			return
+		}
+		slog.Warn("webhook failed, retrying", "url", url, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postWebhook(c *http.Client, url, secret string, payload []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("X-Todow-Signature", "sha256="+sign(payload, secret))
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of payload keyed with secret.
+func sign(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// An AuditEntry records a security relevant event.
+type AuditEntry struct {
+	Time time.Time
+
+	// User is the name of the user acting, if known.
+	User string `json:",omitempty"`
+	IP   string `json:",omitempty"`
+
+	// Action names the event, e.g. "login" or "remove".
+	Action string
+	Detail string `json:",omitempty"`
+
+	RequestID string `json:",omitempty"`
+}
+
+// Auditor is implemented by stores that keep an append-only audit log.
+type Auditor interface {
+	// Audit appends e to the audit log.
+	Audit(e AuditEntry) error
+
+	// AuditLog returns the entries recorded at or after since, oldest
+	// first. If limit is positive, only the newest limit entries
+	// are returned.
+	AuditLog(since time.Time, limit int) ([]AuditEntry, error)
+}
+
+// auditBucket stores audit entries as JSON under big endian sequence
+// numbers, so they are ordered by the time they were added.
+var auditBucket = []byte("audit")
+
+func createAuditBucket(tx *bolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists(auditBucket)
+	return err
+}
+
+func (b *Bolt) Audit(e AuditEntry) error {
+	j, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return b.updateLog(func(tx *bolt.Tx) error {
+		buck := tx.Bucket(auditBucket)
+		seq, err := buck.NextSequence()
+		if err != nil {
+			return err
+		}
+		return buck.Put(itemKey(int64(seq)), j)
+	})
+}
+
+func (b *Bolt) AuditLog(since time.Time, limit int) ([]AuditEntry, error) {
+	log := []AuditEntry{}
+	err := b.view(func(tx *bolt.Tx) error {
+		// Walk backwards to stop at since or once limit entries are found.
+		c := tx.Bucket(auditBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var e AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("audit entry %d seems corrupt: %s", binary.BigEndian.Uint64(k), err)
+			}
+			if e.Time.Before(since) || (limit > 0 && len(log) == limit) {
+				break
+			}
+			log = append(log, e)
+		}
+		return nil
+	})
+
+	for i, j := 0, len(log)-1; i < j; i, j = i+1, j-1 {
+		log[i], log[j] = log[j], log[i]
+	}
+	return log, err
+}
+
+func (db *Postgres) Audit(e AuditEntry) error {
+	j, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(db.context(), `INSERT INTO audit (time, data) VALUES ($1, $2)`, e.Time, j)
+	return err
+}
+
+func (db *Postgres) AuditLog(since time.Time, limit int) ([]AuditEntry, error) {
+	query := `SELECT data FROM audit WHERE time >= $1 ORDER BY id DESC`
+	args := []interface{}{since}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+
+	rows, err := db.QueryContext(db.context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	log := []AuditEntry{}
+	for rows.Next() {
+		var p []byte
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(p, &e); err != nil {
+			return nil, err
+		}
+		log = append(log, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(log)-1; i < j; i, j = i+1, j-1 {
+		log[i], log[j] = log[j], log[i]
+	}
+	return log, nil
+}
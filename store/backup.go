@@ -0,0 +1,116 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/j1436go/todow/todotxt"
+)
+
+// Backuper is implemented by stores that can write
+// a consistent snapshot of themselves while in use.
+type Backuper interface {
+	Backup(w io.Writer) (int64, error)
+}
+
+// Backup writes a snapshot of the bolt database to w.
+func (b *Bolt) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := b.view(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Backup writes the todo.txt file to w.
+func (t *TodoTxt) Backup(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	err := todotxt.Write(cw, t.col)
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Restore replaces the store of the given kind at source with the
+// snapshot read from r. The snapshot is validated before anything is
+// replaced. The store must not be open.
+func Restore(kind, source string, r io.Reader) error {
+	var validate func(path string) error
+	switch strings.ToLower(kind) {
+	case "bolt":
+		validate = validateBolt
+	case "todotxt":
+		validate = validateTodoTxt
+	default:
+		return fmt.Errorf("restoring %s stores is not supported", kind)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(source), ".restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := validate(tmp.Name()); err != nil {
+		return fmt.Errorf("invalid snapshot: %s", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), source)
+}
+
+func validateBolt(path string) error {
+	d, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(itemsBucket) == nil && tx.Bucket(bucketName) == nil {
+			return fmt.Errorf("no items found")
+		}
+		return nil
+	})
+}
+
+func validateTodoTxt(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = todotxt.Read(f)
+	return err
+}
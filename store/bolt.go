@@ -0,0 +1,572 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/j1436go/todow"
+)
+
+// Bolt is a Store backed by a bolt database file.
+type Bolt struct {
+	*boltFile
+
+	// user owns the items of the store, "" being the default user.
+	user string
+
+	// ctx, if not nil, is the context operations are bound to.
+	ctx context.Context
+}
+
+// boltFile is the database file shared by the stores of all users.
+type boltFile struct {
+	path string
+
+	// mu is held exclusively while the database file is swapped.
+	mu sync.RWMutex
+	db *bolt.DB
+
+	// ObserveTx, if set, is called with the duration of every
+	// read-only or writable transaction.
+	ObserveTx func(writable bool, d time.Duration)
+
+	cache itemCache
+}
+
+var (
+	// itemsBucket stores every item as JSON under its big endian ID.
+	itemsBucket = []byte("items")
+
+	// trashBucket stores removed items like itemsBucket.
+	trashBucket = []byte("trash")
+
+	// usersBucket holds a bucket per user but the default one, each
+	// holding the items, index, field index, trash and history buckets
+	// of the user.
+	usersBucket = []byte("users")
+)
+
+// A namespace holds the items, index, field index, trash and history
+// buckets of a user. It is the root of the database for the default
+// user, and a bucket in usersBucket for others.
+type namespace interface {
+	Bucket(name []byte) *bolt.Bucket
+	CreateBucketIfNotExists(name []byte) (*bolt.Bucket, error)
+}
+
+// ns returns the namespace of the store's user, which User has created.
+func (b *Bolt) ns(tx *bolt.Tx) namespace {
+	if b.user == "" {
+		return tx
+	}
+	return tx.Bucket(usersBucket).Bucket([]byte(b.user))
+}
+
+// User returns the store of the named user, creating their buckets
+// if necessary.
+func (b *Bolt) User(name string) (Store, error) {
+	if name == "" {
+		return &Bolt{boltFile: b.boltFile, ctx: b.ctx}, nil
+	}
+	if err := checkUserName(name); err != nil {
+		return nil, err
+	}
+
+	err := b.update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucketIfNotExists(usersBucket)
+		if err != nil {
+			return err
+		}
+		ns, err := users.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{itemsBucket, indexBucket, fieldIndexBucket, trashBucket, historyBucket} {
+			if _, err := ns.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create buckets of user %s: %s", name, err)
+	}
+	return &Bolt{boltFile: b.boltFile, user: name, ctx: b.ctx}, nil
+}
+
+// WithContext returns the store bound to ctx. Transactions check it
+// when they start and while iterating over items; one done when it
+// runs out is rolled back.
+func (b *Bolt) WithContext(ctx context.Context) Store {
+	return &Bolt{boltFile: b.boltFile, user: b.user, ctx: ctx}
+}
+
+// err returns the error of the context of the store, if it is done.
+func (b *Bolt) err() error {
+	if b.ctx == nil {
+		return nil
+	}
+	return b.ctx.Err()
+}
+
+// OpenBolt opens the bolt database at path, creating it if necessary.
+func OpenBolt(path string) (*Bolt, error) {
+	d, err := openBoltFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bolt{boltFile: &boltFile{path: path, db: d}}
+	if err := b.migrate(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func openBoltFile(path string) (*bolt.DB, error) {
+	// Fail instead of blocking forever if another process holds the file.
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+}
+
+// update runs fn in a writable transaction and drops the cached items
+// once it is done.
+func (b *Bolt) update(fn func(*bolt.Tx) error) error {
+	defer b.cache.invalidate()
+	return b.updateLog(fn)
+}
+
+// updateLog is update for transactions writing only the audit log or
+// histories, which leave the cached items valid.
+func (b *Bolt) updateLog(fn func(*bolt.Tx) error) error {
+	if err := b.err(); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	defer b.observe(true, time.Now())
+	return b.db.Update(b.bound(fn))
+}
+
+func (b *Bolt) view(fn func(*bolt.Tx) error) error {
+	if err := b.err(); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	defer b.observe(false, time.Now())
+	return b.db.View(b.bound(fn))
+}
+
+// bound returns fn failing right away if the context of the store
+// was done while waiting for the database, e.g. during a compaction
+// or another writable transaction.
+func (b *Bolt) bound(fn func(*bolt.Tx) error) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := b.err(); err != nil {
+			return err
+		}
+		return fn(tx)
+	}
+}
+
+func (b *Bolt) observe(writable bool, start time.Time) {
+	if b.ObserveTx != nil {
+		b.ObserveTx(writable, time.Since(start))
+	}
+}
+
+func itemKey(id int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id))
+	return k
+}
+
+// putItem stores item in ns and updates the search index.
+func putItem(ns namespace, item *todow.Item) error {
+	j, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("unable to marshal item: %s", err)
+	}
+
+	buck := ns.Bucket(itemsBucket)
+	old, err := getItem(buck, item.ID)
+	if _, ok := err.(ErrNotFound); ok {
+		old = nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := reindex(ns, old, item); err != nil {
+		return err
+	}
+	if err := reindexFields(ns, old, item); err != nil {
+		return err
+	}
+	return buck.Put(itemKey(item.ID), j)
+}
+
+// deleteItem deletes item and its index entries from ns.
+func deleteItem(ns namespace, item *todow.Item) error {
+	if err := reindex(ns, item, nil); err != nil {
+		return err
+	}
+	if err := reindexFields(ns, item, nil); err != nil {
+		return err
+	}
+	return ns.Bucket(itemsBucket).Delete(itemKey(item.ID))
+}
+
+func getItem(buck *bolt.Bucket, id int64) (*todow.Item, error) {
+	p := buck.Get(itemKey(id))
+	if p == nil {
+		return nil, ErrNotFound{}
+	}
+
+	var item todow.Item
+	if err := json.Unmarshal(p, &item); err != nil {
+		return nil, fmt.Errorf("item #%d seems corrupt: %s", id, err)
+	}
+	return &item, nil
+}
+
+// forEachItem calls fn for every item of buck in ID order.
+func forEachItem(buck *bolt.Bucket, fn func(*todow.Item) error) error {
+	c := buck.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var item todow.Item
+		if err := json.Unmarshal(v, &item); err != nil {
+			return fmt.Errorf("item #%d seems corrupt: %s", binary.BigEndian.Uint64(k), err)
+		}
+		if err := fn(&item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachItem is forEachItem giving up once the context of the store
+// is done.
+func (b *Bolt) forEachItem(buck *bolt.Bucket, fn func(*todow.Item) error) error {
+	return forEachItem(buck, func(v *todow.Item) error {
+		if err := b.err(); err != nil {
+			return err
+		}
+		return fn(v)
+	})
+}
+
+func (b *Bolt) Add(item *todow.Item) error {
+	return b.update(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		buck := ns.Bucket(itemsBucket)
+
+		if item.ParentID != 0 && buck.Get(itemKey(item.ParentID)) == nil {
+			return ErrNotFound{}
+		}
+
+		// IDs are never reused, so those of items in the trash stay
+		// taken and histories don't outlive their items.
+		id, err := buck.NextSequence()
+		if err != nil {
+			return err
+		}
+		item.ID = int64(id)
+
+		if err := putItem(ns, item); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (b *Bolt) Remove(id int64) error {
+	return b.update(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		buck := ns.Bucket(itemsBucket)
+
+		item, err := getItem(buck, id)
+		if err != nil {
+			return err
+		}
+
+		if err := deleteItem(ns, item); err != nil {
+			return err
+		}
+
+		item.Deleted = time.Now()
+		j, err := json.Marshal(item)
+		if err != nil {
+			return fmt.Errorf("unable to marshal item: %s", err)
+		}
+		if err := ns.Bucket(trashBucket).Put(itemKey(id), j); err != nil {
+			return err
+		}
+
+		// Children move up to the parent of the removed item.
+		var children []*todow.Item
+		err = b.forEachItem(buck, func(v *todow.Item) error {
+			if v.ParentID == id {
+				children = append(children, v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, c := range children {
+			c.ParentID = item.ParentID
+			if err := putItem(ns, c); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *Bolt) Trash() ([]*todow.Item, error) {
+	col := []*todow.Item{}
+
+	err := b.view(func(tx *bolt.Tx) error {
+		return b.forEachItem(b.ns(tx).Bucket(trashBucket), func(v *todow.Item) error {
+			col = append(col, v)
+			return nil
+		})
+	})
+	sort.SliceStable(col, func(i, j int) bool { return col[i].Deleted.After(col[j].Deleted) })
+	return col, err
+}
+
+func (b *Bolt) Restore(id int64) (*todow.Item, error) {
+	var item *todow.Item
+
+	err := b.update(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		trash := ns.Bucket(trashBucket)
+
+		var err error
+		item, err = getItem(trash, id)
+		if err != nil {
+			return err
+		}
+
+		if item.ParentID != 0 && ns.Bucket(itemsBucket).Get(itemKey(item.ParentID)) == nil {
+			item.ParentID = 0
+		}
+		item.Deleted = time.Time{}
+		if err := putItem(ns, item); err != nil {
+			return err
+		}
+		return trash.Delete(itemKey(id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (b *Bolt) Purge(before time.Time) (int, error) {
+	var n int
+
+	err := b.update(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		trash := ns.Bucket(trashBucket)
+
+		var ids []int64
+		err := b.forEachItem(trash, func(v *todow.Item) error {
+			if v.Deleted.Before(before) {
+				ids = append(ids, v.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := trash.Delete(itemKey(id)); err != nil {
+				return err
+			}
+			if err := deleteHistory(ns, id); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	return n, err
+}
+
+func (b *Bolt) Update(id int64, fn, cascade func(*todow.Item)) error {
+	return b.update(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		buck := ns.Bucket(itemsBucket)
+
+		item, err := getItem(buck, id)
+		if err != nil {
+			return err
+		}
+
+		fn(item)
+		if err := putItem(ns, item); err != nil {
+			return err
+		}
+
+		if cascade == nil {
+			return nil
+		}
+
+		col := []*todow.Item{}
+		err = b.forEachItem(buck, func(v *todow.Item) error {
+			col = append(col, v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, d := range Descendants(col, id) {
+			cascade(d)
+			if err := putItem(ns, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) Close() error {
+	if b.user != "" {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.db.Close()
+}
+
+func (b *Bolt) Get(id int64) (*todow.Item, error) {
+	var item *todow.Item
+	err := b.view(func(tx *bolt.Tx) error {
+		var err error
+		item, err = getItem(b.ns(tx).Bucket(itemsBucket), id)
+		return err
+	})
+	return item, err
+}
+
+func (b *Bolt) All() ([]*todow.Item, error) {
+	col, err := b.cached()
+	if err != nil {
+		return nil, err
+	}
+	return copyItems(col), nil
+}
+
+func (b *Bolt) Find(q Query) ([]*todow.Item, int, error) {
+	p := newPage(&q)
+	if col, _, ok := b.cache.get(b.user); ok {
+		return findCached(p, col)
+	}
+
+	// Only the items the field index selects need to be read, all
+	// others are cached for the next time.
+	scan := false
+	err := b.view(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		buck := ns.Bucket(itemsBucket)
+
+		ids, ok := findIndexed(ns.Bucket(fieldIndexBucket), &q)
+		if !ok {
+			scan = true
+			return nil
+		}
+		for _, id := range ids {
+			if err := b.err(); err != nil {
+				return err
+			}
+			item, err := getItem(buck, id)
+			if err != nil {
+				return err
+			}
+			p.add(item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if scan {
+		col, err := b.cached()
+		if err != nil {
+			return nil, 0, err
+		}
+		return findCached(p, col)
+	}
+
+	col, total := p.result()
+	return col, total, nil
+}
+
+// findCached returns the page p of the cached items col.
+func findCached(p *page, col []*todow.Item) ([]*todow.Item, int, error) {
+	for _, v := range col {
+		p.add(v)
+	}
+	res, total := p.result()
+	return copyItems(res), total, nil
+}
+
+func (b *Bolt) DueReminders(now time.Time) ([]*todow.Item, error) {
+	// Most checks find none, which needs no writable transaction
+	// dropping the cache.
+	col, err := b.cached()
+	if err != nil {
+		return nil, err
+	}
+	pending := false
+	for _, v := range col {
+		if !v.Done && !v.RemindAt.IsZero() && !v.RemindAt.After(now) {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return nil, nil
+	}
+
+	var due []*todow.Item
+	err = b.update(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		buck := ns.Bucket(itemsBucket)
+
+		err := b.forEachItem(buck, func(v *todow.Item) error {
+			if v.Done || v.RemindAt.IsZero() || v.RemindAt.After(now) {
+				return nil
+			}
+			v.RemindAt = time.Time{}
+			due = append(due, v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, v := range due {
+			if err := putItem(ns, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return due, err
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/j1436go/todow"
+)
+
+// itemCache holds the decoded items of the users of a bolt file, so
+// listing them doesn't read and decode them anew for every request.
+// Every write transaction changing items drops it.
+type itemCache struct {
+	mu sync.Mutex
+	// gen counts the writes, so reads that began before one don't
+	// fill the cache with the items it replaced.
+	gen   uint64
+	items map[string][]*todow.Item
+}
+
+// get returns the cached items of the named user, if any, and the
+// generation to put them with otherwise.
+func (c *itemCache) get(user string) ([]*todow.Item, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	col, ok := c.items[user]
+	return col, c.gen, ok
+}
+
+// put caches the items of the named user read at generation gen,
+// unless there were writes since.
+func (c *itemCache) put(user string, gen uint64, col []*todow.Item) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if c.items == nil {
+		c.items = map[string][]*todow.Item{}
+	}
+	c.items[user] = col
+}
+
+// invalidate drops the cached items of all users.
+func (c *itemCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.items = nil
+}
+
+// cached returns the items of the store, from the cache if possible.
+// They must not be changed, see copyItems.
+func (b *Bolt) cached() ([]*todow.Item, error) {
+	col, gen, ok := b.cache.get(b.user)
+	if ok {
+		return col, nil
+	}
+
+	col = []*todow.Item{}
+	err := b.view(func(tx *bolt.Tx) error {
+		return b.forEachItem(b.ns(tx).Bucket(itemsBucket), func(v *todow.Item) error {
+			col = append(col, v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.cache.put(b.user, gen, col)
+	return col, nil
+}
+
+// copyItems returns copies of the cached items of col, which callers
+// may change.
+func copyItems(col []*todow.Item) []*todow.Item {
+	res := make([]*todow.Item, len(col))
+	for i, v := range col {
+		c := *v
+		c.Tags = append([]string(nil), v.Tags...)
+		c.Checklist = append([]todow.CheckItem(nil), v.Checklist...)
+		c.BlockedBy = append([]int64(nil), v.BlockedBy...)
+		if v.Meta != nil {
+			c.Meta = make(map[string]string, len(v.Meta))
+			for k, s := range v.Meta {
+				c.Meta[k] = s
+			}
+		}
+		res[i] = &c
+	}
+	return res
+}
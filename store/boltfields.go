@@ -0,0 +1,173 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/j1436go/todow"
+)
+
+// fieldIndexBucket indexes items by the fields queries select them by.
+// Every key is a field prefix, the value of the field and the key of an
+// item:
+//
+//	d 0|1 ID             completion state
+//	t TAG 0 ID           tag
+//	u SECONDS ID         due date, in seconds since 1970 with flipped
+//	                     sign bit so they sort; items without are left out
+var fieldIndexBucket = []byte("fields")
+
+// Prefixes of the keys of fieldIndexBucket.
+const (
+	fieldDone = 'd'
+	fieldTag  = 't'
+	fieldDue  = 'u'
+)
+
+func doneKey(done bool) []byte {
+	if done {
+		return []byte{fieldDone, 1}
+	}
+	return []byte{fieldDone, 0}
+}
+
+func tagKey(tag string) []byte {
+	return append(append([]byte{fieldTag}, tag...), 0)
+}
+
+func dueKey(sec int64) []byte {
+	k := []byte{fieldDue, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(k[1:], uint64(sec)^1<<63)
+	return k
+}
+
+// fieldKeys returns the keys of fieldIndexBucket of item.
+func fieldKeys(item *todow.Item) [][]byte {
+	keys := [][]byte{doneKey(item.Done)}
+	for _, t := range item.Tags {
+		keys = append(keys, tagKey(t))
+	}
+	if !item.Due.IsZero() {
+		keys = append(keys, dueKey(item.Due.Unix()))
+	}
+	for i := range keys {
+		keys[i] = append(keys[i], itemKey(item.ID)...)
+	}
+	return keys
+}
+
+// reindexFields replaces the field index entries of old with those of
+// item in ns. Either may be nil. Nothing is done until the field index
+// bucket exists.
+func reindexFields(ns namespace, old, item *todow.Item) error {
+	idx := ns.Bucket(fieldIndexBucket)
+	if idx == nil {
+		return nil
+	}
+
+	if old != nil {
+		for _, k := range fieldKeys(old) {
+			if err := idx.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	if item != nil {
+		for _, k := range fieldKeys(item) {
+			if err := idx.Put(k, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// buildFieldIndexes creates the field index buckets of all users and
+// indexes their items.
+func buildFieldIndexes(tx *bolt.Tx) error {
+	if err := createNamespaceBuckets(tx, fieldIndexBucket); err != nil {
+		return err
+	}
+
+	namespaces, err := allNamespaces(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces {
+		err := forEachItem(ns.Bucket(itemsBucket), func(v *todow.Item) error {
+			return reindexFields(ns, nil, v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findIndexed returns the IDs of the items of idx that may match q in
+// ascending order, selected by its tags, completion state and due date
+// range. It returns false if q selects by none of them, or idx is nil,
+// in which case all items have to be matched.
+func findIndexed(idx *bolt.Bucket, q *Query) ([]int64, bool) {
+	if idx == nil {
+		return nil, false
+	}
+
+	var sets []map[int64]bool
+	for _, t := range q.Tags {
+		sets = append(sets, scanFields(idx, tagKey(t), nil))
+	}
+	if q.Done != nil {
+		sets = append(sets, scanFields(idx, doneKey(*q.Done), nil))
+	}
+	if !q.DueAfter.IsZero() || !q.DueBefore.IsZero() {
+		from, to := dueKey(-1<<63), []byte{fieldDue + 1}
+		if !q.DueAfter.IsZero() {
+			from = dueKey(q.DueAfter.Unix())
+		}
+		// Seconds are rounded down, so the second of DueBefore is
+		// included and left to Match.
+		if !q.DueBefore.IsZero() {
+			to = dueKey(q.DueBefore.Unix() + 1)
+		}
+		sets = append(sets, scanFields(idx, from, to))
+	}
+	if len(sets) == 0 {
+		return nil, false
+	}
+
+	// The smallest set is checked against the others.
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	var ids []int64
+	for id := range sets[0] {
+		found := true
+		for _, s := range sets[1:] {
+			if !s[id] {
+				found = false
+				break
+			}
+		}
+		if found {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, true
+}
+
+// scanFields returns the IDs of the keys of idx starting with prefix
+// or, if to is not nil, of those from prefix up to but excluding to.
+func scanFields(idx *bolt.Bucket, prefix, to []byte) map[int64]bool {
+	ids := map[int64]bool{}
+	c := idx.Cursor()
+	for k, _ := c.Seek(prefix); k != nil; k, _ = c.Next() {
+		if to == nil && !bytes.HasPrefix(k, prefix) || to != nil && bytes.Compare(k, to) >= 0 {
+			break
+		}
+		ids[int64(binary.BigEndian.Uint64(k[len(k)-8:]))] = true
+	}
+	return ids
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/j1436go/todow"
+)
+
+// indexBucket is an inverted index of search terms. Every key is a term
+// followed by a zero byte and the key of an item containing the term.
+var indexBucket = []byte("index")
+
+func indexKey(term string, id int64) []byte {
+	return append(append([]byte(term), 0), itemKey(id)...)
+}
+
+// reindex replaces the index entries of old with those of item in ns.
+// Either may be nil. Nothing is done until the index bucket exists.
+func reindex(ns namespace, old, item *todow.Item) error {
+	idx := ns.Bucket(indexBucket)
+	if idx == nil {
+		return nil
+	}
+
+	if old != nil {
+		for _, t := range itemTerms(old) {
+			if err := idx.Delete(indexKey(t, old.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	if item != nil {
+		for _, t := range itemTerms(item) {
+			if err := idx.Put(indexKey(t, item.ID), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// buildIndex creates the index bucket and indexes all items.
+func buildIndex(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(indexBucket); err != nil {
+		return err
+	}
+
+	return forEachItem(tx.Bucket(itemsBucket), func(v *todow.Item) error {
+		return reindex(tx, nil, v)
+	})
+}
+
+// rebuildSearchIndexes replaces the search indexes of all users, which
+// didn't include the notes of items, and indexes the items of users
+// whose index predates them.
+func rebuildSearchIndexes(tx *bolt.Tx) error {
+	namespaces, err := allNamespaces(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces {
+		idx, err := ns.CreateBucketIfNotExists(indexBucket)
+		if err != nil {
+			return err
+		}
+
+		// DeleteBucket takes the empty values of the index for nested
+		// buckets, and deleting while iterating skips keys.
+		var keys [][]byte
+		idx.ForEach(func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		})
+		for _, k := range keys {
+			if err := idx.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		err = forEachItem(ns.Bucket(itemsBucket), func(v *todow.Item) error {
+			return reindex(ns, nil, v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchIndex returns the IDs of items having a term starting with prefix.
+func searchIndex(idx *bolt.Bucket, prefix string) map[int64]bool {
+	ids := map[int64]bool{}
+	p := []byte(prefix)
+	c := idx.Cursor()
+	for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+		ids[int64(binary.BigEndian.Uint64(k[len(k)-8:]))] = true
+	}
+	return ids
+}
+
+func (b *Bolt) Search(text string) ([]*todow.Item, error) {
+	query := terms(text)
+	col := []*todow.Item{}
+	if len(query) == 0 {
+		return col, nil
+	}
+
+	err := b.view(func(tx *bolt.Tx) error {
+		ns := b.ns(tx)
+		idx := ns.Bucket(indexBucket)
+
+		var ids map[int64]bool
+		for _, q := range query {
+			found := searchIndex(idx, q)
+			if ids == nil {
+				ids = found
+				continue
+			}
+			for id := range ids {
+				if !found[id] {
+					delete(ids, id)
+				}
+			}
+		}
+
+		sorted := make([]int64, 0, len(ids))
+		for id := range ids {
+			sorted = append(sorted, id)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		items := ns.Bucket(itemsBucket)
+		for _, id := range sorted {
+			if err := b.err(); err != nil {
+				return err
+			}
+			item, err := getItem(items, id)
+			if err != nil {
+				return err
+			}
+			col = append(col, item)
+		}
+		return nil
+	})
+	return col, err
+}
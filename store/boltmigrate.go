@@ -0,0 +1,209 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/boltdb/bolt"
+	"github.com/j1436go/todow"
+)
+
+var (
+	// metaBucket holds data about the database itself.
+	metaBucket = []byte("meta")
+	versionKey = []byte("schema_version")
+
+	// bucketName and collectionKey locate the legacy collection,
+	// which stored all items in a single JSON array.
+	bucketName    = []byte("todow")
+	collectionKey = []byte("items")
+)
+
+// boltMigrations upgrade the database from schema version i to i+1.
+// Only ever append to this list.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	migrateCollection,
+	reencodeItems,
+	buildIndex,
+	createAuditBucket,
+	createTrashBuckets,
+	createHistoryBuckets,
+	seedItemSequences,
+	buildFieldIndexes,
+	rebuildSearchIndexes,
+}
+
+// migrate upgrades the database to the latest schema version.
+// Databases with a newer schema than this build knows are refused.
+func (b *Bolt) migrate() error {
+	return b.update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return fmt.Errorf("unable to create/get bucket: %s", err)
+		}
+
+		var version uint64
+		if p := meta.Get(versionKey); p != nil {
+			version = binary.BigEndian.Uint64(p)
+		}
+
+		if version > uint64(len(boltMigrations)) {
+			return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(boltMigrations))
+		}
+
+		for ; version < uint64(len(boltMigrations)); version++ {
+			if err := boltMigrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d failed: %s", version+1, err)
+			}
+
+			p := make([]byte, 8)
+			binary.BigEndian.PutUint64(p, version+1)
+			if err := meta.Put(versionKey, p); err != nil {
+				return err
+			}
+			slog.Info("applied migration", "version", version+1)
+		}
+
+		return nil
+	})
+}
+
+// migrateCollection moves the items of the legacy collection
+// into the items bucket and removes the collection.
+func migrateCollection(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(itemsBucket); err != nil {
+		return fmt.Errorf("unable to create/get bucket: %s", err)
+	}
+
+	old := tx.Bucket(bucketName)
+	if old == nil {
+		return nil
+	}
+
+	p := old.Get(collectionKey)
+	if p == nil {
+		return tx.DeleteBucket(bucketName)
+	}
+
+	col := []*todow.Item{}
+	if err := json.Unmarshal(p, &col); err != nil {
+		return fmt.Errorf("collection seems corrupt: %s", err)
+	}
+
+	for _, v := range col {
+		if err := putItem(tx, v); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("migrated items to per-item storage", "count", len(col))
+	return tx.DeleteBucket(bucketName)
+}
+
+// reencodeItems rewrites all items so that records written before due
+// dates, tags, reminders, completion times and priorities existed
+// carry every field explicitly.
+func reencodeItems(tx *bolt.Tx) error {
+	buck := tx.Bucket(itemsBucket)
+
+	var col []*todow.Item
+	err := forEachItem(buck, func(v *todow.Item) error {
+		if v.Tags == nil {
+			v.Tags = []string{}
+		}
+		col = append(col, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range col {
+		if err := putItem(tx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTrashBuckets creates the trash buckets of all users.
+func createTrashBuckets(tx *bolt.Tx) error {
+	return createNamespaceBuckets(tx, trashBucket)
+}
+
+// allNamespaces returns the namespaces of all users.
+func allNamespaces(tx *bolt.Tx) ([]namespace, error) {
+	namespaces := []namespace{tx}
+	users := tx.Bucket(usersBucket)
+	if users == nil {
+		return namespaces, nil
+	}
+	err := users.ForEach(func(k, v []byte) error {
+		if v == nil {
+			namespaces = append(namespaces, users.Bucket(k))
+		}
+		return nil
+	})
+	return namespaces, err
+}
+
+// createNamespaceBuckets creates the bucket called name
+// in the namespaces of all users.
+func createNamespaceBuckets(tx *bolt.Tx, name []byte) error {
+	if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+		return err
+	}
+
+	users := tx.Bucket(usersBucket)
+	if users == nil {
+		return nil
+	}
+
+	// Buckets must not be changed while iterating over them.
+	var names [][]byte
+	err := users.ForEach(func(k, v []byte) error {
+		if v == nil {
+			names = append(names, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, user := range names {
+		if _, err := users.Bucket(user).CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedItemSequences sets the sequences of the items buckets of all users,
+// which Add takes IDs from, past the highest ID of their items, removed
+// items and histories.
+func seedItemSequences(tx *bolt.Tx) error {
+	namespaces, err := allNamespaces(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces {
+		buck := ns.Bucket(itemsBucket)
+		seq := buck.Sequence()
+		for _, name := range [][]byte{itemsBucket, trashBucket, historyBucket} {
+			// History keys start with the ID of their item.
+			if k, _ := ns.Bucket(name).Cursor().Last(); k != nil {
+				if id := binary.BigEndian.Uint64(k[:8]); id > seq {
+					seq = id
+				}
+			}
+		}
+		if err := buck.SetSequence(seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
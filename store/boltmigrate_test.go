@@ -0,0 +1,130 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/j1436go/todow"
+)
+
+func TestBoltMigrate(t *testing.T) {
+	legacy := func(tx *bolt.Tx) error {
+		p, err := json.Marshal([]*todow.Item{
+			{ID: 1, Body: "call mom", Notes: "about sunday"},
+			{ID: 4, Body: "buy milk", Tags: []string{"shop"}},
+		})
+		if err != nil {
+			return err
+		}
+		buck, err := tx.CreateBucket(bucketName)
+		if err != nil {
+			return err
+		}
+		return buck.Put(collectionKey, p)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(tx *bolt.Tx) error
+		ok    bool
+		check func(t *testing.T, b *Bolt)
+	}{
+		{
+			name:  "new database",
+			setup: func(tx *bolt.Tx) error { return nil },
+			ok:    true,
+			check: func(t *testing.T, b *Bolt) {
+				if col, err := b.All(); err != nil || len(col) != 0 {
+					t.Errorf("All() = %d items, %v, want none", len(col), err)
+				}
+			},
+		},
+		{
+			name:  "legacy collection",
+			setup: legacy,
+			ok:    true,
+			check: func(t *testing.T, b *Bolt) {
+				col, err := b.All()
+				if err != nil || len(col) != 2 {
+					t.Fatalf("All() = %d items, %v, want 2", len(col), err)
+				}
+				if col, err := b.Search("sunday"); err != nil || len(col) != 1 || col[0].ID != 1 {
+					t.Errorf("Search(sunday) = %v, %v, want item #1", col, err)
+				}
+				if col, err := b.Search("shop"); err != nil || len(col) != 1 || col[0].ID != 4 {
+					t.Errorf("Search(shop) = %v, %v, want item #4", col, err)
+				}
+				item := &todow.Item{Body: "new"}
+				if err := b.Add(item); err != nil || item.ID != 5 {
+					t.Errorf("Add() assigned #%d, %v, want #5", item.ID, err)
+				}
+			},
+		},
+		{
+			name: "empty legacy bucket",
+			setup: func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucket(bucketName)
+				return err
+			},
+			ok: true,
+			check: func(t *testing.T, b *Bolt) {
+				b.view(func(tx *bolt.Tx) error {
+					if tx.Bucket(bucketName) != nil {
+						t.Errorf("legacy bucket was kept")
+					}
+					return nil
+				})
+			},
+		},
+		{
+			name: "newer schema",
+			setup: func(tx *bolt.Tx) error {
+				meta, err := tx.CreateBucket(metaBucket)
+				if err != nil {
+					return err
+				}
+				p := make([]byte, 8)
+				binary.BigEndian.PutUint64(p, uint64(len(boltMigrations)+1))
+				return meta.Put(versionKey, p)
+			},
+			ok: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "todos.db")
+			db, err := bolt.Open(path, 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Update(tt.setup); err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+
+			b, err := OpenBolt(path)
+			if !tt.ok {
+				if err == nil {
+					b.Close()
+					t.Fatal("OpenBolt() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenBolt() failed: %s", err)
+			}
+			defer b.Close()
+
+			b.view(func(tx *bolt.Tx) error {
+				if v := binary.BigEndian.Uint64(tx.Bucket(metaBucket).Get(versionKey)); v != uint64(len(boltMigrations)) {
+					t.Errorf("schema version = %d, want %d", v, len(boltMigrations))
+				}
+				return nil
+			})
+			tt.check(t, b)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// Compacter is implemented by stores that can give back space
+// left unused by deleted and rewritten data.
+type Compacter interface {
+	// Compact returns the size of the store before and after compacting.
+	Compact() (before, after int64, err error)
+}
+
+// Compact copies the database into a fresh file and swaps it in.
+// Requests wait until the swap is done.
+func (b *Bolt) Compact() (int64, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	before, err := fileSize(b.path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tmp := b.path + ".compact"
+	os.Remove(tmp)
+
+	dst, err := openBoltFile(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = b.db.View(func(src *bolt.Tx) error {
+		return dst.Update(func(tx *bolt.Tx) error {
+			return src.ForEach(func(name []byte, buck *bolt.Bucket) error {
+				nb, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(nb, buck)
+			})
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, 0, fmt.Errorf("unable to copy database: %s", err)
+	}
+
+	// The old file stays open, and linked as old to move it back, until
+	// the compacted one is open.
+	old := b.path + ".old"
+	os.Remove(old)
+	if err := os.Link(b.path, old); err != nil {
+		os.Remove(tmp)
+		return 0, 0, fmt.Errorf("unable to keep database while compacting: %s", err)
+	}
+
+	if err := os.Rename(tmp, b.path); err != nil {
+		os.Remove(tmp)
+		os.Remove(old)
+		return 0, 0, fmt.Errorf("unable to swap in compacted database: %s", err)
+	}
+
+	d, err := openBoltFile(b.path)
+	if err != nil {
+		// Changes go to old until it's moved back.
+		if rerr := os.Rename(old, b.path); rerr != nil {
+			return 0, 0, fmt.Errorf("unable to open compacted database: %s, then unable to move back %s, which is still used: %s", err, old, rerr)
+		}
+		return 0, 0, fmt.Errorf("unable to open compacted database: %s", err)
+	}
+	if err := b.db.Close(); err != nil {
+		slog.Warn("unable to close database replaced by compacted one", "file", b.path, "err", err)
+	}
+	b.db = d
+	os.Remove(old)
+
+	after, err := fileSize(b.path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	slog.Info("compacted store", "file", b.path, "before", before, "after", after)
+	return before, after, nil
+}
+
+// copyBucket copies all keys and nested buckets of src to dst, and the
+// sequence IDs are taken from.
+func copyBucket(dst, src *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	// Keys are copied in order, fill pages completely.
+	dst.FillPercent = 1
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nb, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(nb, src.Bucket(k))
+	})
+}
+
+func fileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
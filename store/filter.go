@@ -0,0 +1,102 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// FilterValues returns the query parameters, see ParseQuery, of the
+// filter expression expr. Its words select items by
+//
+//	+TAG          tag, may be repeated
+//	due:today     due today
+//	due:week      due within the week from today
+//	due:overdue   overdue and not done
+//	due:3d        due before now plus a duration, like 3d, 2w or 12h
+//	pri:B         priority B or higher
+//	done:false    completion state, true or false
+//
+// The other words select items whose body contains them. Relative dates
+// are resolved against now, so a filter like "+work due:week" keeps
+// selecting the items due within the week ahead.
+func FilterValues(expr string, now time.Time) (url.Values, error) {
+	v := url.Values{}
+	var text []string
+
+	for _, w := range strings.Fields(expr) {
+		if strings.HasPrefix(w, todow.TagPrefix) && len(w) > len(todow.TagPrefix) {
+			v.Add("tag", strings.TrimPrefix(w, todow.TagPrefix))
+			continue
+		}
+
+		i := strings.Index(w, ":")
+		if i < 0 {
+			text = append(text, w)
+			continue
+		}
+		key, val := w[:i], w[i+1:]
+
+		switch key {
+		case "due":
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+			switch val {
+			case "today":
+				v.Set("due_after", today.Format(time.RFC3339))
+				v.Set("due_before", today.AddDate(0, 0, 1).Format(time.RFC3339))
+			case "week":
+				v.Set("due_after", today.Format(time.RFC3339))
+				v.Set("due_before", today.AddDate(0, 0, 7).Format(time.RFC3339))
+			case "overdue":
+				v.Set("due_before", now.Format(time.RFC3339))
+				v.Set("done", "false")
+			default:
+				d, err := filterDuration(val)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("invalid %q, use due:today, due:week, due:overdue or a duration like due:3d", w)
+				}
+				v.Set("due_before", now.Add(d).Format(time.RFC3339))
+			}
+		case "pri":
+			if p := strings.ToUpper(val); p != "" && todow.ValidPriority(p) {
+				v.Set("priority", p)
+				continue
+			}
+			return nil, fmt.Errorf("invalid %q, use a priority from A to Z", w)
+		case "done":
+			if _, err := strconv.ParseBool(val); err != nil {
+				return nil, fmt.Errorf("invalid %q, use done:true or done:false", w)
+			}
+			v.Set("done", val)
+		default:
+			text = append(text, w)
+		}
+	}
+
+	if len(text) > 0 {
+		v.Set("q", strings.Join(text, " "))
+	}
+	return v, nil
+}
+
+// filterDuration is like time.ParseDuration but also accepts
+// a number of days or weeks, e.g. "7d" or "2w".
+func filterDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// A Change records an event in the history of an item.
+type Change struct {
+	Time time.Time
+
+	// User is the name of the user who made the change, if known.
+	User string `json:",omitempty"`
+
+	// Kind is one of the Change constants.
+	Kind string
+
+	// Field names the edited field, "body", "notes", "checklist", "tags"
+	// or "priority", of edits. Old and New are its values before and
+	// after edits and due date changes, due dates in RFC 3339 and
+	// checklists formatted by todow.FormatChecklist, empty if there is none.
+	Field string `json:",omitempty"`
+	Old   string `json:",omitempty"`
+	New   string `json:",omitempty"`
+}
+
+// Kinds of changes.
+const (
+	ChangeCreated   = "created"
+	ChangeEdited    = "edited"
+	ChangeCompleted = "completed"
+	ChangeReopened  = "reopened"
+	ChangeDue       = "due"
+)
+
+// Historian is implemented by stores that keep the history of items.
+// The history of an item goes when it is purged from the trash.
+type Historian interface {
+	// Record appends c to the history of the item identified by id.
+	Record(id int64, c Change) error
+
+	// History returns the changes of the item identified by id,
+	// oldest first.
+	History(id int64) ([]Change, error)
+}
+
+// historyBucket stores changes as JSON under the big endian ID of their
+// item followed by a big endian sequence number, so the changes of an
+// item are adjacent and ordered by the time they were recorded.
+var historyBucket = []byte("history")
+
+func createHistoryBuckets(tx *bolt.Tx) error {
+	return createNamespaceBuckets(tx, historyBucket)
+}
+
+func (b *Bolt) Record(id int64, c Change) error {
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return b.updateLog(func(tx *bolt.Tx) error {
+		buck := b.ns(tx).Bucket(historyBucket)
+		seq, err := buck.NextSequence()
+		if err != nil {
+			return err
+		}
+		return buck.Put(append(itemKey(id), itemKey(int64(seq))...), j)
+	})
+}
+
+func (b *Bolt) History(id int64) ([]Change, error) {
+	col := []Change{}
+	err := b.view(func(tx *bolt.Tx) error {
+		prefix := itemKey(id)
+		c := b.ns(tx).Bucket(historyBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var ch Change
+			if err := json.Unmarshal(v, &ch); err != nil {
+				return fmt.Errorf("history of item #%d seems corrupt: %s", id, err)
+			}
+			col = append(col, ch)
+		}
+		return nil
+	})
+	return col, err
+}
+
+// deleteHistory deletes the history of the item identified by id from ns.
+func deleteHistory(ns namespace, id int64) error {
+	buck := ns.Bucket(historyBucket)
+	prefix := itemKey(id)
+
+	// Deleting while iterating skips keys.
+	var keys [][]byte
+	c := buck.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := buck.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Postgres) Record(id int64, c Change) error {
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(db.context(), `INSERT INTO history (owner, item_id, data) VALUES ($1, $2, $3)`, db.user, id, j)
+	return err
+}
+
+func (db *Postgres) History(id int64) ([]Change, error) {
+	rows, err := db.QueryContext(db.context(), `SELECT data FROM history WHERE owner = $1 AND item_id = $2 ORDER BY id`, db.user, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	col := []Change{}
+	for rows.Next() {
+		var p []byte
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		var c Change
+		if err := json.Unmarshal(p, &c); err != nil {
+			return nil, fmt.Errorf("history of item #%d seems corrupt: %s", id, err)
+		}
+		col = append(col, c)
+	}
+	return col, rows.Err()
+}
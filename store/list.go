@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// ErrReadOnly is returned by writes to a read-only list.
+type ErrReadOnly struct{}
+
+func (e ErrReadOnly) Error() string { return "list is read-only" }
+
+// list is the view of the items of a store having a tag.
+type list struct {
+	s        Store
+	tag      string
+	writable bool
+}
+
+// List returns a view of the items of s tagged with tag. Items added to
+// it are tagged automatically, other items appear not to exist. If
+// writable is false, all writes fail with ErrReadOnly.
+func List(s Store, tag string, writable bool) Store {
+	return list{s, tag, writable}
+}
+
+func (l list) Add(item *todow.Item) error {
+	if !l.writable {
+		return ErrReadOnly{}
+	}
+	if item.ParentID != 0 {
+		if _, err := l.Get(item.ParentID); err != nil {
+			return err
+		}
+	}
+	if !item.HasTag(l.tag) {
+		item.Tags = append(item.Tags, l.tag)
+	}
+	return l.s.Add(item)
+}
+
+func (l list) Remove(id int64) error {
+	if !l.writable {
+		return ErrReadOnly{}
+	}
+	if _, err := l.Get(id); err != nil {
+		return err
+	}
+	return l.s.Remove(id)
+}
+
+func (l list) Trash() ([]*todow.Item, error) {
+	col, err := l.s.Trash()
+	if err != nil {
+		return nil, err
+	}
+	return l.filter(col), nil
+}
+
+func (l list) Restore(id int64) (*todow.Item, error) {
+	if !l.writable {
+		return nil, ErrReadOnly{}
+	}
+	col, err := l.Trash()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range col {
+		if v.ID == id {
+			return l.s.Restore(id)
+		}
+	}
+	return nil, ErrNotFound{}
+}
+
+// Purge does nothing, the trash is purged by the owner of the items.
+func (l list) Purge(before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (l list) Update(id int64, fn, cascade func(*todow.Item)) error {
+	if !l.writable {
+		return ErrReadOnly{}
+	}
+	if _, err := l.Get(id); err != nil {
+		return err
+	}
+	if cascade != nil {
+		// Descendants outside the list are left alone.
+		c := cascade
+		cascade = func(item *todow.Item) {
+			if item.HasTag(l.tag) {
+				c(item)
+			}
+		}
+	}
+	return l.s.Update(id, fn, cascade)
+}
+
+func (l list) Get(id int64) (*todow.Item, error) {
+	item, err := l.s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !item.HasTag(l.tag) {
+		return nil, ErrNotFound{}
+	}
+	return item, nil
+}
+
+func (l list) All() ([]*todow.Item, error) {
+	col, err := l.s.All()
+	if err != nil {
+		return nil, err
+	}
+	return l.filter(col), nil
+}
+
+func (l list) Find(q Query) ([]*todow.Item, int, error) {
+	q.Tags = append(append([]string{}, q.Tags...), l.tag)
+	return l.s.Find(q)
+}
+
+func (l list) Search(text string) ([]*todow.Item, error) {
+	col, err := l.s.Search(text)
+	if err != nil {
+		return nil, err
+	}
+	return l.filter(col), nil
+}
+
+// DueReminders returns nothing, reminders are sent to the owner of the items.
+func (l list) DueReminders(now time.Time) ([]*todow.Item, error) {
+	return nil, nil
+}
+
+func (l list) User(name string) (Store, error) {
+	return nil, fmt.Errorf("list of %q has no users", l.tag)
+}
+
+func (l list) WithContext(ctx context.Context) Store {
+	return list{l.s.WithContext(ctx), l.tag, l.writable}
+}
+
+// Close does nothing, the underlying store stays open.
+func (l list) Close() error { return nil }
+
+func (l list) filter(col []*todow.Item) []*todow.Item {
+	res := []*todow.Item{}
+	for _, v := range col {
+		if v.HasTag(l.tag) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,578 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+	_ "github.com/lib/pq"
+)
+
+// Postgres is a Store backed by a PostgreSQL database, which allows
+// several servers to share one collection.
+type Postgres struct {
+	*sql.DB
+
+	// user owns the items of the store, "" being the default user.
+	user string
+
+	// ctx, if not nil, is the context queries are bound to.
+	ctx context.Context
+}
+
+// pgMigrations are applied in order; the number of applied migrations
+// is recorded in the schema_migrations table.
+// Only ever append to this list.
+var pgMigrations = []string{
+	`CREATE TABLE items (
+		id        bigserial PRIMARY KEY,
+		parent_id bigint NOT NULL DEFAULT 0,
+		remind_at timestamptz,
+		data      jsonb NOT NULL
+	)`,
+	`CREATE INDEX items_parent_id ON items (parent_id)`,
+	`CREATE INDEX items_remind_at ON items (remind_at) WHERE remind_at IS NOT NULL`,
+	`CREATE INDEX items_search ON items USING gin (to_tsvector('simple', COALESCE(data->>'Body', '') || ' ' || COALESCE(data->>'Tags', '')))`,
+	`ALTER TABLE items ADD COLUMN owner text NOT NULL DEFAULT ''`,
+	`CREATE INDEX items_owner ON items (owner)`,
+	`CREATE TABLE audit (
+		id   bigserial PRIMARY KEY,
+		time timestamptz NOT NULL,
+		data jsonb NOT NULL
+	)`,
+	`CREATE INDEX audit_time ON audit (time)`,
+	`CREATE TABLE trash (
+		id        bigint PRIMARY KEY,
+		owner     text NOT NULL,
+		parent_id bigint NOT NULL,
+		deleted   timestamptz NOT NULL,
+		data      jsonb NOT NULL
+	)`,
+	`CREATE INDEX trash_owner_deleted ON trash (owner, deleted)`,
+	`CREATE TABLE history (
+		id      bigserial PRIMARY KEY,
+		owner   text NOT NULL,
+		item_id bigint NOT NULL,
+		data    jsonb NOT NULL
+	)`,
+	`CREATE INDEX history_owner_item_id ON history (owner, item_id)`,
+	`DROP INDEX items_search`,
+	`CREATE INDEX items_search ON items USING gin (` + pgSearchVector + `)`,
+}
+
+// pgSearchVector is the indexed text search vector of an item.
+const pgSearchVector = `to_tsvector('simple', COALESCE(data->>'Body', '') || ' ' || COALESCE(data->>'Notes', '') || ' ' || COALESCE(data->>'Tags', ''))`
+
+// pgLockID is the advisory lock held while migrating so that
+// concurrently starting servers don't migrate twice.
+const pgLockID = 0x746f646f77
+
+// OpenPostgres connects to the database described by the connection
+// string dsn and applies outstanding schema migrations.
+func OpenPostgres(dsn string) (*Postgres, error) {
+	d, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Postgres{DB: d}
+	if err := db.migrate(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *Postgres) migrate() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, pgLockID); err != nil {
+		return fmt.Errorf("unable to acquire migration lock: %s", err)
+	}
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version int PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("unable to create schema_migrations: %s", err)
+	}
+
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("unable to read schema version: %s", err)
+	}
+
+	if version > len(pgMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(pgMigrations))
+	}
+
+	for i := version; i < len(pgMigrations); i++ {
+		if _, err := tx.Exec(pgMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d failed: %s", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+		slog.Info("applied migration", "version", i+1)
+	}
+
+	return tx.Commit()
+}
+
+// nullTime maps the zero time to NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanItem(row scanner) (*todow.Item, error) {
+	var (
+		id, parentID int64
+		data         []byte
+	)
+	if err := row.Scan(&id, &parentID, &data); err != nil {
+		return nil, err
+	}
+	return decodeItem(id, parentID, data)
+}
+
+func decodeItem(id, parentID int64, data []byte) (*todow.Item, error) {
+	var item todow.Item
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, fmt.Errorf("item #%d seems corrupt: %s", id, err)
+	}
+	item.ID = id
+	item.ParentID = parentID
+	return &item, nil
+}
+
+// queryItems runs query, which must select id, parent_id and data.
+func queryItems(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}, query string, args ...interface{}) ([]*todow.Item, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	col := []*todow.Item{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		col = append(col, item)
+	}
+	return col, rows.Err()
+}
+
+func saveItem(ctx context.Context, tx *sql.Tx, item *todow.Item) error {
+	j, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("unable to marshal item: %s", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE items SET parent_id = $2, remind_at = $3, data = $4 WHERE id = $1`,
+		item.ID, item.ParentID, nullTime(item.RemindAt), j,
+	)
+	return err
+}
+
+// User returns the store of the named user. All users share the items
+// table, their items are told apart by the owner column.
+func (db *Postgres) User(name string) (Store, error) {
+	if name != "" {
+		if err := checkUserName(name); err != nil {
+			return nil, err
+		}
+	}
+	return &Postgres{DB: db.DB, user: name, ctx: db.ctx}, nil
+}
+
+// WithContext returns the store bound to ctx, which is passed on to
+// every query. Transactions are rolled back if it is done.
+func (db *Postgres) WithContext(ctx context.Context) Store {
+	return &Postgres{DB: db.DB, user: db.user, ctx: ctx}
+}
+
+// context returns the context of the store, context.Background() if it
+// has none.
+func (db *Postgres) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+func (db *Postgres) Add(item *todow.Item) error {
+	tx, err := db.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if item.ParentID != 0 {
+		var exists bool
+		err := tx.QueryRowContext(db.context(), `SELECT EXISTS (SELECT 1 FROM items WHERE id = $1 AND owner = $2)`, item.ParentID, db.user).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound{}
+		}
+	}
+
+	j, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("unable to marshal item: %s", err)
+	}
+
+	err = tx.QueryRowContext(db.context(),
+		`INSERT INTO items (owner, parent_id, remind_at, data) VALUES ($1, $2, $3, $4) RETURNING id`,
+		db.user, item.ParentID, nullTime(item.RemindAt), j,
+	).Scan(&item.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Postgres) Remove(id int64) error {
+	tx, err := db.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	item, err := scanItem(tx.QueryRowContext(db.context(), `DELETE FROM items WHERE id = $1 AND owner = $2 RETURNING id, parent_id, data`, id, db.user))
+	if err == sql.ErrNoRows {
+		return ErrNotFound{}
+	}
+	if err != nil {
+		return err
+	}
+
+	item.Deleted = time.Now()
+	j, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("unable to marshal item: %s", err)
+	}
+	_, err = tx.ExecContext(db.context(),
+		`INSERT INTO trash (id, owner, parent_id, deleted, data) VALUES ($1, $2, $3, $4, $5)`,
+		item.ID, db.user, item.ParentID, item.Deleted, j,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Children move up to the parent of the removed item.
+	_, err = tx.ExecContext(db.context(),
+		`UPDATE items SET parent_id = $1, data = jsonb_set(data, '{ParentID}', to_jsonb($1::bigint)) WHERE parent_id = $2 AND owner = $3`,
+		item.ParentID, id, db.user,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Postgres) Trash() ([]*todow.Item, error) {
+	return queryItems(db.context(), db, `SELECT id, parent_id, data FROM trash WHERE owner = $1 ORDER BY deleted DESC`, db.user)
+}
+
+func (db *Postgres) Restore(id int64) (*todow.Item, error) {
+	tx, err := db.BeginTx(db.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	item, err := scanItem(tx.QueryRowContext(db.context(), `DELETE FROM trash WHERE id = $1 AND owner = $2 RETURNING id, parent_id, data`, id, db.user))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound{}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if item.ParentID != 0 {
+		var exists bool
+		err := tx.QueryRowContext(db.context(), `SELECT EXISTS (SELECT 1 FROM items WHERE id = $1 AND owner = $2)`, item.ParentID, db.user).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			item.ParentID = 0
+		}
+	}
+
+	item.Deleted = time.Time{}
+	j, err := json.Marshal(item)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal item: %s", err)
+	}
+	_, err = tx.ExecContext(db.context(),
+		`INSERT INTO items (id, owner, parent_id, remind_at, data) VALUES ($1, $2, $3, $4, $5)`,
+		item.ID, db.user, item.ParentID, nullTime(item.RemindAt), j,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (db *Postgres) Purge(before time.Time) (int, error) {
+	var n int
+	err := db.QueryRowContext(db.context(), `
+		WITH purged AS (
+			DELETE FROM trash WHERE owner = $1 AND deleted < $2 RETURNING id
+		), history AS (
+			DELETE FROM history WHERE owner = $1 AND item_id IN (SELECT id FROM purged)
+		)
+		SELECT COUNT(*) FROM purged`, db.user, before).Scan(&n)
+	return n, err
+}
+
+func (db *Postgres) Update(id int64, fn, cascade func(*todow.Item)) error {
+	tx, err := db.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	item, err := scanItem(tx.QueryRowContext(db.context(), `SELECT id, parent_id, data FROM items WHERE id = $1 AND owner = $2 FOR UPDATE`, id, db.user))
+	if err == sql.ErrNoRows {
+		return ErrNotFound{}
+	}
+	if err != nil {
+		return err
+	}
+
+	fn(item)
+	if err := saveItem(db.context(), tx, item); err != nil {
+		return err
+	}
+
+	if cascade != nil {
+		col, err := queryItems(db.context(), tx, `
+			WITH RECURSIVE tree AS (
+				SELECT id, parent_id, data FROM items WHERE parent_id = $1 AND owner = $2
+				UNION
+				SELECT i.id, i.parent_id, i.data FROM items i JOIN tree t ON i.parent_id = t.id WHERE i.owner = $2
+			)
+			SELECT id, parent_id, data FROM tree`, id, db.user)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range col {
+			cascade(d)
+			if err := saveItem(db.context(), tx, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Postgres) Get(id int64) (*todow.Item, error) {
+	item, err := scanItem(db.QueryRowContext(db.context(), `SELECT id, parent_id, data FROM items WHERE id = $1 AND owner = $2`, id, db.user))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound{}
+	}
+	return item, err
+}
+
+func (db *Postgres) All() ([]*todow.Item, error) {
+	return queryItems(db.context(), db, `SELECT id, parent_id, data FROM items WHERE owner = $1 ORDER BY id`, db.user)
+}
+
+func (db *Postgres) Find(q Query) ([]*todow.Item, int, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where = append(where, "owner = "+arg(db.user))
+	// Items stored before archiving existed lack the Archived key.
+	where = append(where, "(NULLIF(data->>'Archived', '0001-01-01T00:00:00Z') IS NOT NULL) = "+arg(q.Archived))
+	if !q.Deferred {
+		where = append(where, "COALESCE(NULLIF(data->>'StartAt', '0001-01-01T00:00:00Z')::timestamptz <= now(), true)")
+	}
+
+	for _, t := range q.Tags {
+		where = append(where, "data->'Tags' ? "+arg(t))
+	}
+	if q.Done != nil {
+		where = append(where, "COALESCE((data->>'Done')::boolean, false) = "+arg(*q.Done))
+	}
+	if q.Priority != "" {
+		where = append(where, "NULLIF(data->>'Priority', '') <= "+arg(q.Priority))
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "(data->>'Created')::timestamptz > "+arg(q.CreatedAfter))
+	}
+	if !q.CompletedSince.IsZero() {
+		where = append(where, "COALESCE((data->>'Done')::boolean, false) AND (data->>'CompletedAt')::timestamptz >= "+arg(q.CompletedSince))
+	}
+	// Zero times mark missing due dates, which compare as NULL.
+	if !q.DueAfter.IsZero() {
+		where = append(where, "NULLIF(data->>'Due', '0001-01-01T00:00:00Z')::timestamptz >= "+arg(q.DueAfter))
+	}
+	if !q.DueBefore.IsZero() {
+		where = append(where, "NULLIF(data->>'Due', '0001-01-01T00:00:00Z')::timestamptz < "+arg(q.DueBefore))
+	}
+	if q.Text != "" {
+		where = append(where, "strpos(lower(data->>'Body'), lower("+arg(q.Text)+")) > 0")
+	}
+
+	query := `SELECT id, parent_id, data, COUNT(*) OVER () FROM items WHERE ` + strings.Join(where, " AND ")
+	dir := "ASC"
+	if q.Desc {
+		dir = "DESC"
+	}
+	// Pinned items come first in any order.
+	query += " ORDER BY COALESCE((data->>'Pinned')::boolean, false) DESC"
+	switch q.Sort {
+	case SortCreated:
+		query += ", (data->>'Created')::timestamptz " + dir
+	case SortDue:
+		// Zero times mark missing due dates.
+		query += ", NULLIF(data->>'Due', '0001-01-01T00:00:00Z')::timestamptz " + dir + " NULLS LAST"
+	case SortPriority:
+		query += ", NULLIF(data->>'Priority', '') " + dir + " NULLS LAST"
+	}
+	query += ", id " + dir
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+	if q.Offset > 0 {
+		query += " OFFSET " + arg(q.Offset)
+	}
+
+	rows, err := db.QueryContext(db.context(), query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	col := []*todow.Item{}
+	var total int
+	for rows.Next() {
+		var (
+			id, parentID int64
+			data         []byte
+		)
+		if err := rows.Scan(&id, &parentID, &data, &total); err != nil {
+			return nil, 0, err
+		}
+		item, err := decodeItem(id, parentID, data)
+		if err != nil {
+			return nil, 0, err
+		}
+		col = append(col, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Paging beyond the last item leaves no row to carry the count.
+	if len(col) == 0 && q.Offset > 0 {
+		q.Limit, q.Offset = 0, 0
+		_, total, err = db.Find(q)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return col, total, nil
+}
+
+func (db *Postgres) Search(text string) ([]*todow.Item, error) {
+	query := terms(text)
+	if len(query) == 0 {
+		return []*todow.Item{}, nil
+	}
+
+	// Terms consist of letters and digits only, so they can't
+	// inject tsquery operators.
+	for i, t := range query {
+		query[i] = t + ":*"
+	}
+
+	return queryItems(db.context(), db, `
+		SELECT id, parent_id, data FROM items
+		WHERE owner = $1 AND `+pgSearchVector+` @@ to_tsquery('simple', $2)
+		ORDER BY id`, db.user, strings.Join(query, " & "))
+}
+
+func (db *Postgres) DueReminders(now time.Time) ([]*todow.Item, error) {
+	tx, err := db.BeginTx(db.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// SKIP LOCKED lets every server claim a disjoint set of reminders.
+	col, err := queryItems(db.context(), tx, `
+		SELECT id, parent_id, data FROM items
+		WHERE owner = $1 AND remind_at <= $2 AND NOT COALESCE((data->>'Done')::boolean, false)
+		ORDER BY id
+		FOR UPDATE SKIP LOCKED`, db.user, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range col {
+		v.RemindAt = time.Time{}
+		if err := saveItem(db.context(), tx, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return col, tx.Commit()
+}
+
+func (db *Postgres) Close() error {
+	if db.user != "" {
+		return nil
+	}
+	return db.DB.Close()
+}
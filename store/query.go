@@ -0,0 +1,301 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+// Query selects items. Zero fields don't restrict the result,
+// but archived items are only selected by Archived and items whose
+// start time is still ahead only by Deferred.
+type Query struct {
+	// Tags selects items tagged with all of the tags.
+	Tags []string
+
+	// Done selects items by completion state.
+	Done *bool
+
+	// CreatedAfter selects items created after the given time.
+	CreatedAfter time.Time
+
+	// CompletedSince selects items completed at or after the given time.
+	CompletedSince time.Time
+
+	// DueAfter and DueBefore select items due at or after and before
+	// the given times. Items without due date are never selected by them.
+	DueAfter, DueBefore time.Time
+
+	// Text selects items whose body contains the text, ignoring case.
+	Text string
+
+	// Priority selects items with this priority or a higher one.
+	Priority string
+
+	// Archived selects archived items instead of all others.
+	Archived bool
+
+	// Deferred selects items whose start time is still ahead, too.
+	Deferred bool
+
+	// Sort is the order of the result, one of the Sort constants.
+	// Desc reverses it. Items lacking the sort field come last either way,
+	// pinned items first.
+	Sort string
+	Desc bool
+
+	// Limit is the maximum number of items returned, Offset the number
+	// of matching items skipped before that.
+	Limit, Offset int
+}
+
+// Sort orders.
+const (
+	SortID       = "id"
+	SortCreated  = "created"
+	SortDue      = "due"
+	SortPriority = "priority"
+)
+
+// ValidSort reports whether s is a known sort order.
+// The empty string sorts by ID.
+func ValidSort(s string) bool {
+	switch s {
+	case "", SortID, SortCreated, SortDue, SortPriority:
+		return true
+	}
+	return false
+}
+
+// ParseQuery reads a query from URL query parameters:
+//
+//	tag              items tagged with all given tags, may be repeated
+//	done             items that are (true) or are not (false) done
+//	created_after    items created after the RFC 3339 time
+//	completed_since  items completed at or after the RFC 3339 time
+//	due_after        items due at or after the RFC 3339 time
+//	due_before       items due before the RFC 3339 time
+//	q                items whose body contains the text, ignoring case
+//	priority         items with the priority, A to Z, or a higher one
+//	archived         archived items instead of all others if true
+//	deferred         items whose start time is still ahead, too, if true
+//	sort             the order of items, id, created, due or priority
+//	order            asc or desc
+//	limit, offset    the page of matching items
+func ParseQuery(v url.Values) (Query, error) {
+	q := Query{
+		Tags: v["tag"],
+		Text: v.Get("q"),
+	}
+
+	q.Sort = v.Get("sort")
+	if !ValidSort(q.Sort) {
+		return q, fmt.Errorf("invalid sort %q", q.Sort)
+	}
+
+	switch v.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("invalid order %q", v.Get("order"))
+	}
+
+	if s := v.Get("archived"); s != "" {
+		var err error
+		if q.Archived, err = strconv.ParseBool(s); err != nil {
+			return q, fmt.Errorf("invalid archived %q", s)
+		}
+	}
+	if s := v.Get("deferred"); s != "" {
+		var err error
+		if q.Deferred, err = strconv.ParseBool(s); err != nil {
+			return q, fmt.Errorf("invalid deferred %q", s)
+		}
+	}
+
+	if s := v.Get("priority"); s != "" {
+		if !todow.ValidPriority(s) {
+			return q, fmt.Errorf("invalid priority %q", s)
+		}
+		q.Priority = s
+	}
+
+	if s := v.Get("done"); s != "" {
+		done, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid done %q", s)
+		}
+		q.Done = &done
+	}
+
+	for name, t := range map[string]*time.Time{
+		"created_after":   &q.CreatedAfter,
+		"completed_since": &q.CompletedSince,
+		"due_after":       &q.DueAfter,
+		"due_before":      &q.DueBefore,
+	} {
+		s := v.Get(name)
+		if s == "" {
+			continue
+		}
+		var err error
+		if *t, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid %s: %s", name, err)
+		}
+	}
+
+	for name, n := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+		s := v.Get(name)
+		if s == "" {
+			continue
+		}
+		var err error
+		*n, err = strconv.Atoi(s)
+		if err != nil || *n < 0 {
+			return q, fmt.Errorf("invalid %s %q", name, s)
+		}
+	}
+
+	return q, nil
+}
+
+// compare orders a and b by the sort order of q, after pinned items.
+// Ties are broken by ID.
+func (q *Query) compare(a, b *todow.Item) int {
+	if a.Pinned != b.Pinned {
+		return lastIf(b.Pinned)
+	}
+
+	var c int
+	switch q.Sort {
+	case SortCreated:
+		c = compareTimes(a.Created, b.Created)
+	case SortDue:
+		if a.Due.IsZero() != b.Due.IsZero() {
+			return lastIf(a.Due.IsZero())
+		}
+		c = compareTimes(a.Due, b.Due)
+	case SortPriority:
+		if (a.Priority == "") != (b.Priority == "") {
+			return lastIf(a.Priority == "")
+		}
+		c = strings.Compare(a.Priority, b.Priority)
+	}
+
+	if c == 0 {
+		c = compareInts(a.ID, b.ID)
+	}
+	if q.Desc {
+		c = -c
+	}
+	return c
+}
+
+// lastIf orders a after b if missing is true.
+func lastIf(missing bool) int {
+	if missing {
+		return 1
+	}
+	return -1
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Match reports whether item is selected by q.
+// Limit and Offset are ignored.
+func (q *Query) Match(item *todow.Item) bool {
+	for _, t := range q.Tags {
+		if !item.HasTag(t) {
+			return false
+		}
+	}
+	if item.Archived.IsZero() == q.Archived {
+		return false
+	}
+	if !q.Deferred && item.StartAt.After(time.Now()) {
+		return false
+	}
+	if q.Done != nil && item.Done != *q.Done {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && !item.Created.After(q.CreatedAfter) {
+		return false
+	}
+	if !q.CompletedSince.IsZero() && (!item.Done || item.CompletedAt.Before(q.CompletedSince)) {
+		return false
+	}
+	if !q.DueAfter.IsZero() && (item.Due.IsZero() || item.Due.Before(q.DueAfter)) {
+		return false
+	}
+	if !q.DueBefore.IsZero() && (item.Due.IsZero() || !item.Due.Before(q.DueBefore)) {
+		return false
+	}
+	if q.Priority != "" && (item.Priority == "" || item.Priority > q.Priority) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(item.Body), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// page collects the matching items of a query and returns the requested
+// range of them in order. It is used by stores filtering in memory.
+type page struct {
+	q     *Query
+	items []*todow.Item
+}
+
+func newPage(q *Query) *page {
+	return &page{q: q}
+}
+
+// add adds item to the page if it matches the query.
+func (p *page) add(item *todow.Item) {
+	if p.q.Match(item) {
+		p.items = append(p.items, item)
+	}
+}
+
+// result returns the requested range of the sorted items
+// and the number of all matching items.
+func (p *page) result() ([]*todow.Item, int) {
+	sort.SliceStable(p.items, func(i, j int) bool {
+		return p.q.compare(p.items[i], p.items[j]) < 0
+	})
+
+	total := len(p.items)
+	if p.q.Offset >= total {
+		return []*todow.Item{}, total
+	}
+
+	res := p.items[p.q.Offset:]
+	if p.q.Limit > 0 && p.q.Limit < len(res) {
+		res = res[:p.q.Limit]
+	}
+	return res, total
+}
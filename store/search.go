@@ -0,0 +1,48 @@
+package store
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/j1436go/todow"
+)
+
+// terms splits s into lower case words for searching.
+func terms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// itemTerms returns the distinct search terms of the body, notes and
+// tags of item.
+func itemTerms(item *todow.Item) []string {
+	seen := map[string]bool{}
+	var res []string
+	for _, t := range terms(item.Body + " " + item.Notes + " " + strings.Join(item.Tags, " ")) {
+		if !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// matchTerms reports whether every query term is a prefix
+// of one of the search terms of item.
+func matchTerms(item *todow.Item, query []string) bool {
+	words := itemTerms(item)
+	for _, q := range query {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
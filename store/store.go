@@ -0,0 +1,220 @@
+// Package store persists todow items.
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/j1436go/todow"
+)
+
+// Store is a persistent collection of items.
+type Store interface {
+	// Add assigns a new ID to item and stores it.
+	// It returns ErrNotFound if the parent of item does not exist.
+	Add(item *todow.Item) error
+
+	// Remove moves the item identified by id to the trash,
+	// setting its Deleted time. Children of the item move up
+	// to its parent.
+	Remove(id int64) error
+
+	// Trash returns the removed items, most recently removed first.
+	Trash() ([]*todow.Item, error)
+
+	// Restore moves the item identified by id out of the trash and
+	// returns it. If its parent is gone, it becomes a top level item.
+	Restore(id int64) (*todow.Item, error)
+
+	// Purge deletes the items removed before t for good
+	// and returns how many there were.
+	Purge(before time.Time) (int, error)
+
+	// Update calls fn with the item identified by id and stores the result.
+	// If cascade is not nil, it is called with every descendant of the item.
+	Update(id int64, fn, cascade func(*todow.Item)) error
+
+	// Get returns the item identified by id.
+	Get(id int64) (*todow.Item, error)
+
+	// All returns all items ordered by ID.
+	All() ([]*todow.Item, error)
+
+	// Find returns the items selected by q in the order of q.Sort,
+	// and the number of selected items ignoring q.Limit and q.Offset.
+	Find(q Query) ([]*todow.Item, int, error)
+
+	// Search returns the items whose body, notes or tags contain words
+	// starting with each word of text, ordered by ID.
+	Search(text string) ([]*todow.Item, error)
+
+	// DueReminders returns all open items whose reminder time is not after
+	// now and clears their reminder so it fires only once.
+	DueReminders(now time.Time) ([]*todow.Item, error)
+
+	// User returns the store of the named user's items, which shares
+	// the database with this store. The empty name denotes the default
+	// user, who owns all items created before there were users.
+	User(name string) (Store, error)
+
+	// WithContext returns the store with its operations bound to ctx:
+	// once ctx is done, they fail with its error instead of starting
+	// or running on, e.g. when the client of a request went away.
+	// Writes are either done completely or not at all.
+	WithContext(ctx context.Context) Store
+
+	// Close closes the database. Closing the store of a user other
+	// than the default one does nothing.
+	Close() error
+}
+
+// Open opens the store of the given kind, "bolt", "postgres" or "todotxt".
+// For bolt and todotxt, source is the path of the database file,
+// for postgres a connection string.
+func Open(kind, source string) (Store, error) {
+	switch strings.ToLower(kind) {
+	case "bolt":
+		return OpenBolt(source)
+	case "postgres":
+		return OpenPostgres(source)
+	case "todotxt":
+		return OpenTodoTxt(source)
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+// userNameRegexp matches valid user names. As the todotxt store keeps
+// a file per user, names must be safe to use in file names.
+var userNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func checkUserName(name string) error {
+	if !userNameRegexp.MatchString(name) || strings.Trim(name, ".") == "" {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	return nil
+}
+
+type ErrNotFound struct{}
+
+func (e ErrNotFound) Error() string { return "not found" }
+
+// OpenBlockers returns the IDs of the items item is blocked by
+// that are not done. Blockers that no longer exist are ignored.
+func OpenBlockers(s Store, item *todow.Item) ([]int64, error) {
+	var open []int64
+	for _, id := range item.BlockedBy {
+		blocker, err := s.Get(id)
+		switch err.(type) {
+		case ErrNotFound:
+			continue
+		case error:
+			return nil, err
+		}
+		if !blocker.Done {
+			open = append(open, id)
+		}
+	}
+	return open, nil
+}
+
+// BlockCycle returns the ID of an item of blockers that is, directly
+// or through other items, blocked by the item identified by id, or id
+// itself. Blocking the item by it would mean neither can be completed.
+// It returns 0 if there is none.
+func BlockCycle(s Store, id int64, blockers []int64) (int64, error) {
+	seen := map[int64]bool{}
+	for _, b := range blockers {
+		next := []int64{b}
+		for len(next) > 0 {
+			v := next[0]
+			next = next[1:]
+			if v == id {
+				return b, nil
+			}
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+
+			item, err := s.Get(v)
+			switch err.(type) {
+			case ErrNotFound:
+				continue
+			case error:
+				return 0, err
+			}
+			next = append(next, item.BlockedBy...)
+		}
+	}
+	return 0, nil
+}
+
+// Duplicates returns the open items of s whose body closely matches
+// body: at least four in five of the distinct words of both, ignoring
+// case and punctuation, are in each. Deferred items count as open.
+func Duplicates(s Store, body string) ([]*todow.Item, error) {
+	words := bodyWords(body)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	open := false
+	col, _, err := s.Find(Query{Done: &open, Deferred: true})
+	if err != nil {
+		return nil, err
+	}
+	var dups []*todow.Item
+	for _, item := range col {
+		other := bodyWords(item.Body)
+		shared := 0
+		for w := range words {
+			if other[w] {
+				shared++
+			}
+		}
+		// Shared words out of all distinct words of both.
+		if all := len(words) + len(other) - shared; shared*5 >= all*4 {
+			dups = append(dups, item)
+		}
+	}
+	return dups, nil
+}
+
+// bodyWords returns the distinct words of body in lower case,
+// without the punctuation around them.
+func bodyWords(body string) map[string]bool {
+	words := map[string]bool{}
+	for _, w := range strings.Fields(strings.ToLower(body)) {
+		w = strings.TrimFunc(w, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if w != "" {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// Descendants returns all items of col below the item identified by id.
+func Descendants(col []*todow.Item, id int64) []*todow.Item {
+	var res []*todow.Item
+	seen := map[int64]bool{id: true}
+	parents := map[int64]bool{id: true}
+	for len(parents) > 0 {
+		next := map[int64]bool{}
+		for _, v := range col {
+			if parents[v.ParentID] && !seen[v.ID] {
+				seen[v.ID] = true
+				res = append(res, v)
+				next[v.ID] = true
+			}
+		}
+		parents = next
+	}
+	return res
+}
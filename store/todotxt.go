@@ -0,0 +1,530 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/j1436go/todow"
+	"github.com/j1436go/todow/todotxt"
+)
+
+// TodoTxt is a Store backed by a plain todo.txt file. The file is read
+// once when opening the store and rewritten after every change.
+// Removed items are kept in a second file named like the first
+// with .trash appended, e.g. todo.txt.trash.
+type TodoTxt struct {
+	path string
+
+	mu    sync.Mutex
+	col   []*todow.Item
+	trash []*todow.Item
+
+	// users holds the opened stores of other users, keyed by name.
+	// It is shared by the stores of all users.
+	users *todoTxtUsers
+}
+
+type todoTxtUsers struct {
+	mu     sync.Mutex
+	byName map[string]*TodoTxt
+}
+
+// OpenTodoTxt loads the todo.txt file at path. A missing file is
+// created on the first change. Lines without an id: pair, e.g. those
+// written by other todo.txt clients, are assigned new IDs.
+func OpenTodoTxt(path string) (*TodoTxt, error) {
+	t := &TodoTxt{path: path, users: &todoTxtUsers{byName: map[string]*TodoTxt{}}}
+	t.users.byName[""] = t
+
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load reads the file and the trash, if they exist.
+func (t *TodoTxt) load() error {
+	var err error
+	if t.trash, err = readItems(t.path + ".trash"); err != nil {
+		return err
+	}
+	if t.col, err = readItems(t.path); err != nil {
+		return err
+	}
+
+	var assigned int
+	for _, v := range t.col {
+		if v.ID == 0 {
+			v.ID = t.nextID()
+			assigned++
+		}
+	}
+	if assigned > 0 {
+		slog.Info("assigned IDs to items", "file", t.path, "count", assigned)
+		return t.save()
+	}
+	return nil
+}
+
+// readItems reads the todo.txt file at path, if it exists.
+func readItems(path string) ([]*todow.Item, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return todotxt.Read(f)
+}
+
+// nextID returns an ID neither taken by an item nor by one in the
+// trash, so that trashed items can be restored.
+func (t *TodoTxt) nextID() int64 {
+	var id int64 = 1
+	for _, col := range [][]*todow.Item{t.col, t.trash} {
+		for _, v := range col {
+			if v.ID >= id {
+				id = v.ID + 1
+			}
+		}
+	}
+	return id
+}
+
+func (t *TodoTxt) find(id int64) *todow.Item {
+	for _, v := range t.col {
+		if v.ID == id {
+			return v
+		}
+	}
+	return nil
+}
+
+// save atomically replaces the file with the current collection.
+func (t *TodoTxt) save() error {
+	return writeItems(t.path, t.col)
+}
+
+// saveTrash atomically replaces the trash file with the current trash.
+func (t *TodoTxt) saveTrash() error {
+	return writeItems(t.path+".trash", t.trash)
+}
+
+func writeItems(path string, col []*todow.Item) error {
+	var buf bytes.Buffer
+	if err := todotxt.Write(&buf, col); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".todo.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyItem keeps callers from modifying the collection behind our back.
+func copyItem(item *todow.Item) *todow.Item {
+	c := *item
+	return &c
+}
+
+func (t *TodoTxt) Add(item *todow.Item) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if item.ParentID != 0 && t.find(item.ParentID) == nil {
+		return ErrNotFound{}
+	}
+
+	item.ID = t.nextID()
+	t.col = append(t.col, copyItem(item))
+	if err := t.save(); err != nil {
+		t.col = t.col[:len(t.col)-1]
+		return err
+	}
+
+	return nil
+}
+
+func (t *TodoTxt) Remove(id int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old := t.col
+	col := make([]*todow.Item, 0, len(old))
+
+	var removed *todow.Item
+	for _, v := range old {
+		if v.ID == id {
+			removed = v
+			continue
+		}
+		col = append(col, v)
+	}
+	if removed == nil {
+		return ErrNotFound{}
+	}
+
+	// Children move up to the parent of the removed item.
+	for i, v := range col {
+		if v.ParentID == id {
+			col[i] = copyItem(v)
+			col[i].ParentID = removed.ParentID
+		}
+	}
+
+	oldTrash := t.trash
+	removed = copyItem(removed)
+	removed.Deleted = time.Now()
+	t.trash = append(oldTrash[:len(oldTrash):len(oldTrash)], removed)
+	if err := t.saveTrash(); err != nil {
+		t.trash = oldTrash
+		return err
+	}
+
+	t.col = col
+	if err := t.save(); err != nil {
+		// The item wasn't removed, so it mustn't stay in the trash.
+		t.col, t.trash = old, oldTrash
+		t.saveTrash()
+		return err
+	}
+
+	return nil
+}
+
+func (t *TodoTxt) Trash() ([]*todow.Item, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	col := make([]*todow.Item, len(t.trash))
+	for i, v := range t.trash {
+		col[i] = copyItem(v)
+	}
+	sort.SliceStable(col, func(i, j int) bool { return col[i].Deleted.After(col[j].Deleted) })
+	return col, nil
+}
+
+func (t *TodoTxt) Restore(id int64) (*todow.Item, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oldTrash := t.trash
+	trash := make([]*todow.Item, 0, len(oldTrash))
+
+	var item *todow.Item
+	for _, v := range oldTrash {
+		if v.ID == id {
+			item = copyItem(v)
+			continue
+		}
+		trash = append(trash, v)
+	}
+	if item == nil {
+		return nil, ErrNotFound{}
+	}
+
+	if item.ParentID != 0 && t.find(item.ParentID) == nil {
+		item.ParentID = 0
+	}
+	item.Deleted = time.Time{}
+
+	old := t.col
+	t.col = append(old[:len(old):len(old)], item)
+	if err := t.save(); err != nil {
+		t.col = old
+		return nil, err
+	}
+
+	t.trash = trash
+	if err := t.saveTrash(); err != nil {
+		// The item is still in the trash, so it mustn't be restored.
+		t.col, t.trash = old, oldTrash
+		t.save()
+		return nil, err
+	}
+
+	return copyItem(item), nil
+}
+
+func (t *TodoTxt) Purge(before time.Time) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old := t.trash
+	trash := make([]*todow.Item, 0, len(old))
+	for _, v := range old {
+		if !v.Deleted.Before(before) {
+			trash = append(trash, v)
+		}
+	}
+	n := len(old) - len(trash)
+	if n == 0 {
+		return 0, nil
+	}
+
+	t.trash = trash
+	if err := t.saveTrash(); err != nil {
+		t.trash = old
+		return 0, err
+	}
+	return n, nil
+}
+
+func (t *TodoTxt) Update(id int64, fn, cascade func(*todow.Item)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old := t.col
+	col := make([]*todow.Item, len(old))
+	for i, v := range old {
+		col[i] = copyItem(v)
+	}
+
+	var item *todow.Item
+	for _, v := range col {
+		if v.ID == id {
+			item = v
+		}
+	}
+	if item == nil {
+		return ErrNotFound{}
+	}
+
+	fn(item)
+	if cascade != nil {
+		for _, d := range Descendants(col, id) {
+			cascade(d)
+		}
+	}
+
+	t.col = col
+	if err := t.save(); err != nil {
+		t.col = old
+		return err
+	}
+
+	return nil
+}
+
+func (t *TodoTxt) Get(id int64) (*todow.Item, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	item := t.find(id)
+	if item == nil {
+		return nil, ErrNotFound{}
+	}
+	return copyItem(item), nil
+}
+
+func (t *TodoTxt) All() ([]*todow.Item, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	col := make([]*todow.Item, len(t.col))
+	for i, v := range t.col {
+		col[i] = copyItem(v)
+	}
+	sort.Slice(col, func(i, j int) bool { return col[i].ID < col[j].ID })
+	return col, nil
+}
+
+func (t *TodoTxt) Find(q Query) ([]*todow.Item, int, error) {
+	col, _ := t.All()
+
+	p := newPage(&q)
+	for _, v := range col {
+		p.add(v)
+	}
+
+	col, total := p.result()
+	return col, total, nil
+}
+
+func (t *TodoTxt) Search(text string) ([]*todow.Item, error) {
+	query := terms(text)
+	if len(query) == 0 {
+		return []*todow.Item{}, nil
+	}
+
+	col, _ := t.All()
+	res := []*todow.Item{}
+	for _, v := range col {
+		if matchTerms(v, query) {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
+
+func (t *TodoTxt) DueReminders(now time.Time) ([]*todow.Item, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old := t.col
+	col := make([]*todow.Item, len(old))
+	copy(col, old)
+
+	var due []*todow.Item
+	for i, v := range col {
+		if v.Done || v.RemindAt.IsZero() || v.RemindAt.After(now) {
+			continue
+		}
+		col[i] = copyItem(v)
+		col[i].RemindAt = time.Time{}
+		due = append(due, copyItem(col[i]))
+	}
+
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	t.col = col
+	if err := t.save(); err != nil {
+		t.col = old
+		return nil, err
+	}
+	return due, nil
+}
+
+// User returns the store of the named user, which is kept in a file
+// next to that of the default user, named after the user,
+// e.g. todo.alice.txt for todo.txt.
+func (t *TodoTxt) User(name string) (Store, error) {
+	t.users.mu.Lock()
+	defer t.users.mu.Unlock()
+
+	if u, ok := t.users.byName[name]; ok {
+		return u, nil
+	}
+	if err := checkUserName(name); err != nil {
+		return nil, err
+	}
+
+	root := t.users.byName[""]
+	ext := filepath.Ext(root.path)
+	u := &TodoTxt{
+		path:  strings.TrimSuffix(root.path, ext) + "." + name + ext,
+		users: t.users,
+	}
+	if err := u.load(); err != nil {
+		return nil, err
+	}
+	t.users.byName[name] = u
+	return u, nil
+}
+
+func (t *TodoTxt) Close() error {
+	return nil
+}
+
+// WithContext returns the store bound to ctx. Operations hold the
+// items in memory and only check ctx before they start.
+func (t *TodoTxt) WithContext(ctx context.Context) Store {
+	return todoTxtContext{t, ctx}
+}
+
+// todoTxtContext is a TodoTxt bound to ctx.
+type todoTxtContext struct {
+	*TodoTxt
+	ctx context.Context
+}
+
+func (t todoTxtContext) Add(item *todow.Item) error {
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	return t.TodoTxt.Add(item)
+}
+
+func (t todoTxtContext) Remove(id int64) error {
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	return t.TodoTxt.Remove(id)
+}
+
+func (t todoTxtContext) Trash() ([]*todow.Item, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.TodoTxt.Trash()
+}
+
+func (t todoTxtContext) Restore(id int64) (*todow.Item, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.TodoTxt.Restore(id)
+}
+
+func (t todoTxtContext) Purge(before time.Time) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return t.TodoTxt.Purge(before)
+}
+
+func (t todoTxtContext) Update(id int64, fn, cascade func(*todow.Item)) error {
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	return t.TodoTxt.Update(id, fn, cascade)
+}
+
+func (t todoTxtContext) Get(id int64) (*todow.Item, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.TodoTxt.Get(id)
+}
+
+func (t todoTxtContext) All() ([]*todow.Item, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.TodoTxt.All()
+}
+
+func (t todoTxtContext) Find(q Query) ([]*todow.Item, int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	return t.TodoTxt.Find(q)
+}
+
+func (t todoTxtContext) Search(text string) ([]*todow.Item, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.TodoTxt.Search(text)
+}
+
+func (t todoTxtContext) DueReminders(now time.Time) ([]*todow.Item, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.TodoTxt.DueReminders(now)
+}
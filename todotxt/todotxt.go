@@ -0,0 +1,275 @@
+// Package todotxt reads and writes items in the todo.txt format,
+// see https://github.com/todotxt/todo.txt.
+//
+// Tags are written as +project words. Contexts (@word) and unknown
+// key:value pairs stay part of the body. Fields without a todo.txt
+// equivalent are written as key:value pairs:
+//
+//	due:2016-05-25 remind:2016-05-24T18:00 t:2016-05-20 id:3 parent:1 blocked:1,2
+//
+// The start date is written as the t: pair other todo.txt tools use
+// for threshold dates.
+//
+// Notes are written as a notes: pair, escaped like URL paths, and every
+// checklist entry as a check: pair, or a checked: pair if it is done.
+// Archived items carry an archived: pair, items in the trash a deleted: pair
+// and pinned items a pinned:yes pair. Every metadata field is written
+// as a meta: pair of its key and value, like meta:issue=GH-12.
+package todotxt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+const (
+	dateFormat     = "2006-01-02"
+	dateTimeFormat = "2006-01-02T15:04"
+)
+
+var priorityRegexp = regexp.MustCompile(`^\(([A-Z])\)$`)
+
+// Parse parses a single todo.txt line.
+// Items without an id: pair have an ID of 0.
+func Parse(line string) (*todow.Item, error) {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty line")
+	}
+
+	item := &todow.Item{}
+
+	if words[0] == "x" {
+		item.Done = true
+		words = words[1:]
+		if len(words) > 0 {
+			if t, err := parseTime(words[0]); err == nil {
+				item.CompletedAt = t
+				words = words[1:]
+			}
+		}
+	} else if m := priorityRegexp.FindStringSubmatch(words[0]); m != nil {
+		item.Priority = m[1]
+		words = words[1:]
+	}
+
+	if len(words) > 0 {
+		if t, err := parseTime(words[0]); err == nil {
+			item.Created = t
+			words = words[1:]
+		}
+	}
+
+	var body []string
+	for _, w := range words {
+		ok, err := parsePair(item, w)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			body = append(body, w)
+		}
+	}
+
+	item.Body, item.Tags = todow.ParseTags(body)
+	if item.Body == "" {
+		return nil, fmt.Errorf("missing body in %q", line)
+	}
+	return item, nil
+}
+
+// parsePair sets the field of item described by the key:value pair w.
+// It reports whether w was such a pair.
+func parsePair(item *todow.Item, w string) (bool, error) {
+	i := strings.Index(w, ":")
+	if i <= 0 || i == len(w)-1 {
+		return false, nil
+	}
+	key, val := w[:i], w[i+1:]
+
+	var err error
+	switch key {
+	case "id":
+		item.ID, err = strconv.ParseInt(val, 10, 64)
+	case "parent":
+		item.ParentID, err = strconv.ParseInt(val, 10, 64)
+	case "blocked":
+		for _, s := range strings.Split(val, ",") {
+			var id int64
+			if id, err = strconv.ParseInt(s, 10, 64); err != nil {
+				break
+			}
+			item.BlockedBy = append(item.BlockedBy, id)
+		}
+	case "due":
+		item.Due, err = parseTime(val)
+	case "remind":
+		item.RemindAt, err = parseTime(val)
+	case "t":
+		item.StartAt, err = parseTime(val)
+	case "notes":
+		item.Notes, err = url.PathUnescape(val)
+	case "check", "checked":
+		var text string
+		text, err = url.PathUnescape(val)
+		item.Checklist = append(item.Checklist, todow.CheckItem{Text: text, Done: key == "checked"})
+	case "meta":
+		i := strings.Index(val, "=")
+		if i < 0 || !todow.ValidMetaKey(val[:i]) {
+			return false, fmt.Errorf("invalid meta %q, use meta:KEY=VALUE", val)
+		}
+		var v string
+		if v, err = url.PathUnescape(val[i+1:]); err == nil {
+			if item.Meta == nil {
+				item.Meta = map[string]string{}
+			}
+			item.Meta[val[:i]] = v
+		}
+	case "pinned":
+		item.Pinned = val == "yes"
+	case "archived":
+		item.Archived, err = parseTime(val)
+	case "deleted":
+		item.Deleted, err = parseTime(val)
+	case "pri":
+		if len(val) != 1 || val[0] < 'A' || val[0] > 'Z' {
+			return false, fmt.Errorf("invalid priority %q", val)
+		}
+		item.Priority = val
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s", key, err)
+	}
+	return true, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(dateTimeFormat, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(dateFormat, s, time.Local)
+}
+
+// formatTime omits the time of day if it is midnight.
+func formatTime(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 {
+		return t.Format(dateFormat)
+	}
+	return t.Format(dateTimeFormat)
+}
+
+// Format formats item as a single todo.txt line.
+func Format(item *todow.Item) string {
+	var words []string
+
+	if item.Done {
+		words = append(words, "x")
+		if !item.CompletedAt.IsZero() {
+			words = append(words, item.CompletedAt.Format(dateFormat))
+		}
+	} else if item.Priority != "" {
+		words = append(words, "("+item.Priority+")")
+	}
+
+	if !item.Created.IsZero() {
+		// A creation date requires a completion date on done items.
+		if !item.Done || !item.CompletedAt.IsZero() {
+			words = append(words, item.Created.Format(dateFormat))
+		}
+	}
+
+	words = append(words, item.Body)
+	for _, t := range item.Tags {
+		words = append(words, todow.TagPrefix+t)
+	}
+
+	if item.Done && item.Priority != "" {
+		words = append(words, "pri:"+item.Priority)
+	}
+	if !item.Due.IsZero() {
+		words = append(words, "due:"+formatTime(item.Due))
+	}
+	if !item.RemindAt.IsZero() {
+		words = append(words, "remind:"+formatTime(item.RemindAt))
+	}
+	if !item.StartAt.IsZero() {
+		words = append(words, "t:"+formatTime(item.StartAt))
+	}
+	if item.ID != 0 {
+		words = append(words, "id:"+strconv.FormatInt(item.ID, 10))
+	}
+	if item.ParentID != 0 {
+		words = append(words, "parent:"+strconv.FormatInt(item.ParentID, 10))
+	}
+	if len(item.BlockedBy) > 0 {
+		words = append(words, "blocked:"+todow.FormatIDs(item.BlockedBy, ","))
+	}
+	if item.Notes != "" {
+		words = append(words, "notes:"+url.PathEscape(item.Notes))
+	}
+	for _, c := range item.Checklist {
+		key := "check:"
+		if c.Done {
+			key = "checked:"
+		}
+		words = append(words, key+url.PathEscape(c.Text))
+	}
+	if item.Pinned {
+		words = append(words, "pinned:yes")
+	}
+	keys := make([]string, 0, len(item.Meta))
+	for k := range item.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		words = append(words, "meta:"+k+"="+url.PathEscape(item.Meta[k]))
+	}
+	if !item.Archived.IsZero() {
+		words = append(words, "archived:"+formatTime(item.Archived))
+	}
+	if !item.Deleted.IsZero() {
+		words = append(words, "deleted:"+formatTime(item.Deleted))
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Read parses all non-empty lines of r.
+func Read(r io.Reader) ([]*todow.Item, error) {
+	var col []*todow.Item
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		item, err := Parse(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		col = append(col, item)
+	}
+	return col, s.Err()
+}
+
+// Write writes col to w, one item per line.
+func Write(w io.Writer, col []*todow.Item) error {
+	for _, v := range col {
+		if _, err := fmt.Fprintln(w, Format(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
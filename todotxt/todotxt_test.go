@@ -0,0 +1,116 @@
+package todotxt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/j1436go/todow"
+)
+
+func date(y int, m time.Month, d, hour, min int) time.Time {
+	return time.Date(y, m, d, hour, min, 0, 0, time.Local)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want *todow.Item
+	}{
+		{
+			"(A) 2016-05-20 call mom +family due:2016-05-25T18:30",
+			&todow.Item{Priority: "A", Created: date(2016, 5, 20, 0, 0), Body: "call mom", Tags: []string{"family"}, Due: date(2016, 5, 25, 18, 30)},
+		},
+		{
+			"x 2016-05-21 2016-05-20 file taxes pri:B",
+			&todow.Item{Done: true, CompletedAt: date(2016, 5, 21, 0, 0), Created: date(2016, 5, 20, 0, 0), Body: "file taxes", Priority: "B"},
+		},
+		{
+			"buy @store milk see:http://example.com remind:2016-05-23T08:00",
+			&todow.Item{Body: "buy @store milk see:http://example.com", RemindAt: date(2016, 5, 23, 8, 0)},
+		},
+		{"step id:3 parent:1", &todow.Item{ID: 3, ParentID: 1, Body: "step"}},
+		{"gone deleted:2016-05-02T10:00", &todow.Item{Body: "gone", Deleted: date(2016, 5, 2, 10, 0)}},
+		{"old archived:2016-05-01", &todow.Item{Body: "old", Archived: date(2016, 5, 1, 0, 0)}},
+		{"pack notes:socks%0Ashoes", &todow.Item{Body: "pack", Notes: "socks\nshoes"}},
+		{"pack check:bag checked:tickets", &todow.Item{Body: "pack", Checklist: []todow.CheckItem{{Text: "bag"}, {Text: "tickets", Done: true}}}},
+		{"wait blocked:1,2", &todow.Item{Body: "wait", BlockedBy: []int64{1, 2}}},
+		{"someday t:2016-05-24", &todow.Item{Body: "someday", StartAt: date(2016, 5, 24, 0, 0)}},
+		{"top pinned:yes", &todow.Item{Body: "top", Pinned: true}},
+		{"fix meta:issue=GH-12", &todow.Item{Body: "fix", Meta: map[string]string{"issue": "GH-12"}}},
+		{"", nil},
+		{"(A) +tag", nil},
+		{"x 2016-05-21", nil},
+		{"late due:tomorrow", nil},
+		{"odd id:three", nil},
+		{"odd pri:AB", nil},
+		{"odd blocked:1,x", nil},
+		{"odd meta:issue", nil},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.line)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %s", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		item *todow.Item
+		want string
+	}{
+		{&todow.Item{Body: "buy milk"}, "buy milk"},
+		{
+			&todow.Item{Priority: "A", Created: date(2016, 5, 20, 0, 0), Body: "call mom", Tags: []string{"family"}, Due: date(2016, 5, 25, 18, 30)},
+			"(A) 2016-05-20 call mom +family due:2016-05-25T18:30",
+		},
+		{
+			&todow.Item{Done: true, CompletedAt: date(2016, 5, 21, 12, 0), Created: date(2016, 5, 20, 0, 0), Body: "file taxes", Priority: "B"},
+			"x 2016-05-21 2016-05-20 file taxes pri:B",
+		},
+		// A creation date would be taken for the completion date.
+		{&todow.Item{Done: true, Created: date(2016, 5, 20, 0, 0), Body: "done"}, "x done"},
+		{&todow.Item{ID: 3, ParentID: 1, Body: "step", RemindAt: date(2016, 5, 23, 8, 0)}, "step remind:2016-05-23T08:00 id:3 parent:1"},
+		{&todow.Item{Body: "gone", Deleted: date(2016, 5, 2, 10, 0)}, "gone deleted:2016-05-02T10:00"},
+		{&todow.Item{Body: "old", Archived: date(2016, 5, 1, 0, 0)}, "old archived:2016-05-01"},
+		{&todow.Item{Body: "pack", Notes: "socks\nshoes"}, "pack notes:socks%0Ashoes"},
+		{&todow.Item{Body: "pack", Checklist: []todow.CheckItem{{Text: "the bag"}, {Text: "tickets", Done: true}}}, "pack check:the%20bag checked:tickets"},
+		{&todow.Item{Body: "wait", BlockedBy: []int64{1, 2}}, "wait blocked:1,2"},
+		{&todow.Item{Body: "someday", StartAt: date(2016, 5, 24, 0, 0)}, "someday t:2016-05-24"},
+		{&todow.Item{Body: "top", Pinned: true}, "top pinned:yes"},
+		{&todow.Item{Body: "fix", Meta: map[string]string{"z": "1", "issue": "GH-12"}}, "fix meta:issue=GH-12 meta:z=1"},
+		{
+			&todow.Item{
+				ID:        3,
+				ParentID:  1,
+				BlockedBy: []int64{1, 2},
+				Body:      "pack",
+				Notes:     "socks\nshoes",
+				Checklist: []todow.CheckItem{{Text: "bag"}},
+				Pinned:    true,
+				Meta:      map[string]string{"issue": "GH-12"},
+				Archived:  date(2016, 5, 1, 0, 0),
+			},
+			"pack id:3 parent:1 blocked:1,2 notes:socks%0Ashoes check:bag pinned:yes meta:issue=GH-12 archived:2016-05-01",
+		},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.item); got != tt.want {
+			t.Errorf("Format(%+v) = %q, want %q", tt.item, got, tt.want)
+		}
+		if _, err := Parse(tt.want); err != nil {
+			t.Errorf("Parse(%q) failed: %s", tt.want, err)
+		}
+	}
+}
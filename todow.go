@@ -1,17 +1,347 @@
 package todow
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
 
 const (
-	HTTPUser     = "todow"
-	HTTPPassword = "todow"
+	// DefaultUser is the name of the user owning the items
+	// created before there were user accounts.
+	DefaultUser = "todow"
 
 	APIPath = "/api/"
+
+	// DueFormat is the layout used to read and print due dates.
+	DueFormat = "2006-01-02 15:04"
+
+	// TagPrefix marks a word as a tag, e.g. "+shopping".
+	TagPrefix = "+"
 )
 
 type Item struct {
-	ID      int64
-	Body    string
-	Created time.Time
-	Done    bool
+	ID       int64
+	ParentID int64
+
+	// Body is the one-line title of the item,
+	// Notes are its details of any length.
+	Body  string
+	Notes string
+
+	// Checklist holds steps too small to be items of their own,
+	// like the things to pack for a trip.
+	Checklist []CheckItem
+
+	// BlockedBy are the IDs of the items that need to be done
+	// before this one can be completed.
+	BlockedBy []int64
+
+	Created     time.Time
+	Due         time.Time
+	RemindAt    time.Time
+	Done        bool
+	CompletedAt time.Time
+	Tags        []string
+
+	// Pinned items are listed before all others, whatever the order.
+	Pinned bool
+
+	// Meta holds fields of integrations, like the ID of an issue
+	// the item tracks, by keys valid for ValidMetaKey.
+	Meta map[string]string
+
+	// StartAt defers the item, which is left out of listings until
+	// then unless deferred items are asked for. It is zero for items
+	// that can be worked on right away.
+	StartAt time.Time
+
+	// Priority ranks items from "A" (highest) to "Z" (lowest) like
+	// todo.txt does. Items without priority rank below all others.
+	Priority string
+
+	// Archived is when the item was archived, which keeps it out of
+	// listings unless archived items are asked for. It is zero for
+	// items that aren't archived.
+	Archived time.Time
+
+	// Deleted is when the item was moved to the trash,
+	// zero for items that weren't removed.
+	Deleted time.Time
+}
+
+// A CheckItem is an entry of the checklist of an item.
+type CheckItem struct {
+	Text string
+	Done bool
+}
+
+// FormatChecklist formats list one entry per line,
+// prefixed by "[x] " if it is done and by "[ ] " otherwise.
+func FormatChecklist(list []CheckItem) string {
+	lines := make([]string, len(list))
+	for i, c := range list {
+		box := "[ ] "
+		if c.Done {
+			box = "[x] "
+		}
+		lines[i] = box + c.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseCheckItem parses a line formatted by FormatChecklist.
+// It reports false if the line does not start with a check box.
+func ParseCheckItem(line string) (CheckItem, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) < 3 || line[0] != '[' || line[2] != ']' {
+		return CheckItem{}, false
+	}
+	switch line[1] {
+	case ' ':
+		return CheckItem{Text: strings.TrimSpace(line[3:])}, true
+	case 'x', 'X':
+		return CheckItem{Text: strings.TrimSpace(line[3:]), Done: true}, true
+	}
+	return CheckItem{}, false
+}
+
+// ParseChecklist parses the lines of s formatted by FormatChecklist.
+// Lines without a check box are entries that are not done,
+// empty lines are skipped.
+func ParseChecklist(s string) []CheckItem {
+	var list []CheckItem
+	for _, line := range strings.Split(s, "\n") {
+		c, ok := ParseCheckItem(line)
+		if !ok {
+			c = CheckItem{Text: strings.TrimSpace(line)}
+		}
+		if c.Text != "" {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// SetDone sets the completion state of the item
+// and records when it was completed.
+func (i *Item) SetDone(done bool) {
+	switch {
+	case done && !i.Done:
+		i.CompletedAt = time.Now()
+	case !done:
+		i.CompletedAt = time.Time{}
+	}
+	i.Done = done
+}
+
+// SetArchived archives the item or takes it out of the archive
+// and records when it was archived.
+func (i *Item) SetArchived(archived bool) {
+	switch {
+	case archived && i.Archived.IsZero():
+		i.Archived = time.Now()
+	case !archived:
+		i.Archived = time.Time{}
+	}
+}
+
+// NestedItem is an item together with its depth below the top level.
+type NestedItem struct {
+	*Item
+	Depth int
+}
+
+// Nest orders col depth-first so that every item is directly followed by
+// its children. Items whose parent is not part of col are treated as top
+// level items.
+func Nest(col []*Item) []NestedItem {
+	ids := make(map[int64]bool, len(col))
+	for _, v := range col {
+		ids[v.ID] = true
+	}
+
+	children := map[int64][]*Item{}
+	var roots []*Item
+	for _, v := range col {
+		if v.ParentID == 0 || !ids[v.ParentID] {
+			roots = append(roots, v)
+			continue
+		}
+		children[v.ParentID] = append(children[v.ParentID], v)
+	}
+
+	res := make([]NestedItem, 0, len(col))
+	var walk func(items []*Item, depth int)
+	walk = func(items []*Item, depth int) {
+		for _, v := range items {
+			res = append(res, NestedItem{v, depth})
+			walk(children[v.ID], depth+1)
+		}
+	}
+	walk(roots, 0)
+
+	return res
+}
+
+// HasTag reports whether the item is tagged with tag.
+func (i *Item) HasTag(tag string) bool {
+	for _, t := range i.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTags separates tag words from the remaining words
+// and returns the remaining words joined by spaces.
+func ParseTags(words []string) (string, []string) {
+	var body, tags []string
+	for _, w := range words {
+		if strings.HasPrefix(w, TagPrefix) && len(w) > len(TagPrefix) {
+			tags = append(tags, strings.TrimPrefix(w, TagPrefix))
+			continue
+		}
+		body = append(body, w)
+	}
+	return strings.Join(body, " "), tags
+}
+
+// ItemPatch is the body of a PATCH request.
+// Nil fields are left untouched.
+type ItemPatch struct {
+	Body  *string
+	Notes *string
+	// Checklist replaces the whole checklist.
+	Checklist *[]CheckItem
+	Tags      *[]string
+	Due       *time.Time
+	RemindAt  *time.Time
+	StartAt   *time.Time
+	Done      *bool
+	Priority  *string
+	Archived  *bool
+	Pinned    *bool
+	// Meta sets the given metadata fields, those set to ""
+	// are removed. The other fields are left untouched.
+	Meta map[string]string
+	// BlockedBy replaces the IDs of the blocking items.
+	BlockedBy *[]int64
+}
+
+// Apply applies the non-nil fields of p to item.
+func (p *ItemPatch) Apply(item *Item) {
+	if p.Body != nil {
+		item.Body = *p.Body
+	}
+	if p.Notes != nil {
+		item.Notes = *p.Notes
+	}
+	if p.Checklist != nil {
+		item.Checklist = *p.Checklist
+	}
+	if p.Pinned != nil {
+		item.Pinned = *p.Pinned
+	}
+	if p.BlockedBy != nil {
+		item.BlockedBy = *p.BlockedBy
+	}
+	if len(p.Meta) > 0 {
+		// The map is copied, as item may be a shallow copy of another.
+		meta := map[string]string{}
+		for k, v := range item.Meta {
+			meta[k] = v
+		}
+		for k, v := range p.Meta {
+			if v == "" {
+				delete(meta, k)
+				continue
+			}
+			meta[k] = v
+		}
+		item.Meta = nil
+		if len(meta) > 0 {
+			item.Meta = meta
+		}
+	}
+	if p.Tags != nil {
+		item.Tags = *p.Tags
+	}
+	if p.RemindAt != nil {
+		item.RemindAt = *p.RemindAt
+	}
+	if p.StartAt != nil {
+		item.StartAt = *p.StartAt
+	}
+	if p.Done != nil {
+		item.SetDone(*p.Done)
+	}
+	if p.Due != nil {
+		item.Due = *p.Due
+	}
+	if p.Priority != nil {
+		item.Priority = *p.Priority
+	}
+	if p.Archived != nil {
+		item.SetArchived(*p.Archived)
+	}
+}
+
+// Bulk is the body of a bulk request, which removes the items
+// identified by IDs or applies Patch to all of them.
+type Bulk struct {
+	IDs    []int64
+	Remove bool       `json:",omitempty"`
+	Patch  *ItemPatch `json:",omitempty"`
+}
+
+// FormatIDs formats ids separated by sep.
+func FormatIDs(ids []int64, sep string) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(s, sep)
+}
+
+// ValidPriority reports whether p is a priority from "A" to "Z",
+// or empty for none.
+func ValidPriority(p string) bool {
+	return p == "" || len(p) == 1 && p >= "A" && p <= "Z"
+}
+
+// ValidMetaKey reports whether k may be a key of the metadata of
+// items, a non-empty word of letters, digits, ".", "-" and "_".
+func ValidMetaKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for _, r := range k {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(".-_", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckMeta returns an error if a key of m is not valid.
+func CheckMeta(m map[string]string) error {
+	for k := range m {
+		if !ValidMetaKey(k) {
+			return fmt.Errorf("invalid metadata key %q, use letters, digits, ., - and _", k)
+		}
+	}
+	return nil
+}
+
+// FormatDays formats d in days and hours, or smaller units below a day.
+func FormatDays(d time.Duration) string {
+	if d < 24*time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	days := d / (24 * time.Hour)
+	return fmt.Sprintf("%dd%dh", days, (d-days*24*time.Hour)/time.Hour)
 }